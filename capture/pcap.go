@@ -0,0 +1,523 @@
+// Package capture reads pcap and pcapng files and reassembles the TCP streams
+// of a LuxPower dongle into frames.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+const (
+	PCAP_MAGIC_MICRO = 0xA1B2C3D4
+	PCAP_MAGIC_NANO  = 0xA1B23C4D
+	PCAPNG_SECTION   = 0x0A0D0D0A
+	PCAPNG_BYTEORDER = 0x1A2B3C4D
+)
+
+const (
+	PCAPNG_INTERFACE       = 0x00000001
+	PCAPNG_PACKET_OBSOLETE = 0x00000002
+	PCAPNG_PACKET_SIMPLE   = 0x00000003
+	PCAPNG_PACKET_ENHANCED = 0x00000006
+)
+
+const (
+	LINKTYPE_NULL     = 0
+	LINKTYPE_ETHERNET = 1
+	LINKTYPE_RAW_BSD  = 12
+	LINKTYPE_RAW      = 101
+	LINKTYPE_LOOP     = 108
+	LINKTYPE_SLL      = 113
+	LINKTYPE_IPV4     = 228
+	LINKTYPE_IPV6     = 229
+	LINKTYPE_SLL2     = 276
+)
+
+const (
+	TCP_FLAG_FIN = 0x01
+	TCP_FLAG_SYN = 0x02
+	TCP_FLAG_RST = 0x04
+)
+
+// Out of order segments kept per stream before giving up on a missing one
+const MAX_PENDING_SEGMENTS = 64
+
+type Packet struct {
+	Time     time.Time
+	LinkType uint32
+	Data     []byte
+}
+
+// PacketReader returns the packets of a capture file one at a time and
+// io.EOF after the last one.
+type PacketReader interface {
+	ReadPacket() (Packet, error)
+}
+
+// NewPacketReader detects whether r holds a pcap or a pcapng capture and
+// returns a reader for it.
+func NewPacketReader(r io.Reader) (PacketReader, error) {
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case binary.LittleEndian.Uint32(magic) == PCAPNG_SECTION:
+		return &pcapngReader{reader: reader}, nil
+	case binary.LittleEndian.Uint32(magic) == PCAP_MAGIC_MICRO || binary.LittleEndian.Uint32(magic) == PCAP_MAGIC_NANO:
+		return newPcapReader(reader, binary.LittleEndian)
+	case binary.BigEndian.Uint32(magic) == PCAP_MAGIC_MICRO || binary.BigEndian.Uint32(magic) == PCAP_MAGIC_NANO:
+		return newPcapReader(reader, binary.BigEndian)
+	}
+
+	return nil, fmt.Errorf("unknown capture format %X", magic)
+}
+
+type pcapReader struct {
+	reader   io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+}
+
+func newPcapReader(reader io.Reader, order binary.ByteOrder) (*pcapReader, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	return &pcapReader{
+		reader:   reader,
+		order:    order,
+		nano:     order.Uint32(header[0:4]) == PCAP_MAGIC_NANO,
+		linkType: order.Uint32(header[20:24]) & 0xFFFF,
+	}, nil
+}
+
+func (pcap *pcapReader) ReadPacket() (Packet, error) {
+	record := make([]byte, 16)
+	if _, err := io.ReadFull(pcap.reader, record); err != nil {
+		return Packet{}, err
+	}
+
+	seconds := int64(pcap.order.Uint32(record[0:4]))
+	fraction := int64(pcap.order.Uint32(record[4:8]))
+	length := pcap.order.Uint32(record[8:12])
+	if length > math.MaxUint16*4 {
+		return Packet{}, fmt.Errorf("pcap record too large: %d", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(pcap.reader, data); err != nil {
+		return Packet{}, unexpected(err)
+	}
+
+	if !pcap.nano {
+		fraction *= 1000
+	}
+
+	return Packet{Time: time.Unix(seconds, fraction), LinkType: pcap.linkType, Data: data}, nil
+}
+
+type pcapngInterface struct {
+	linkType   uint32
+	resolution uint8
+}
+
+type pcapngReader struct {
+	reader     io.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterface
+}
+
+func (pcapng *pcapngReader) ReadPacket() (Packet, error) {
+	for {
+		blockType, body, err := pcapng.readBlock()
+		if err != nil {
+			return Packet{}, err
+		}
+
+		switch blockType {
+		case PCAPNG_SECTION:
+			pcapng.interfaces = nil
+		case PCAPNG_INTERFACE:
+			if len(body) < 8 {
+				return Packet{}, errors.New("pcapng interface block too short")
+			}
+			pcapng.interfaces = append(pcapng.interfaces, pcapngInterface{
+				linkType:   uint32(pcapng.order.Uint16(body[0:2])),
+				resolution: pcapng.resolution(body[8:]),
+			})
+		case PCAPNG_PACKET_ENHANCED:
+			if len(body) < 20 {
+				return Packet{}, errors.New("pcapng packet block too short")
+			}
+			id := pcapng.order.Uint32(body[0:4])
+			timestamp := uint64(pcapng.order.Uint32(body[4:8]))<<32 | uint64(pcapng.order.Uint32(body[8:12]))
+			length := pcapng.order.Uint32(body[12:16])
+			return pcapng.packet(id, timestamp, body[20:], length)
+		case PCAPNG_PACKET_OBSOLETE:
+			if len(body) < 20 {
+				return Packet{}, errors.New("pcapng packet block too short")
+			}
+			id := uint32(pcapng.order.Uint16(body[0:2]))
+			timestamp := uint64(pcapng.order.Uint32(body[4:8]))<<32 | uint64(pcapng.order.Uint32(body[8:12]))
+			length := pcapng.order.Uint32(body[12:16])
+			return pcapng.packet(id, timestamp, body[20:], length)
+		case PCAPNG_PACKET_SIMPLE:
+			if len(body) < 4 {
+				return Packet{}, errors.New("pcapng packet block too short")
+			}
+			// Simple packets carry no timestamp
+			packet, err := pcapng.packet(0, 0, body[4:], pcapng.order.Uint32(body[0:4]))
+			packet.Time = time.Time{}
+			return packet, err
+		}
+	}
+}
+
+func (pcapng *pcapngReader) packet(id uint32, timestamp uint64, data []byte, length uint32) (Packet, error) {
+	if int(id) >= len(pcapng.interfaces) {
+		return Packet{}, fmt.Errorf("pcapng packet for unknown interface %d", id)
+	}
+
+	if uint32(len(data)) > length {
+		data = data[:length]
+	}
+
+	iface := pcapng.interfaces[id]
+	var when time.Time
+	if iface.resolution&0x80 == 0 {
+		unit := uint64(1)
+		for i := uint8(0); i < iface.resolution && i < 19; i++ {
+			unit *= 10
+		}
+		when = time.Unix(int64(timestamp/unit), int64((timestamp%unit)*uint64(time.Second)/unit))
+	} else {
+		shift := iface.resolution & 0x7F
+		when = time.Unix(int64(timestamp>>shift), int64((timestamp&(1<<shift-1))*uint64(time.Second)>>shift))
+	}
+
+	return Packet{Time: when, LinkType: iface.linkType, Data: data}, nil
+}
+
+// resolution finds the if_tsresol option of an interface block, the default
+// resolution is microseconds.
+func (pcapng *pcapngReader) resolution(options []byte) uint8 {
+	for len(options) >= 4 {
+		code := pcapng.order.Uint16(options[0:2])
+		length := int(pcapng.order.Uint16(options[2:4]))
+		if code == 0 || len(options) < 4+length {
+			break
+		}
+		if code == 9 && length >= 1 {
+			return options[4]
+		}
+		options = options[4+(length+3)&^3:]
+	}
+
+	return 6
+}
+
+func (pcapng *pcapngReader) readBlock() (uint32, []byte, error) {
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(pcapng.reader, head); err != nil {
+		return 0, nil, err
+	}
+
+	if binary.LittleEndian.Uint32(head[0:4]) == PCAPNG_SECTION {
+		// The byte order of a section is given by the magic following its length
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(pcapng.reader, magic); err != nil {
+			return 0, nil, unexpected(err)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == PCAPNG_BYTEORDER:
+			pcapng.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == PCAPNG_BYTEORDER:
+			pcapng.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("invalid pcapng byte order magic %X", magic)
+		}
+		body, err := pcapng.readBody(pcapng.order.Uint32(head[4:8]), 12)
+		return PCAPNG_SECTION, append(magic, body...), err
+	}
+
+	if pcapng.order == nil {
+		return 0, nil, errors.New("pcapng block outside of a section")
+	}
+
+	body, err := pcapng.readBody(pcapng.order.Uint32(head[4:8]), 8)
+	return pcapng.order.Uint32(head[0:4]), body, err
+}
+
+func (pcapng *pcapngReader) readBody(length uint32, consumed uint32) ([]byte, error) {
+	if length < consumed+4 || length%4 != 0 || length > math.MaxUint16*16 {
+		return nil, fmt.Errorf("invalid pcapng block length %d", length)
+	}
+
+	body := make([]byte, length-consumed)
+	if _, err := io.ReadFull(pcapng.reader, body); err != nil {
+		return nil, unexpected(err)
+	}
+
+	// Drop the trailing copy of the block length
+	return body[:len(body)-4], nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type Segment struct {
+	Source      net.TCPAddr
+	Destination net.TCPAddr
+	Sequence    uint32
+	Flags       uint8
+	Payload     []byte
+}
+
+// DecodeSegment strips the link, IP and TCP headers off a captured packet.
+// It returns false for anything that is not a TCP segment.
+func DecodeSegment(packet Packet) (Segment, bool) {
+	data := packet.Data
+	switch packet.LinkType {
+	case LINKTYPE_NULL, LINKTYPE_LOOP:
+		if len(data) < 4 {
+			return Segment{}, false
+		}
+		data = data[4:]
+	case LINKTYPE_ETHERNET:
+		if len(data) < 14 {
+			return Segment{}, false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		for (etherType == 0x8100 || etherType == 0x88A8) && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+	case LINKTYPE_SLL:
+		if len(data) < 16 {
+			return Segment{}, false
+		}
+		data = data[16:]
+	case LINKTYPE_SLL2:
+		if len(data) < 20 {
+			return Segment{}, false
+		}
+		data = data[20:]
+	case LINKTYPE_RAW, LINKTYPE_RAW_BSD, LINKTYPE_IPV4, LINKTYPE_IPV6:
+	default:
+		return Segment{}, false
+	}
+
+	if len(data) < 1 {
+		return Segment{}, false
+	}
+
+	var source, destination net.IP
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return Segment{}, false
+		}
+		headerLength := int(data[0]&0x0F) * 4
+		totalLength := int(binary.BigEndian.Uint16(data[2:4]))
+		fragment := binary.BigEndian.Uint16(data[6:8])
+		if data[9] != 6 || headerLength < 20 || totalLength < headerLength || len(data) < totalLength || fragment&0x3FFF != 0 {
+			return Segment{}, false
+		}
+		source = net.IP(data[12:16])
+		destination = net.IP(data[16:20])
+		data = data[headerLength:totalLength]
+	case 6:
+		if len(data) < 40 {
+			return Segment{}, false
+		}
+		payloadLength := int(binary.BigEndian.Uint16(data[4:6]))
+		next := data[6]
+		source = net.IP(data[8:24])
+		destination = net.IP(data[24:40])
+		if len(data) < 40+payloadLength {
+			return Segment{}, false
+		}
+		data = data[40 : 40+payloadLength]
+		// Hop-by-hop, routing and destination options headers
+		for next == 0 || next == 43 || next == 60 {
+			if len(data) < 8 || len(data) < int(data[1]+1)*8 {
+				return Segment{}, false
+			}
+			next, data = data[0], data[int(data[1]+1)*8:]
+		}
+		if next != 6 {
+			return Segment{}, false
+		}
+	default:
+		return Segment{}, false
+	}
+
+	if len(data) < 20 {
+		return Segment{}, false
+	}
+
+	offset := int(data[12]>>4) * 4
+	if offset < 20 || len(data) < offset {
+		return Segment{}, false
+	}
+
+	return Segment{
+		Source:      net.TCPAddr{IP: source, Port: int(binary.BigEndian.Uint16(data[0:2]))},
+		Destination: net.TCPAddr{IP: destination, Port: int(binary.BigEndian.Uint16(data[2:4]))},
+		Sequence:    binary.BigEndian.Uint32(data[4:8]),
+		Flags:       data[13],
+		Payload:     data[offset:],
+	}, true
+}
+
+type pendingSegment struct {
+	payload []byte
+	when    time.Time
+}
+
+type tcpStream struct {
+	started bool
+	next    uint32
+	pending map[uint32]pendingSegment
+	frames  luxproto.FrameBuffer
+}
+
+// Reassembler puts the TCP streams sent from Port back in order and splits
+// them into frames with the same framing used for live connections.
+type Reassembler struct {
+	Port    int
+	Frame   func(frame []byte, when time.Time)
+	streams map[string]*tcpStream
+}
+
+func (re *Reassembler) Add(packet Packet) {
+	segment, ok := DecodeSegment(packet)
+	if !ok || segment.Source.Port != re.Port {
+		return
+	}
+
+	if re.streams == nil {
+		re.streams = make(map[string]*tcpStream)
+	}
+
+	key := segment.Source.String() + ">" + segment.Destination.String()
+	stream := re.streams[key]
+	if stream == nil || segment.Flags&TCP_FLAG_SYN != 0 {
+		if stream != nil {
+			stream.frames.Flush(packet.Time, re.Frame)
+		}
+		stream = &tcpStream{pending: make(map[uint32]pendingSegment)}
+		re.streams[key] = stream
+	}
+
+	if segment.Flags&TCP_FLAG_SYN != 0 {
+		stream.started = true
+		stream.next = segment.Sequence + 1
+		return
+	}
+
+	if !stream.started {
+		// Capture started in the middle of the connection
+		stream.started = true
+		stream.next = segment.Sequence
+	}
+
+	if len(segment.Payload) > 0 {
+		re.segment(stream, segment.Sequence, segment.Payload, packet.Time)
+	}
+
+	if segment.Flags&(TCP_FLAG_FIN|TCP_FLAG_RST) != 0 {
+		re.close(stream, packet.Time)
+		delete(re.streams, key)
+	}
+}
+
+// Flush emits everything still buffered, skipping over missing segments.
+func (re *Reassembler) Flush() {
+	for key, stream := range re.streams {
+		re.close(stream, time.Time{})
+		delete(re.streams, key)
+	}
+}
+
+func (re *Reassembler) segment(stream *tcpStream, sequence uint32, payload []byte, when time.Time) {
+	if int32(sequence-stream.next) > 0 {
+		if _, ok := stream.pending[sequence]; !ok {
+			stream.pending[sequence] = pendingSegment{payload: append([]byte(nil), payload...), when: when}
+		}
+		if len(stream.pending) > MAX_PENDING_SEGMENTS {
+			re.skipGap(stream)
+		}
+		return
+	}
+
+	re.deliver(stream, sequence, payload, when)
+	re.drain(stream)
+}
+
+// deliver passes the part of a segment that has not been seen yet on to the
+// framer.
+func (re *Reassembler) deliver(stream *tcpStream, sequence uint32, payload []byte, when time.Time) {
+	overlap := stream.next - sequence
+	if overlap >= uint32(len(payload)) {
+		return
+	}
+
+	stream.frames.Write(payload[overlap:], when, re.Frame)
+	stream.next = sequence + uint32(len(payload))
+}
+
+func (re *Reassembler) drain(stream *tcpStream) {
+	for found := true; found; {
+		found = false
+		for sequence, pending := range stream.pending {
+			if int32(sequence-stream.next) <= 0 {
+				delete(stream.pending, sequence)
+				re.deliver(stream, sequence, pending.payload, pending.when)
+				found = true
+			}
+		}
+	}
+}
+
+// skipGap gives up on missing data and continues at the earliest segment
+// that was received. Partial frames in front of the gap are dropped.
+func (re *Reassembler) skipGap(stream *tcpStream) {
+	first, found := uint32(0), false
+	for sequence := range stream.pending {
+		if !found || int32(sequence-first) < 0 {
+			first, found = sequence, true
+		}
+	}
+
+	if found {
+		stream.frames.Reset()
+		stream.next = first
+		re.drain(stream)
+	}
+}
+
+func (re *Reassembler) close(stream *tcpStream, when time.Time) {
+	for len(stream.pending) > 0 {
+		re.skipGap(stream)
+	}
+	stream.frames.Flush(when, re.Frame)
+}
@@ -0,0 +1,155 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+type importedFrame struct {
+	register uint16
+	when     time.Time
+}
+
+func importFile(t *testing.T, name string) []importedFrame {
+	t.Helper()
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := NewPacketReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := []importedFrame{}
+	reassembler := Reassembler{
+		Port: 8000,
+		Frame: func(frame []byte, when time.Time) {
+			_, data, err := luxproto.ParseFrame(frame)
+			if err != nil {
+				t.Errorf("frame at %v: %v", when, err)
+				return
+			}
+			msg, err := luxproto.ParseMessage(data)
+			if err != nil {
+				t.Errorf("frame at %v: %v", when, err)
+				return
+			}
+			frames = append(frames, importedFrame{msg.Register, when})
+		},
+	}
+	for {
+		packet, err := reader.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		reassembler.Add(packet)
+	}
+	reassembler.Flush()
+	return frames
+}
+
+// The fixtures hold the input register blocks 0, 40 and 80 sent by a dongle
+// on port 8000, one packet a second from 10:00:00.25 UTC on 2024-06-01.
+func TestImport(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 250_000_000, time.UTC)
+	at := func(packet int) time.Time {
+		return start.Add(time.Duration(packet) * time.Second)
+	}
+
+	tests := []struct {
+		name     string
+		expected []importedFrame
+	}{
+		// SYN, a request from the client, the second block split over two
+		// segments and FIN
+		{"in_order_le.pcap", []importedFrame{{0, at(2)}, {40, at(4)}, {80, at(5)}}},
+		{"in_order_be_nano.pcap", []importedFrame{{0, at(2)}, {40, at(4)}, {80, at(5)}}},
+		// Segments out of order, a retransmission and an overlapping one
+		{"reordered_le.pcapng", []importedFrame{{0, at(1)}, {40, at(2)}, {80, at(6)}}},
+		// Started in the middle of the first block with a gap in the second,
+		// in big endian with nanosecond timestamps
+		{"gap_be.pcapng", []importedFrame{{80, at(3)}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frames := importFile(t, "testdata/"+test.name)
+			if len(frames) != len(test.expected) {
+				t.Fatalf("imported %v, expected %v", frames, test.expected)
+			}
+			for i, frame := range frames {
+				if frame.register != test.expected[i].register || !frame.when.Equal(test.expected[i].when) {
+					t.Errorf("frame %d is %v, expected %v", i, frame, test.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPacketReaderErrors(t *testing.T) {
+	if _, err := NewPacketReader(bytes.NewReader([]byte{1, 2, 3, 4, 5, 6})); err == nil {
+		t.Error("unknown format accepted")
+	}
+
+	capture, err := os.ReadFile("testdata/in_order_le.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewPacketReader(bytes.NewReader(capture[:len(capture)-10]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err = reader.ReadPacket()
+		if err != nil {
+			break
+		}
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated capture ended with %v", err)
+	}
+}
+
+func TestDecodeSegment(t *testing.T) {
+	capture, err := os.ReadFile("testdata/in_order_le.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewPacketReader(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := reader.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segment, ok := DecodeSegment(packet)
+	if !ok {
+		t.Fatal("SYN not decoded")
+	}
+	if segment.Source.String() != "192.168.1.50:8000" || segment.Destination.String() != "192.168.1.10:50000" {
+		t.Errorf("segment from %v to %v", &segment.Source, &segment.Destination)
+	}
+	if segment.Sequence != 1000 || segment.Flags&TCP_FLAG_SYN == 0 || len(segment.Payload) != 0 {
+		t.Errorf("segment %+v", segment)
+	}
+
+	if _, ok := DecodeSegment(Packet{LinkType: LINKTYPE_ETHERNET, Data: packet.Data[:30]}); ok {
+		t.Error("truncated packet decoded")
+	}
+	if _, ok := DecodeSegment(Packet{LinkType: 999, Data: packet.Data}); ok {
+		t.Error("unknown link type decoded")
+	}
+}
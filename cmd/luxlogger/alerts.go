@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"LuxLogger/luxalert"
+	"LuxLogger/luxnotify"
+)
+
+const (
+	ALERT_CHECK_INTERVAL = 30 * time.Second
+)
+
+// setupAlerts creates the alert engine of the rules in config.
+func setupAlerts(config AlertsConfig) (*luxalert.Engine, error) {
+	rules := []luxalert.Rule{}
+	for _, rule := range config.Rules {
+		rules = append(rules, luxalert.Rule{
+			Name:       rule.Name,
+			Expression: rule.Expression,
+			Silence:    rule.Silence.Duration,
+			For:        rule.For.Duration,
+			Hysteresis: rule.Hysteresis,
+			Severity:   rule.Severity,
+		})
+	}
+	return luxalert.NewEngine(rules)
+}
+
+// checkAlerts looks for inverters that went silent and sends the summaries of
+// the quiet hours until ctx is done.
+func checkAlerts(ctx context.Context, sinks *sinks) {
+	ticker := time.NewTicker(ALERT_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			sinks.alert(sinks.alerts.Check(now))
+			sinks.flushNotifiers(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// setupNotifiers creates the notifiers of config, the plain webhooks
+// included.
+func setupNotifiers(config AlertsConfig) ([]*luxnotify.Notifier, error) {
+	configs := []luxnotify.Config{}
+	for _, url := range config.Webhooks {
+		configs = append(configs, luxnotify.Config{Kind: luxnotify.KIND_WEBHOOK, URL: url})
+	}
+	for _, notifier := range config.Notifiers {
+		location := time.Local
+		if notifier.Timezone != "" {
+			var err error
+			location, err = time.LoadLocation(notifier.Timezone)
+			if err != nil {
+				return nil, err
+			}
+		}
+		configs = append(configs, luxnotify.Config{
+			Kind:       notifier.Kind,
+			URL:        notifier.URL,
+			Token:      notifier.Token,
+			User:       notifier.User,
+			Title:      notifier.Title,
+			Body:       notifier.Body,
+			Retries:    notifier.Retries,
+			RetryDelay: notifier.RetryDelay.Duration,
+			RateLimit:  notifier.RateLimit.Duration,
+			QuietStart: notifier.QuietStart,
+			QuietEnd:   notifier.QuietEnd,
+			Location:   location,
+		})
+	}
+
+	notifiers := []*luxnotify.Notifier{}
+	for _, config := range configs {
+		notifier, err := luxnotify.NewNotifier(config)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers, nil
+}
+
+// alert logs events and sends them to MQTT and the notifiers.
+func (sinks *sinks) alert(events []luxalert.Event) {
+	for _, event := range events {
+		println("Alert", event.Severity, event.State+":", event.Message)
+
+		payload, _ := json.Marshal(event)
+		sinks.mqttClient.Publish(sinks.mqttTopic(event.SerialNumber, event.InverterSerial)+"Alerts", 1, false, payload)
+
+		for _, notifier := range sinks.notifiers {
+			go func(notifier *luxnotify.Notifier, event luxalert.Event) {
+				if err := notifier.Notify(event); err != nil {
+					println("Notification failed:", err.Error())
+				}
+			}(notifier, event)
+		}
+	}
+}
+
+// flushNotifiers sends the summaries of the notifiers whose quiet hours are
+// over.
+func (sinks *sinks) flushNotifiers(now time.Time) {
+	for _, notifier := range sinks.notifiers {
+		go func(notifier *luxnotify.Notifier) {
+			if err := notifier.Flush(now); err != nil {
+				println("Notification failed:", err.Error())
+			}
+		}(notifier)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+
+	"LuxLogger/luxbattery"
+)
+
+// setupBattery creates the battery health monitor, with the defaults for the
+// limits left out of config.
+func setupBattery(config BatteryConfig) *luxbattery.Monitor {
+	limits := luxbattery.DefaultLimits()
+	if config.VoltageSpread > 0 {
+		limits.VoltageSpread = config.VoltageSpread
+	}
+	if config.TemperatureSpread > 0 {
+		limits.TemperatureSpread = config.TemperatureSpread
+	}
+	if config.SOCSwing > 0 {
+		limits.SOCSwing = config.SOCSwing
+	}
+	return luxbattery.NewMonitor(limits)
+}
+
+// setupRuntime creates the runtime estimator, with the defaults for the
+// limits left out of config.
+func setupRuntime(config BatteryConfig) *luxbattery.Estimator {
+	limits := luxbattery.DefaultRuntimeLimits()
+	if config.EmptySOC > 0 {
+		limits.EmptySOC = config.EmptySOC
+	}
+	if config.FullSOC > 0 {
+		limits.FullSOC = config.FullSOC
+	}
+	if config.Smoothing.Duration > 0 {
+		limits.Smoothing = config.Smoothing.Duration
+	}
+	return luxbattery.NewEstimator(limits)
+}
+
+// storeBattery writes health to the Battery measurement and to Battery below
+// the MQTT topic of the inverter.
+func (sinks *sinks) storeBattery(health luxbattery.Health, tags map[string]string) {
+	dataPoint := influxdb2.NewPointWithMeasurement("Battery").AddTag("Serial", health.SerialNumber)
+	if health.InverterSerial != "" {
+		dataPoint.AddTag("Inverter", health.InverterSerial)
+	}
+	for key, value := range tags {
+		dataPoint.AddTag(key, value)
+	}
+	dataPoint.SetTime(health.Time)
+	dataPoint.AddField("SOC", health.SOC)
+	dataPoint.AddField("SOH", health.SOH)
+	dataPoint.AddField("Cycle_Count", health.Cycle_Count)
+	dataPoint.AddField("Cell_Voltage_Spread", health.Cell_Voltage_Spread)
+	dataPoint.AddField("Cell_Temperature_Spread", health.Cell_Temperature_Spread)
+	for _, spread := range health.Spread_By_SOC {
+		if spread.Samples == 0 {
+			continue
+		}
+		dataPoint.AddField("Spread_SOC_"+spread.Band+"_Max", spread.Max)
+		dataPoint.AddField("Spread_SOC_"+spread.Band+"_Average", spread.Average)
+	}
+	if health.Estimated_Capacity > 0 {
+		dataPoint.AddField("Estimated_Capacity", health.Estimated_Capacity)
+		dataPoint.AddField("Capacity_Ratio", health.Capacity_Ratio)
+	}
+	dataPoint.AddField("Nominal_Capacity", health.Nominal_Capacity)
+	dataPoint.AddField("SOH_Trend", health.SOH_Trend)
+	sinks.influxWriter.WritePoint(dataPoint)
+
+	payload, _ := json.Marshal(health)
+	sinks.mqttClient.Publish(sinks.mqttTopic(health.SerialNumber, health.InverterSerial)+"Battery", 1, false, payload)
+}
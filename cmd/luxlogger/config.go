@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"LuxLogger/luxexport"
+	"LuxLogger/luxschedule"
+	"LuxLogger/luxserver"
+	"LuxLogger/luxtariff"
+	"LuxLogger/modbus"
+)
+
+const (
+	SERIAL_BAUD = 19200
+	SERIAL_UNIT = 1
+)
+
+// Config is read from the JSON file given with -config. Without one the
+// constants and command line flags are used.
+type Config struct {
+	Influx InfluxConfig
+	MQTT   MQTTConfig
+
+	// Listen is the address dongles connect to in listen mode
+	Listen string
+	// PollInterval is the time between polls of dongles that connect in
+	PollInterval Duration
+	// Extended polls the registers from 120 of dongles that connect in
+	Extended bool
+
+	Dongles []DongleConfig
+	// Serial are inverters wired to RS485 ports instead of a dongle
+	Serial []SerialPortConfig
+
+	Gateway GatewayConfig
+	Daily   DailyConfig
+	Alerts  AlertsConfig
+	Outage  OutageConfig
+
+	GridQuality GridQualityConfig
+	Battery     BatteryConfig
+	Tariff      TariffConfig
+	Schedules   []ScheduleConfig
+	ExportLimit ExportLimitConfig
+}
+
+type InfluxConfig struct {
+	URL    string
+	Token  string
+	Org    string
+	Bucket string
+}
+
+type MQTTConfig struct {
+	Broker   string
+	ClientID string
+	// InverterTopics publishes below LuxLogger/<datalog>/<inverter>/ instead
+	// of LuxLogger/<datalog>/, for inverters in parallel behind one dongle
+	InverterTopics bool
+}
+
+// DongleConfig is one dongle. Dongles with a Host are dialed, the others
+// only give the tags of a dongle with that Datalog serial connecting in.
+type DongleConfig struct {
+	Host    string
+	Port    string
+	Datalog string
+
+	// Inverters are the serial numbers polled through the dongle. Inverters
+	// in parallel share one dongle, without any the dongle picks.
+	Inverters []string
+	// PollInterval is the time between polls, zero only logs pushed data
+	PollInterval Duration
+	// Extended also polls the input registers from 120 of newer firmware
+	Extended bool
+
+	Site  string
+	Label string
+}
+
+// SerialPortConfig is an inverter read over Modbus RTU on its RS485 port.
+// Only its input registers are polled: schedules and the export limit reach
+// inverters through a dongle connection and leave these alone.
+type SerialPortConfig struct {
+	Device   string
+	Baud     int
+	Parity   string // N, E or O
+	StopBits int
+	Unit     uint8
+
+	// Inverter is the serial number the data is stored under
+	Inverter     string
+	PollInterval Duration
+	Extended     bool
+
+	Site  string
+	Label string
+}
+
+// GatewayConfig sets up the Modbus TCP gateway, it runs when Listen is set.
+type GatewayConfig struct {
+	Listen string
+	// Writes lets clients write holding registers
+	Writes bool
+	// MaxAge is how old cached registers may be before reads are forwarded
+	MaxAge Duration
+	// Units maps unit identifiers to inverter serial numbers
+	Units map[uint8]string
+}
+
+// DailyConfig sets where the day ends and where daily summaries are kept
+// besides Influx and MQTT.
+type DailyConfig struct {
+	// Timezone of the inverters like "Africa/Johannesburg", local time if empty
+	Timezone string
+	// File gets every summary appended as a JSON line when set
+	File string
+}
+
+// AlertsConfig lists the alert rules and where their events go besides the
+// log and MQTT.
+type AlertsConfig struct {
+	Rules []AlertRuleConfig
+	// Webhooks get every event POSTed as JSON
+	Webhooks  []string
+	Notifiers []NotifierConfig
+}
+
+// AlertRuleConfig is a luxalert.Rule, see there for the fields.
+type AlertRuleConfig struct {
+	Name       string
+	Expression string
+	Silence    Duration
+	For        Duration
+	Hysteresis float64
+	Severity   string
+}
+
+// NotifierConfig is a luxnotify.Config, see there for the fields.
+type NotifierConfig struct {
+	Kind  string
+	URL   string
+	Token string
+	User  string
+
+	Title string
+	Body  string
+
+	Retries    int
+	RetryDelay Duration
+	RateLimit  Duration
+
+	QuietStart string
+	QuietEnd   string
+	Timezone   string
+}
+
+// OutageConfig tunes the grid outage detection.
+type OutageConfig struct {
+	// LossVoltage is the grid voltage below which the grid counts as lost
+	LossVoltage float32
+}
+
+// GridQualityConfig sets the report interval and limits of the grid quality
+// monitor, see luxgrid.Limits. Zero values keep the EN 50160 defaults.
+type GridQualityConfig struct {
+	Interval           Duration
+	NominalVoltage     float32
+	VoltageTolerance   float32
+	NominalFrequency   float32
+	FrequencyTolerance float32
+	ImbalanceLimit     float32
+}
+
+// BatteryConfig sets the limits of the battery health warnings, see
+// luxbattery.Limits, and of the runtime estimate, see
+// luxbattery.RuntimeLimits. Zero values keep the defaults.
+type BatteryConfig struct {
+	VoltageSpread     float32
+	TemperatureSpread float32
+	SOCSwing          float32
+
+	// EmptySOC and FullSOC match the discharge cutoff and charge limit
+	// set on the inverter
+	EmptySOC  float32
+	FullSOC   float32
+	Smoothing Duration
+}
+
+// TariffConfig holds the energy prices, see luxtariff.Tariff. Costs are
+// only worked out when a price is set, for days and months in the time zone
+// of Daily.
+type TariffConfig struct {
+	Currency string
+	Import   float64
+	Export   float64
+	Bands    []luxtariff.Band
+}
+
+// ScheduleConfig is a charge and discharge schedule kept on inverters
+// reached through a dongle, see luxschedule.Profile. Profiles pick their
+// days in the time zone of Daily.
+type ScheduleConfig struct {
+	Inverters []string
+	// Interval between checks of the inverter settings, 5 minutes if unset
+	Interval Duration
+	Profiles []luxschedule.Profile
+}
+
+// ExportLimitConfig holds the grid export of the listed inverters at a
+// setpoint, see luxexport.Config. Only inverters reached through a dongle
+// are controlled.
+type ExportLimitConfig struct {
+	Inverters   []string
+	Setpoint    float32
+	Bands       []luxexport.Band
+	Deadband    float32
+	MaxStep     float32
+	MinInterval Duration
+	RatedPower  float32
+	FailSafe    uint16
+	StaleAfter  Duration
+}
+
+// Duration reads a time.Duration from a JSON string like "30s".
+type Duration struct {
+	time.Duration
+}
+
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	duration.Duration = parsed
+	return nil
+}
+
+func (duration Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(duration.String())
+}
+
+func defaultConfig() Config {
+	return Config{
+		Influx: InfluxConfig{
+			URL:    INFLUX_URL,
+			Token:  INFLUX_API,
+			Org:    INFLUX_ORG,
+			Bucket: INFLUX_BUCKET,
+		},
+		MQTT: MQTTConfig{
+			Broker:   MQTT_BROKER,
+			ClientID: MQTT_CLIENT_ID,
+		},
+	}
+}
+
+// loadConfig reads the config file at path on top of the defaults.
+func loadConfig(path string) (Config, error) {
+	config := defaultConfig()
+	file, err := os.Open(path)
+	if err != nil {
+		return config, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&config)
+	return config, err
+}
+
+// Address returns the host and port to dial.
+func (dongle DongleConfig) Address() string {
+	port := dongle.Port
+	if port == "" {
+		port = PORT
+	}
+	return dongle.Host + ":" + port
+}
+
+// Tags returns the tags added to the data of the dongle.
+func (dongle DongleConfig) Tags() map[string]string {
+	tags := map[string]string{}
+	if dongle.Site != "" {
+		tags["Site"] = dongle.Site
+	}
+	if dongle.Label != "" {
+		tags["Label"] = dongle.Label
+	}
+	return tags
+}
+
+// withDefaults fills in the settings left out of the config.
+func (port SerialPortConfig) withDefaults() SerialPortConfig {
+	if port.Baud == 0 {
+		port.Baud = SERIAL_BAUD
+	}
+	if port.Parity == "" {
+		port.Parity = modbus.PARITY_NONE
+	}
+	if port.StopBits == 0 {
+		port.StopBits = 1
+	}
+	if port.Unit == 0 {
+		port.Unit = SERIAL_UNIT
+	}
+	if port.PollInterval.Duration == 0 {
+		port.PollInterval.Duration = luxserver.DEFAULT_POLL_INTERVAL
+	}
+	if port.Inverter == "" {
+		port.Inverter = port.Device
+	}
+	return port
+}
+
+// Tags returns the tags added to the data of the inverter.
+func (port SerialPortConfig) Tags() map[string]string {
+	return DongleConfig{Site: port.Site, Label: port.Label}.Tags()
+}
+
+// serial converts a serial number from the config to its wire form.
+func serial(text string) [10]byte {
+	serial := [10]byte{}
+	copy(serial[:], text)
+	return serial
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadExampleConfig(t *testing.T) {
+	config, err := loadConfig("../../config.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Dongles) != 2 {
+		t.Fatalf("read %d dongles", len(config.Dongles))
+	}
+	barn := config.Dongles[1]
+	if barn.Address() != "dongle-barn.lan:8000" || len(barn.Inverters) != 2 || barn.PollInterval.Duration != time.Minute {
+		t.Errorf("barn dongle read as %+v", barn)
+	}
+	if config.Dongles[0].Address() != "dongle-house.lan:"+PORT {
+		t.Errorf("default port not applied: %s", config.Dongles[0].Address())
+	}
+	if tags := barn.Tags(); tags["Site"] != "farm" || tags["Label"] != "barn" {
+		t.Errorf("tags %v", tags)
+	}
+
+	if len(config.Serial) != 1 {
+		t.Fatalf("read %d serial ports", len(config.Serial))
+	}
+	port := config.Serial[0].withDefaults()
+	if port.Parity != "N" || port.StopBits != 1 || port.Unit != SERIAL_UNIT || port.PollInterval.Duration == 0 {
+		t.Errorf("serial port defaults not applied: %+v", port)
+	}
+
+	if config.Gateway.Units[2] != "3123456790" {
+		t.Errorf("gateway units %v", config.Gateway.Units)
+	}
+
+	if _, err := setupAlerts(config.Alerts); err != nil || len(config.Alerts.Rules) != 4 {
+		t.Errorf("alert rules %+v: %v", config.Alerts.Rules, err)
+	}
+	if config.Alerts.Rules[3].Silence.Duration != 10*time.Minute {
+		t.Errorf("silence %v", config.Alerts.Rules[3].Silence)
+	}
+	if notifiers, err := setupNotifiers(config.Alerts); err != nil || len(notifiers) != 3 {
+		t.Errorf("%d notifiers: %v", len(notifiers), err)
+	}
+	if costs, err := setupCosts(config.Tariff, time.UTC); err != nil || costs == nil || len(config.Tariff.Bands) != 2 {
+		t.Errorf("tariff %+v: %v", config.Tariff, err)
+	}
+	if schedules, err := setupSchedules(config.Schedules, time.UTC); err != nil || len(schedules) != 1 || len(schedules[0].inverters) != 2 {
+		t.Errorf("schedules %+v: %v", config.Schedules, err)
+	}
+	if controller, inverters, err := setupExportLimit(config.ExportLimit, time.UTC); err != nil || controller == nil || !inverters[serial("3123456789")] {
+		t.Errorf("export limit %+v: %v", config.ExportLimit, err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+
+	"LuxLogger/luxtariff"
+)
+
+// setupCosts creates the cost tracker, or nil when config sets no prices.
+func setupCosts(config TariffConfig, location *time.Location) (*luxtariff.Tracker, error) {
+	if config.Import == 0 && config.Export == 0 && len(config.Bands) == 0 {
+		return nil, nil
+	}
+	return luxtariff.NewTracker(luxtariff.Tariff{
+		Currency: config.Currency,
+		Import:   config.Import,
+		Export:   config.Export,
+		Bands:    config.Bands,
+	}, location)
+}
+
+// storeCosts writes the running costs of a day or month to the Costs
+// measurement at the start of the period, so each write replaces the last,
+// and retained to Costs/Day or Costs/Month below the MQTT topic of the
+// inverter.
+func (sinks *sinks) storeCosts(costs luxtariff.Costs, tags map[string]string) {
+	dataPoint := influxdb2.NewPointWithMeasurement("Costs").AddTag("Serial", costs.SerialNumber)
+	if costs.InverterSerial != "" {
+		dataPoint.AddTag("Inverter", costs.InverterSerial)
+	}
+	for key, value := range tags {
+		dataPoint.AddTag(key, value)
+	}
+	dataPoint.AddTag("Period", costs.Period)
+	dataPoint.SetTime(costs.Start)
+	dataPoint.AddField("Date", costs.Date)
+	dataPoint.AddField("Currency", costs.Currency)
+	dataPoint.AddField("Imported", costs.Imported)
+	dataPoint.AddField("Exported", costs.Exported)
+	dataPoint.AddField("Self_Consumed", costs.Self_Consumed)
+	dataPoint.AddField("Discharged", costs.Discharged)
+	dataPoint.AddField("Import_Cost", costs.Import_Cost)
+	dataPoint.AddField("Export_Revenue", costs.Export_Revenue)
+	dataPoint.AddField("Net_Cost", costs.Net_Cost)
+	dataPoint.AddField("Self_Consumption_Savings", costs.Self_Consumption_Savings)
+	dataPoint.AddField("Battery_Savings", costs.Battery_Savings)
+	dataPoint.AddField("Savings", costs.Savings)
+	sinks.influxWriter.WritePoint(dataPoint)
+
+	period := strings.ToUpper(costs.Period[:1]) + costs.Period[1:]
+	payload, _ := json.Marshal(costs)
+	sinks.mqttClient.Publish(sinks.mqttTopic(costs.SerialNumber, costs.InverterSerial)+"Costs/"+period, 1, true, payload)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+
+	"LuxLogger/luxdaily"
+)
+
+// storeDaily writes the summary of a finished day to every sink and the
+// daily file when one is configured.
+func (sinks *sinks) storeDaily(summary luxdaily.Summary, tags map[string]string) {
+	influxDailyWrite(summary, tags, sinks.influxWriter)
+	mqttDailyWrite(summary, sinks.mqttTopic(summary.SerialNumber, summary.InverterSerial), sinks.mqttClient)
+	if sinks.dailyFile != "" {
+		if err := luxdaily.AppendFile(sinks.dailyFile, summary); err != nil {
+			println("Writing daily summary failed:", err.Error())
+		}
+	}
+}
+
+// influxDailyWrite adds summary to the Daily measurement at the time of the
+// last data of the day.
+func influxDailyWrite(summary luxdaily.Summary, tags map[string]string, writter api.WriteAPI) {
+	dataPoint := influxdb2.NewPointWithMeasurement("Daily").AddTag("Serial", summary.SerialNumber)
+	if summary.InverterSerial != "" {
+		dataPoint.AddTag("Inverter", summary.InverterSerial)
+	}
+	for key, value := range tags {
+		dataPoint.AddTag(key, value)
+	}
+	dataPoint.SetTime(summary.End)
+	dataPoint.AddField("Date", summary.Date)
+	dataPoint.AddField("PV_Energy", summary.PV_Energy)
+	dataPoint.AddField("Inverter_Energy", summary.Inverter_Energy)
+	dataPoint.AddField("AC_Charging", summary.AC_Charging)
+	dataPoint.AddField("Charging", summary.Charging)
+	dataPoint.AddField("Discharging", summary.Discharging)
+	dataPoint.AddField("EPS", summary.EPS)
+	dataPoint.AddField("Exported", summary.Exported)
+	dataPoint.AddField("Grid", summary.Grid)
+	dataPoint.AddField("Load_Energy", summary.Load_Energy)
+	dataPoint.AddField("Peak_PV_Power", summary.Peak_PV_Power)
+	dataPoint.AddField("Min_SOC", summary.Min_SOC)
+	dataPoint.AddField("Max_SOC", summary.Max_SOC)
+	dataPoint.AddField("Min_Inner_Temperature", summary.Min_Inner_Temperature)
+	dataPoint.AddField("Max_Inner_Temperature", summary.Max_Inner_Temperature)
+	dataPoint.AddField("Min_Radiator_Temperature", summary.Min_Radiator_Temperature)
+	dataPoint.AddField("Max_Radiator_Temperature", summary.Max_Radiator_Temperature)
+	dataPoint.AddField("Min_Battery_Temperature", summary.Min_Battery_Temperature)
+	dataPoint.AddField("Max_Battery_Temperature", summary.Max_Battery_Temperature)
+	writter.WritePoint(dataPoint)
+}
+
+// mqttDailyWrite publishes summary as JSON to Daily below baseTopic,
+// retained so the last day is there for clients connecting later.
+func mqttDailyWrite(summary luxdaily.Summary, baseTopic string, client MQTT.Client) {
+	payload, _ := json.Marshal(summary)
+	client.Publish(baseTopic+"Daily", 1, true, payload)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"LuxLogger/luxclient"
+	"LuxLogger/luxproto"
+)
+
+const (
+	RECONNECT_DELAY = 60 * time.Second
+)
+
+// runDongle keeps a connection to dongle until ctx is done and passes every
+// pushed or polled frame to frame.
+func runDongle(ctx context.Context, dongle DongleConfig, frame func(client *luxclient.Client, frame []byte)) {
+	for {
+		err := connectDongle(ctx, dongle, frame)
+		if ctx.Err() != nil {
+			return
+		}
+		println("Dongle", dongle.Address(), "failed:", err.Error())
+
+		select {
+		case <-time.After(RECONNECT_DELAY):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func connectDongle(ctx context.Context, dongle DongleConfig, frame func(client *luxclient.Client, frame []byte)) error {
+	client, err := luxclient.Dial(ctx, dongle.Address())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if dongle.Datalog != "" {
+		client.SetDatalog(serial(dongle.Datalog))
+	}
+	client.Unsolicited = func(data []byte) {
+		frame(client, data)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if dongle.PollInterval.Duration > 0 {
+		go pollDongle(ctx, client, dongle, frame)
+	}
+	return client.Run(ctx)
+}
+
+// pollDongle reads the input registers of every inverter of dongle each
+// PollInterval.
+func pollDongle(ctx context.Context, client *luxclient.Client, dongle DongleConfig, frame func(client *luxclient.Client, frame []byte)) {
+	inverters := [][10]byte{}
+	for _, inverter := range dongle.Inverters {
+		inverters = append(inverters, serial(inverter))
+	}
+	if len(inverters) == 0 {
+		inverters = append(inverters, [10]byte{})
+	}
+
+	ticker := time.NewTicker(dongle.PollInterval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		for _, inverter := range inverters {
+			err := client.PollInput(ctx, inverter, luxproto.InputBlocks(dongle.Extended), func(data []byte) {
+				frame(client, data)
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				println("Poll of", dongle.Address(), "failed:", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+
+	"LuxLogger/luxproto"
+)
+
+// conditionTracker remembers the fault and warning codes last seen from each
+// inverter, so changes can be written as events.
+type conditionTracker struct {
+	lock sync.Mutex
+	last map[string][2]uint32
+}
+
+// update records the codes of log and returns the previous ones when they
+// changed. An inverter seen for the first time counts as having had none.
+func (tracker *conditionTracker) update(log luxproto.LogData) ([2]uint32, bool) {
+	if !log.Section2.Loaded {
+		return [2]uint32{}, false
+	}
+
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if tracker.last == nil {
+		tracker.last = make(map[string][2]uint32)
+	}
+
+	key := log.SerialNumber + "/" + log.InverterSerial
+	current := [2]uint32{log.Section2.FaultCode, log.Section2.WarningCode}
+	previous := tracker.last[key]
+	tracker.last[key] = current
+	return previous, previous != current
+}
+
+// influxConditionWrite adds a point to the Conditions measurement for a
+// change of the active faults and warnings.
+func influxConditionWrite(log luxproto.LogData, previous [2]uint32, tags map[string]string, writter api.WriteAPI) {
+	faults, warnings := log.Section2.FaultCode, log.Section2.WarningCode
+	raised := append(luxproto.Faults(faults&^previous[0]), luxproto.Warnings(warnings&^previous[1])...)
+	cleared := append(luxproto.Faults(previous[0]&^faults), luxproto.Warnings(previous[1]&^warnings)...)
+
+	dataPoint := influxdb2.NewPointWithMeasurement("Conditions").AddTag("Serial", log.SerialNumber)
+	if log.InverterSerial != "" {
+		dataPoint.AddTag("Inverter", log.InverterSerial)
+	}
+	for key, value := range tags {
+		dataPoint.AddTag(key, value)
+	}
+	if !log.Time.IsZero() {
+		dataPoint.SetTime(log.Time)
+	}
+	dataPoint.AddField("FaultCode", faults)
+	dataPoint.AddField("WarningCode", warnings)
+	dataPoint.AddField("Faults", conditionCodes(log.Section2.Faults))
+	dataPoint.AddField("Warnings", conditionCodes(log.Section2.Warnings))
+	dataPoint.AddField("Raised", conditionCodes(raised))
+	dataPoint.AddField("Cleared", conditionCodes(cleared))
+	writter.WritePoint(dataPoint)
+}
+
+// conditionCodes joins the codes of conditions, like "E021,W016".
+func conditionCodes(conditions []luxproto.Condition) string {
+	codes := make([]string, len(conditions))
+	for i, condition := range conditions {
+		codes[i] = condition.Code
+	}
+	return strings.Join(codes, ",")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtest"
+)
+
+func TestConditionTracker(t *testing.T) {
+	tracker := conditionTracker{}
+	log := luxtest.Frame(time.Time{}, luxproto.LogDataSection2{})
+
+	steps := []struct {
+		name     string
+		faults   uint32
+		warnings uint32
+		changed  bool
+		previous [2]uint32
+	}{
+		{"FirstClear", 0, 0, false, [2]uint32{}},
+		{"Raised", 1 << 21, 0, true, [2]uint32{}},
+		{"Unchanged", 1 << 21, 0, false, [2]uint32{1 << 21, 0}},
+		{"Warning", 1 << 21, 1 << 16, true, [2]uint32{1 << 21, 0}},
+		{"Cleared", 0, 0, true, [2]uint32{1 << 21, 1 << 16}},
+	}
+
+	for _, step := range steps {
+		log.Section2.FaultCode = step.faults
+		log.Section2.WarningCode = step.warnings
+		previous, changed := tracker.update(log)
+		if changed != step.changed || (changed && previous != step.previous) {
+			t.Errorf("%s: got %v %v", step.name, previous, changed)
+		}
+	}
+
+	// Another inverter behind the same dongle has its own state
+	log.InverterSerial = "0000000002"
+	log.Section2.FaultCode = 1 << 21
+	if _, changed := tracker.update(log); !changed {
+		t.Error("second inverter shares state with the first")
+	}
+}
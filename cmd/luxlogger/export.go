@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"LuxLogger/luxalert"
+	"LuxLogger/luxexport"
+	"LuxLogger/luxproto"
+)
+
+const (
+	EXPORT_CHECK_INTERVAL = 15 * time.Second
+)
+
+// setupExportLimit creates the export limit controller, or nil when config
+// lists no inverters.
+func setupExportLimit(config ExportLimitConfig, location *time.Location) (*luxexport.Controller, map[[10]byte]bool, error) {
+	if len(config.Inverters) == 0 {
+		return nil, nil, nil
+	}
+	controller, err := luxexport.NewController(luxexport.Config{
+		Setpoint:    config.Setpoint,
+		Bands:       config.Bands,
+		Deadband:    config.Deadband,
+		MaxStep:     config.MaxStep,
+		MinInterval: config.MinInterval.Duration,
+		RatedPower:  config.RatedPower,
+		FailSafe:    config.FailSafe,
+		StaleAfter:  config.StaleAfter.Duration,
+		Location:    location,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	inverters := make(map[[10]byte]bool)
+	for _, inverter := range config.Inverters {
+		inverters[serial(inverter)] = true
+	}
+	return controller, inverters, nil
+}
+
+// controlExport hands log to the controller when its inverter is controlled
+// and reached through a dongle. The controller talks to the inverter in its
+// own goroutine.
+func (sinks *sinks) controlExport(log luxproto.LogData) {
+	inverter := serial(log.InverterSerial)
+	if !sinks.exportInverters[inverter] {
+		return
+	}
+	client := sinks.client(inverter)
+	if client == nil {
+		return
+	}
+	sinks.exportLimit.Feed(sinks.ctx, client, inverter, log)
+}
+
+// checkExportLimit falls back to the fail-safe limit for inverters whose
+// data stopped, until ctx is done.
+func checkExportLimit(ctx context.Context, sinks *sinks) {
+	ticker := time.NewTicker(EXPORT_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			adjustments, err := sinks.exportLimit.Check(ctx, now)
+			if err != nil {
+				println("Export limit fail-safe failed:", err.Error())
+			}
+			for _, adjustment := range adjustments {
+				sinks.storeExportLimit(adjustment)
+				sinks.alert([]luxalert.Event{{
+					Rule:           "Export limit fail-safe",
+					Severity:       luxalert.SEVERITY_WARNING,
+					State:          luxalert.STATE_FIRING,
+					InverterSerial: adjustment.InverterSerial,
+					Value:          float64(adjustment.To),
+					Time:           now,
+					Message:        "Set " + adjustment.String(),
+				}})
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// storeExportLimit logs adjustment and publishes it to ExportLimit below the
+// MQTT topic of the inverter.
+func (sinks *sinks) storeExportLimit(adjustment luxexport.Adjustment) {
+	println("Set", adjustment.String())
+	payload, _ := json.Marshal(adjustment)
+	sinks.mqttClient.Publish(sinks.mqttTopic(adjustment.SerialNumber, adjustment.InverterSerial)+"ExportLimit", 1, false, payload)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+
+	"LuxLogger/luxgrid"
+)
+
+// setupGridQuality creates the grid quality monitor, with the EN 50160
+// defaults for the limits left out of config.
+func setupGridQuality(config GridQualityConfig) *luxgrid.Monitor {
+	limits := luxgrid.DefaultLimits()
+	if config.Interval.Duration > 0 {
+		limits.Interval = config.Interval.Duration
+	}
+	if config.NominalVoltage > 0 {
+		limits.NominalVoltage = config.NominalVoltage
+	}
+	if config.VoltageTolerance > 0 {
+		limits.VoltageTolerance = config.VoltageTolerance
+	}
+	if config.NominalFrequency > 0 {
+		limits.NominalFrequency = config.NominalFrequency
+	}
+	if config.FrequencyTolerance > 0 {
+		limits.FrequencyTolerance = config.FrequencyTolerance
+	}
+	if config.ImbalanceLimit > 0 {
+		limits.ImbalanceLimit = config.ImbalanceLimit
+	}
+	return luxgrid.NewMonitor(limits)
+}
+
+// storeGridQuality writes report to the GridQuality measurement at the start
+// of its interval and to GridQuality below the MQTT topic of the inverter.
+func (sinks *sinks) storeGridQuality(report luxgrid.Report, tags map[string]string) {
+	dataPoint := influxdb2.NewPointWithMeasurement("GridQuality").AddTag("Serial", report.SerialNumber)
+	if report.InverterSerial != "" {
+		dataPoint.AddTag("Inverter", report.InverterSerial)
+	}
+	for key, value := range tags {
+		dataPoint.AddTag(key, value)
+	}
+	dataPoint.SetTime(report.Start)
+	dataPoint.AddField("Samples", report.Samples)
+	dataPoint.AddField("Phases", report.Phases)
+	stats := map[string]luxgrid.Stats{
+		"Voltage_R":    report.Voltage_R,
+		"Voltage_S":    report.Voltage_S,
+		"Voltage_T":    report.Voltage_T,
+		"Frequency":    report.Frequency,
+		"Power_Factor": report.Power_Factor,
+	}
+	for name, value := range stats {
+		dataPoint.AddField(name+"_Min", value.Min)
+		dataPoint.AddField(name+"_Max", value.Max)
+		dataPoint.AddField(name+"_Average", value.Average)
+	}
+	dataPoint.AddField("Overvoltage", report.Overvoltage)
+	dataPoint.AddField("Undervoltage", report.Undervoltage)
+	dataPoint.AddField("Frequency_Excursions", report.Frequency_Excursions)
+	dataPoint.AddField("Interruptions", report.Interruptions)
+	dataPoint.AddField("Imbalance_Max", report.Imbalance_Max)
+	dataPoint.AddField("Imbalance_Average", report.Imbalance_Average)
+	dataPoint.AddField("Imbalance_Excursions", report.Imbalance_Excursions)
+	sinks.influxWriter.WritePoint(dataPoint)
+
+	payload, _ := json.Marshal(report)
+	sinks.mqttClient.Publish(sinks.mqttTopic(report.SerialNumber, report.InverterSerial)+"GridQuality", 1, false, payload)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"LuxLogger/capture"
+	"LuxLogger/luxproto"
+)
+
+// runImport decodes the dongle frames found in pcap or pcapng captures and
+// writes them as JSON lines, as hex lines in the format of the golden frames
+// of luxproto, or to the configured sinks.
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	port := flags.String("port", PORT, "TCP port of the dongle in the capture")
+	output := flags.String("output", "json", "Where decoded frames go: json, hex or sinks")
+	configFile := flags.String("config", "", "JSON config file with the sinks")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: LuxLogger import [options] capture...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	dongle, err := strconv.Atoi(*port)
+	if err != nil {
+		println("Invalid port:", *port)
+		os.Exit(1)
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	var write func(frame []byte, log luxproto.LogData)
+	switch *output {
+	case "json":
+		stdout := bufio.NewWriter(os.Stdout)
+		defer stdout.Flush()
+		encoder := json.NewEncoder(stdout)
+		write = func(frame []byte, log luxproto.LogData) {
+			encoder.Encode(log)
+		}
+	case "hex":
+		stdout := bufio.NewWriter(os.Stdout)
+		defer stdout.Flush()
+		write = func(frame []byte, log luxproto.LogData) {
+			fmt.Fprintln(stdout, hex.EncodeToString(frame))
+		}
+	case "sinks":
+		config := defaultConfig()
+		if *configFile != "" {
+			config, err = loadConfig(*configFile)
+			if err != nil {
+				println("Reading config failed:", err.Error())
+				os.Exit(1)
+			}
+		}
+		influxClient, influxWriter, mqttClient := setupSinks(config)
+		defer influxClient.Close()
+		defer influxWriter.Flush()
+		// Give the queued MQTT messages time to go out before exiting
+		defer mqttClient.Disconnect(5000)
+		sinks := newSinks(config, influxWriter, mqttClient)
+		write = func(frame []byte, log luxproto.LogData) {
+			sinks.store(log, nil)
+		}
+	default:
+		println("Unknown output:", *output)
+		os.Exit(1)
+	}
+
+	for _, name := range flags.Args() {
+		frames, err := importCapture(name, dongle, write)
+		if err != nil {
+			println("Import of", name, "failed:", err.Error())
+			continue
+		}
+		println("Imported", frames, "frames from", name)
+	}
+}
+
+func importCapture(name string, port int, write func(frame []byte, log luxproto.LogData)) (int, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader, err := capture.NewPacketReader(file)
+	if err != nil {
+		return 0, err
+	}
+
+	frames := 0
+	reassembler := capture.Reassembler{
+		Port: port,
+		Frame: func(frame []byte, when time.Time) {
+			log := luxproto.LogData{Time: when}
+			if log.Decode(frame, uint16(len(frame))) {
+				write(frame, log)
+				frames++
+			}
+		},
+	}
+
+	for {
+		packet, err := reader.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			reassembler.Flush()
+			return frames, err
+		}
+		reassembler.Add(packet)
+	}
+
+	reassembler.Flush()
+	return frames, nil
+}
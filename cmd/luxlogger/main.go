@@ -0,0 +1,211 @@
+// LuxLogger reads the data pushed by LuxPower inverter dongles and stores it
+// in InfluxDB and MQTT.
+//
+// On MQTT every field of an inverter is its own topic below
+// LuxLogger/<datalog>/, with <datalog> the serial number of the dongle, or
+// LuxLogger/<inverter>/ for inverters read over RS485. The Site and Label of
+// the dongle are retained topics next to the fields.
+//
+// Inverters read over RS485 are read-only: schedules and the export limit
+// only control inverters reached through a dongle.
+//
+// Inverters in parallel behind one dongle would share those topics. Setting
+// MQTT.InverterTopics moves the data of every inverter with its own serial to
+// LuxLogger/<datalog>/<inverter>/, so subscribers of LuxLogger/<datalog>/#,
+// like Home Assistant sensors, have to add the inverter serial to their
+// topics when it is switched on.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"sync"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+
+	"LuxLogger/luxclient"
+	"LuxLogger/luxgateway"
+	"LuxLogger/luxproto"
+	"LuxLogger/luxserver"
+	"LuxLogger/modbus"
+)
+
+const (
+	INFLUX_URL    = ""
+	INFLUX_API    = ""
+	INFLUX_ORG    = ""
+	INFLUX_BUCKET = ""
+)
+
+const (
+	MQTT_BROKER    = ""
+	MQTT_CLIENT_ID = ""
+)
+
+const (
+	HOST = "mico.lan"
+	PORT = "8000"
+)
+
+// process decodes frame and queues it to be stored. It is called in the
+// order the frames arrive.
+func process(frame []byte, length uint16, tags map[string]string, sinks *sinks) {
+	log := luxproto.LogData{Time: time.Now()}
+	if log.Decode(frame, length) {
+		sinks.queue(log, tags)
+	}
+}
+
+func setupSinks(config Config) (influxdb2.Client, api.WriteAPI, MQTT.Client) {
+	// Setup Influx
+	influxClient := influxdb2.NewClient(config.Influx.URL, config.Influx.Token)
+	influxWriter := influxClient.WriteAPI(config.Influx.Org, config.Influx.Bucket)
+
+	// Setup MQTT
+	options := MQTT.NewClientOptions().AddBroker(config.MQTT.Broker)
+	options.SetClientID(config.MQTT.ClientID)
+	mqttClient := MQTT.NewClient(options)
+
+	if token := mqttClient.Connect(); token.Wait() && token.Error() != nil {
+		println("Connection to MQTT broker failed:", token.Error())
+		os.Exit(3)
+	}
+
+	return influxClient, influxWriter, mqttClient
+}
+
+// setupGateway creates the Modbus gateway with the unit identifiers of the
+// config.
+func setupGateway(config GatewayConfig) *luxgateway.Gateway {
+	gateway := luxgateway.NewGateway()
+	gateway.Writes = config.Writes
+	if config.MaxAge.Duration > 0 {
+		gateway.MaxAge = config.MaxAge.Duration
+	}
+	for unit, inverter := range config.Units {
+		gateway.Units[unit] = serial(inverter)
+	}
+	return gateway
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		runSimulate(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "proxy" {
+		runProxy(os.Args[2:])
+		return
+	}
+
+	configFile := flag.String("config", "", "JSON config file listing the dongles and sinks, replaces the other flags")
+	host := flag.String("host", HOST, "Host name of the dongle")
+	port := flag.String("port", PORT, "TCP port of the dongle")
+	listen := flag.String("listen", "", "Accept connections from dongles on this address instead of dialing one, for example :4346")
+	poll := flag.Duration("poll", luxserver.DEFAULT_POLL_INTERVAL, "Time between polls of dongles connected in listen mode")
+	flag.Parse()
+
+	config := defaultConfig()
+	if *configFile != "" {
+		var err error
+		config, err = loadConfig(*configFile)
+		if err != nil {
+			println("Reading config failed:", err.Error())
+			os.Exit(1)
+		}
+	} else {
+		config.Listen = *listen
+		config.PollInterval = Duration{*poll}
+		if *listen == "" {
+			config.Dongles = []DongleConfig{{Host: *host, Port: *port}}
+		}
+	}
+
+	_, influxWriter, mqttClient := setupSinks(config)
+	ctx := context.Background()
+	sinks := newSinks(config, influxWriter, mqttClient)
+	sinks.ctx = ctx
+	group := sync.WaitGroup{}
+
+	// The notifiers need the loop for their quiet hours summaries, even
+	// when only other events reach them
+	if len(config.Alerts.Rules) > 0 || len(sinks.notifiers) > 0 {
+		go checkAlerts(ctx, sinks)
+	}
+	runSchedules(ctx, sinks)
+	if sinks.exportLimit != nil {
+		go checkExportLimit(ctx, sinks)
+	}
+
+	if config.Gateway.Listen != "" {
+		sinks.gateway = setupGateway(config.Gateway)
+		go func() {
+			err := modbus.ListenAndServeTCP(ctx, config.Gateway.Listen, sinks.gateway)
+			println("Modbus gateway failed:", err.Error())
+			os.Exit(2)
+		}()
+	}
+
+	for _, dongle := range config.Dongles {
+		if dongle.Host == "" {
+			continue
+		}
+		group.Add(1)
+		go func(dongle DongleConfig) {
+			defer group.Done()
+			tags := dongle.Tags()
+			runDongle(ctx, dongle, func(client *luxclient.Client, frame []byte) {
+				sinks.observe(frame, client)
+				process(frame, uint16(len(frame)), tags, sinks)
+			})
+		}(dongle)
+	}
+
+	for _, port := range config.Serial {
+		group.Add(1)
+		go func(port SerialPortConfig) {
+			defer group.Done()
+			runSerial(ctx, port.withDefaults(), port.Tags(), sinks)
+		}(port)
+	}
+
+	if config.Listen != "" {
+		server := luxserver.NewServer()
+		if config.PollInterval.Duration > 0 {
+			server.PollInterval = config.PollInterval.Duration
+		}
+		server.Extended = config.Extended
+		server.Frame = func(dongle *luxserver.Dongle, frame []byte) {
+			sinks.observe(frame, dongle.Client)
+			process(frame, uint16(len(frame)), listenTags(config, dongle), sinks)
+		}
+		err := server.ListenAndServe(ctx, config.Listen)
+		println("Listen failed:", err.Error())
+		os.Exit(2)
+	}
+
+	group.Wait()
+	println("No dongles configured")
+	os.Exit(1)
+}
+
+// listenTags returns the tags of the configured dongle matching a dongle that
+// connected in.
+func listenTags(config Config, dongle *luxserver.Dongle) map[string]string {
+	for _, configured := range config.Dongles {
+		if configured.Datalog != "" && serial(configured.Datalog) == dongle.Datalog() {
+			return configured.Tags()
+		}
+	}
+	return nil
+}
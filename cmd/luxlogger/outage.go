@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+
+	"LuxLogger/luxoutage"
+)
+
+// storeOutages logs grid events and writes them to the Outages measurement
+// and to Outage below the MQTT topic of the inverter.
+func (sinks *sinks) storeOutages(events []luxoutage.Event, tags map[string]string) {
+	for _, event := range events {
+		serial := event.InverterSerial
+		if serial == "" {
+			serial = event.SerialNumber
+		}
+		if event.Type == luxoutage.EVENT_GRID_RESTORED {
+			println("Grid restored at", serial, "after", (time.Duration(event.Duration) * time.Second).String())
+		} else {
+			println("Grid event at", serial+":", event.Type)
+		}
+
+		dataPoint := influxdb2.NewPointWithMeasurement("Outages").AddTag("Serial", event.SerialNumber)
+		if event.InverterSerial != "" {
+			dataPoint.AddTag("Inverter", event.InverterSerial)
+		}
+		for key, value := range tags {
+			dataPoint.AddTag(key, value)
+		}
+		dataPoint.SetTime(event.Time)
+		dataPoint.AddField("Type", event.Type)
+		dataPoint.AddField("Start", event.Start.Unix())
+		if event.Type == luxoutage.EVENT_GRID_RESTORED {
+			dataPoint.AddField("Duration", event.Duration)
+			dataPoint.AddField("Battery_Energy", event.Battery_Energy)
+			dataPoint.AddField("EPS_Energy", event.EPS_Energy)
+			dataPoint.AddField("Start_SOC", event.Start_SOC)
+			dataPoint.AddField("End_SOC", event.End_SOC)
+			dataPoint.AddField("Min_SOC", event.Min_SOC)
+		}
+		sinks.influxWriter.WritePoint(dataPoint)
+
+		payload, _ := json.Marshal(event)
+		sinks.mqttClient.Publish(sinks.mqttTopic(event.SerialNumber, event.InverterSerial)+"Outage", 1, false, payload)
+	}
+}
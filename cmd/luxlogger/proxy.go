@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxproxy"
+)
+
+// runProxy shares one dongle between LuxLogger and other clients such as the
+// LuxPower app, printing every frame it passes on.
+func runProxy(args []string) {
+	flags := flag.NewFlagSet("proxy", flag.ExitOnError)
+	listen := flags.String("listen", ":"+PORT, "Address clients connect to")
+	host := flags.String("host", HOST, "Host name of the dongle")
+	port := flags.String("port", PORT, "TCP port of the dongle")
+	output := flags.String("output", "log", "Where data from the dongle goes besides the log: log or sinks")
+	configFile := flags.String("config", "", "JSON config file with the sinks")
+	flags.Parse(args)
+
+	proxy := luxproxy.NewProxy(*host + ":" + *port)
+	logFrame := func(source string, frame []byte) {
+		println(source+":", describeFrame(frame))
+	}
+
+	switch *output {
+	case "log":
+		proxy.Frame = logFrame
+	case "sinks":
+		config := defaultConfig()
+		if *configFile != "" {
+			var err error
+			config, err = loadConfig(*configFile)
+			if err != nil {
+				println("Reading config failed:", err.Error())
+				os.Exit(1)
+			}
+		}
+		_, influxWriter, mqttClient := setupSinks(config)
+		sinks := newSinks(config, influxWriter, mqttClient)
+		proxy.Frame = func(source string, frame []byte) {
+			logFrame(source, frame)
+			if source == luxproxy.UPSTREAM {
+				process(frame, uint16(len(frame)), nil, sinks)
+			}
+		}
+	default:
+		println("Unknown output:", *output)
+		os.Exit(1)
+	}
+
+	err := proxy.ListenAndServe(context.Background(), *listen)
+	println("Proxy failed:", err.Error())
+	os.Exit(2)
+}
+
+// describeFrame returns a one line summary of frame for logging.
+func describeFrame(frame []byte) string {
+	header, data, err := luxproto.ParseFrame(frame)
+	if err != nil {
+		return fmt.Sprintf("% X", frame)
+	}
+	if header.Function == luxproto.FUNCTION_DATA {
+		if msg, err := luxproto.ParseMessage(data); err == nil {
+			return fmt.Sprintf("Serial: %s %s", header.SerialNumber, msg)
+		}
+	}
+	return fmt.Sprintf("Serial: %s Function: %02X Data: % X", header.SerialNumber, header.Function, data)
+}
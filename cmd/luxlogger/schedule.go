@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"LuxLogger/luxalert"
+	"LuxLogger/luxclient"
+	"LuxLogger/luxschedule"
+)
+
+const (
+	DEFAULT_SCHEDULE_INTERVAL = 5 * time.Minute
+)
+
+// schedule is a configured schedule with the inverters it applies to.
+type schedule struct {
+	scheduler *luxschedule.Scheduler
+	inverters [][10]byte
+	interval  time.Duration
+}
+
+// setupSchedules creates the schedulers of config.
+func setupSchedules(config []ScheduleConfig, location *time.Location) ([]schedule, error) {
+	schedules := []schedule{}
+	for _, configured := range config {
+		scheduler, err := luxschedule.NewScheduler(configured.Profiles, location)
+		if err != nil {
+			return nil, err
+		}
+		entry := schedule{scheduler: scheduler, interval: configured.Interval.Duration}
+		if entry.interval <= 0 {
+			entry.interval = DEFAULT_SCHEDULE_INTERVAL
+		}
+		for _, inverter := range configured.Inverters {
+			entry.inverters = append(entry.inverters, serial(inverter))
+		}
+		schedules = append(schedules, entry)
+	}
+	return schedules, nil
+}
+
+// runSchedules applies every schedule each interval until ctx is done.
+func runSchedules(ctx context.Context, sinks *sinks) {
+	for _, entry := range sinks.schedules {
+		go func(entry schedule) {
+			ticker := time.NewTicker(entry.interval)
+			defer ticker.Stop()
+			for {
+				for _, inverter := range entry.inverters {
+					sinks.applySchedule(ctx, entry.scheduler, inverter)
+				}
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(entry)
+	}
+}
+
+// applySchedule applies scheduler to inverter when a dongle connection to it
+// is known, and raises an alert for settings changed outside LuxLogger.
+func (sinks *sinks) applySchedule(ctx context.Context, scheduler *luxschedule.Scheduler, inverter [10]byte) {
+	client := sinks.client(inverter)
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, DEFAULT_SCHEDULE_INTERVAL)
+	defer cancel()
+
+	now := time.Now()
+	changes, err := scheduler.Apply(ctx, client, inverter, now)
+	for _, change := range changes {
+		println("Schedule of", string(inverter[:]), "set", change.String())
+		if change.Outside {
+			sinks.alert([]luxalert.Event{{
+				Rule:           "Schedule changed",
+				Severity:       luxalert.SEVERITY_WARNING,
+				State:          luxalert.STATE_FIRING,
+				InverterSerial: string(inverter[:]),
+				Field:          change.Name,
+				Value:          float64(change.Had),
+				Threshold:      float64(change.Wrote),
+				Time:           now,
+				Message:        "Schedule of " + string(inverter[:]) + " was changed outside LuxLogger, reset " + change.String(),
+			}})
+		}
+	}
+	if err != nil {
+		println("Schedule of", string(inverter[:]), "failed:", err.Error())
+	}
+}
+
+// client returns the dongle connection frames of inverter last came from.
+func (sinks *sinks) client(inverter [10]byte) *luxclient.Client {
+	sinks.clientsLock.Lock()
+	defer sinks.clientsLock.Unlock()
+	return sinks.clients[inverter]
+}
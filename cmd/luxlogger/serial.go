@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/modbus"
+)
+
+// runSerial polls the inverter on an RS485 port until ctx is done, reopening
+// the port after failures.
+func runSerial(ctx context.Context, port SerialPortConfig, tags map[string]string, sinks *sinks) {
+	for {
+		err := pollSerial(ctx, port, tags, sinks)
+		if ctx.Err() != nil {
+			return
+		}
+		println("Serial port", port.Device, "failed:", err.Error())
+
+		select {
+		case <-time.After(RECONNECT_DELAY):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func pollSerial(ctx context.Context, port SerialPortConfig, tags map[string]string, sinks *sinks) error {
+	file, err := modbus.OpenSerial(port.Device, modbus.SerialConfig{
+		Baud:     port.Baud,
+		Parity:   port.Parity,
+		StopBits: port.StopBits,
+	})
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	client := modbus.NewRTUClient(file)
+
+	ticker := time.NewTicker(port.PollInterval.Duration)
+	defer ticker.Stop()
+	for {
+		for _, register := range luxproto.InputBlocks(port.Extended) {
+			values, err := client.ReadInput(port.Unit, register, luxproto.SECTION_REGISTERS)
+			if err != nil {
+				if _, ok := err.(modbus.Exception); !ok && !os.IsTimeout(err) {
+					return err
+				}
+				println("Read from", port.Device, "failed:", err.Error())
+				continue
+			}
+
+			if sinks.gateway != nil {
+				sinks.gateway.ObserveRegisters(serial(port.Inverter), luxproto.DEVICE_READINPUT, register, values, nil)
+			}
+			log := luxproto.LogData{SerialNumber: port.Inverter, InverterSerial: port.Inverter, Time: time.Now()}
+			if log.DecodeRegisters(register, values) {
+				sinks.store(log, tags)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"LuxLogger/luxsim"
+)
+
+// runSimulate starts a dongle simulator for testing without real hardware.
+func runSimulate(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	listen := flags.String("listen", ":"+PORT, "Address to accept connections on")
+	connect := flags.String("connect", "", "Server to connect to instead of listening, like a dongle connects to the cloud")
+	datalog := flags.String("datalog", "BA00000001", "Serial number of the simulated dongle")
+	inverter := flags.String("inverter", "0000000001", "Serial number of the simulated inverter")
+	interval := flags.Duration("interval", 10*time.Second, "Time between input register pushes")
+	heartbeat := flags.Duration("heartbeat", 60*time.Second, "Time between heartbeats")
+	push := flags.Bool("push", true, "Push input registers without being polled")
+	split := flags.Float64("split", 0, "Probability of a frame being split in two writes")
+	badCRC := flags.Float64("badcrc", 0, "Probability of a frame having a bad CRC")
+	disconnect := flags.Float64("disconnect", 0, "Probability of a disconnect per push")
+	stale := flags.Float64("stale", 0, "Probability of values freezing per push")
+	staleCycles := flags.Int("stale-cycles", 6, "Number of pushes stale values last")
+	flags.Parse(args)
+
+	sim := luxsim.NewSimulator(*datalog, *inverter)
+	sim.Interval = *interval
+	sim.Heartbeat = *heartbeat
+	sim.Push = *push
+	sim.Faults = luxsim.Faults{
+		SplitFrames: *split,
+		BadCRC:      *badCRC,
+		Disconnect:  *disconnect,
+		StaleData:   *stale,
+		StaleCycles: *staleCycles,
+	}
+
+	var err error
+	if *connect != "" {
+		err = sim.DialAndServe(*connect)
+	} else {
+		err = sim.ListenAndServe(*listen)
+	}
+	if err != nil {
+		println("Simulator failed:", err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+
+	"LuxLogger/luxalert"
+	"LuxLogger/luxbattery"
+	"LuxLogger/luxclient"
+	"LuxLogger/luxdaily"
+	"LuxLogger/luxexport"
+	"LuxLogger/luxgateway"
+	"LuxLogger/luxgrid"
+	"LuxLogger/luxnotify"
+	"LuxLogger/luxoutage"
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtariff"
+)
+
+// STORE_QUEUE_LENGTH is how many frames of one inverter wait to be stored
+// before new ones are dropped.
+const STORE_QUEUE_LENGTH = 100
+
+// sinks is where decoded data goes.
+type sinks struct {
+	// ctx ends the work sinks start on inverters, like the export limit
+	// control
+	ctx context.Context
+
+	influxWriter api.WriteAPI
+	mqttClient   MQTT.Client
+	gateway      *luxgateway.Gateway
+	conditions   conditionTracker
+	daily        *luxdaily.Tracker
+	dailyFile    string
+	alerts       *luxalert.Engine
+	notifiers    []*luxnotify.Notifier
+	outages      *luxoutage.Tracker
+	gridQuality  *luxgrid.Monitor
+	battery      *luxbattery.Monitor
+	runtime      *luxbattery.Estimator
+	costs        *luxtariff.Tracker
+	schedules    []schedule
+
+	// inverterTopics is MQTTConfig.InverterTopics
+	inverterTopics bool
+
+	exportLimit     *luxexport.Controller
+	exportInverters map[[10]byte]bool
+
+	// Dongle connections by the inverters seen through them
+	clientsLock sync.Mutex
+	clients     map[[10]byte]*luxclient.Client
+
+	// Frames waiting to be stored by inverter
+	queuesLock sync.Mutex
+	queues     map[string]chan queued
+}
+
+type queued struct {
+	log  luxproto.LogData
+	tags map[string]string
+}
+
+// newSinks sets up the sinks and the state kept for them from config.
+func newSinks(config Config, influxWriter api.WriteAPI, mqttClient MQTT.Client) *sinks {
+	location := time.Local
+	if config.Daily.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(config.Daily.Timezone)
+		if err != nil {
+			println("Invalid time zone:", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	alerts, err := setupAlerts(config.Alerts)
+	if err != nil {
+		println("Invalid alert rule:", err.Error())
+		os.Exit(1)
+	}
+	notifiers, err := setupNotifiers(config.Alerts)
+	if err != nil {
+		println("Invalid notifier:", err.Error())
+		os.Exit(1)
+	}
+
+	costs, err := setupCosts(config.Tariff, location)
+	if err != nil {
+		println("Invalid tariff:", err.Error())
+		os.Exit(1)
+	}
+
+	schedules, err := setupSchedules(config.Schedules, location)
+	if err != nil {
+		println("Invalid schedule:", err.Error())
+		os.Exit(1)
+	}
+
+	exportLimit, exportInverters, err := setupExportLimit(config.ExportLimit, location)
+	if err != nil {
+		println("Invalid export limit:", err.Error())
+		os.Exit(1)
+	}
+
+	outages := luxoutage.NewTracker()
+	if config.Outage.LossVoltage > 0 {
+		outages.LossVoltage = config.Outage.LossVoltage
+	}
+
+	sinks := &sinks{
+		ctx:             context.Background(),
+		influxWriter:    influxWriter,
+		mqttClient:      mqttClient,
+		inverterTopics:  config.MQTT.InverterTopics,
+		daily:           luxdaily.NewTracker(location),
+		dailyFile:       config.Daily.File,
+		alerts:          alerts,
+		notifiers:       notifiers,
+		outages:         outages,
+		gridQuality:     setupGridQuality(config.GridQuality),
+		battery:         setupBattery(config.Battery),
+		runtime:         setupRuntime(config.Battery),
+		costs:           costs,
+		schedules:       schedules,
+		exportLimit:     exportLimit,
+		exportInverters: exportInverters,
+		clients:         make(map[[10]byte]*luxclient.Client),
+		queues:          make(map[string]chan queued),
+	}
+	if exportLimit != nil {
+		exportLimit.Adjusted = sinks.storeExportLimit
+	}
+	controlled := make(map[[10]byte]bool)
+	for _, schedule := range schedules {
+		for _, inverter := range schedule.inverters {
+			controlled[inverter] = true
+		}
+	}
+	for inverter := range exportInverters {
+		controlled[inverter] = true
+	}
+	for _, port := range config.Serial {
+		if controlled[serial(port.Inverter)] {
+			println("Inverter", port.Inverter, "on", port.Device, "is only read over RS485, schedules and the export limit need a dongle to control it")
+		}
+	}
+	if !sinks.inverterTopics {
+		for _, dongle := range config.Dongles {
+			if len(dongle.Inverters) > 1 {
+				println("Inverters behind dongle", dongle.Host+dongle.Datalog, "share MQTT topics, set MQTT.InverterTopics to tell them apart")
+			}
+		}
+	}
+	return sinks
+}
+
+func (sinks *sinks) store(log luxproto.LogData, tags map[string]string) {
+	sinks.runtime.Estimate(&log)
+	influxWrite(log, tags, sinks.influxWriter)
+	if sinks.exportLimit != nil {
+		sinks.controlExport(log)
+	}
+	if previous, changed := sinks.conditions.update(log); changed {
+		influxConditionWrite(log, previous, tags, sinks.influxWriter)
+	}
+	mqttWrite(log, sinks.mqttTopic(log.SerialNumber, log.InverterSerial), tags, sinks.mqttClient)
+	if summary, ended := sinks.daily.Update(log); ended {
+		sinks.storeDaily(summary, tags)
+	}
+	sinks.alert(sinks.alerts.Update(log))
+	sinks.storeOutages(sinks.outages.Update(log), tags)
+	if report, ended := sinks.gridQuality.Update(log); ended {
+		sinks.storeGridQuality(report, tags)
+	}
+	if health, events, ok := sinks.battery.Update(log); ok {
+		sinks.storeBattery(health, tags)
+		sinks.alert(events)
+	}
+	if sinks.costs != nil {
+		if day, month, changed := sinks.costs.Update(log); changed {
+			sinks.storeCosts(day, tags)
+			sinks.storeCosts(month, tags)
+		}
+	}
+}
+
+// queue hands log to the goroutine storing the data of its inverter. The
+// trackers expect the frames of an inverter one at a time and in order, so
+// each inverter has its own goroutine and they do not wait for each other.
+func (sinks *sinks) queue(log luxproto.LogData, tags map[string]string) {
+	key := log.SerialNumber + "/" + log.InverterSerial
+	sinks.queuesLock.Lock()
+	queue, ok := sinks.queues[key]
+	if !ok {
+		queue = make(chan queued, STORE_QUEUE_LENGTH)
+		sinks.queues[key] = queue
+		go func() {
+			for next := range queue {
+				sinks.store(next.log, next.tags)
+			}
+		}()
+	}
+	sinks.queuesLock.Unlock()
+
+	select {
+	case queue <- queued{log, tags}:
+	default:
+		println("Store queue of", key, "full, frame dropped")
+	}
+}
+
+// observe passes a frame of the inverter to the Modbus gateway, which sends
+// its requests for that inverter through forwarder, and remembers the dongle
+// connection for the schedules.
+func (sinks *sinks) observe(frame []byte, forwarder luxgateway.Forwarder) {
+	if sinks.gateway != nil {
+		sinks.gateway.Observe(frame, forwarder)
+	}
+
+	client, ok := forwarder.(*luxclient.Client)
+	if !ok || client == nil {
+		return
+	}
+	header, data, err := luxproto.ParseFrame(frame)
+	if err != nil || header.Function != luxproto.FUNCTION_DATA {
+		return
+	}
+	if msg, err := luxproto.ParseMessage(data); err == nil && msg.SerialNumber != ([10]byte{}) {
+		sinks.clientsLock.Lock()
+		sinks.clients[msg.SerialNumber] = client
+		sinks.clientsLock.Unlock()
+	}
+}
+
+// influxWrite adds the loaded sections of log as one point to the Input
+// measurement, tagged with the serial numbers and tags.
+func influxWrite(log luxproto.LogData, tags map[string]string, writter api.WriteAPI) {
+	if log.Section1.Loaded || log.Section2.Loaded || log.Section3.Loaded || log.Section4.Loaded {
+		dataPoint := influxdb2.NewPointWithMeasurement("Input").AddTag("Serial", log.SerialNumber)
+		if log.InverterSerial != "" {
+			dataPoint.AddTag("Inverter", log.InverterSerial)
+		}
+		for key, value := range tags {
+			dataPoint.AddTag(key, value)
+		}
+		if !log.Time.IsZero() {
+			dataPoint.SetTime(log.Time)
+		}
+		if log.Section1.Loaded {
+			dataPoint.AddField("Status", log.Section1.Status)
+			dataPoint.AddField("Status_Label", log.Section1.Status_Label)
+			dataPoint.AddField("PV1_Voltage", log.Section1.PV1_Voltage)
+			dataPoint.AddField("PV2_Voltage", log.Section1.PV2_Voltage)
+			dataPoint.AddField("PV3_Voltage", log.Section1.PV3_Voltage)
+			dataPoint.AddField("Battery_Voltage", log.Section1.Battery_Voltage)
+			dataPoint.AddField("SOC", log.Section1.SOC)
+			dataPoint.AddField("SOH", log.Section1.SOH)
+			dataPoint.AddField("PV1_Power", log.Section1.PV1_Power)
+			dataPoint.AddField("PV2_Power", log.Section1.PV2_Power)
+			dataPoint.AddField("PV3_Power", log.Section1.PV3_Power)
+			dataPoint.AddField("Charge_Power", log.Section1.Charge_Power)
+			dataPoint.AddField("Discharge_Power", log.Section1.Discharge_Power)
+			dataPoint.AddField("Voltage_AC_R", log.Section1.Voltage_AC_R)
+			dataPoint.AddField("Voltage_AC_S", log.Section1.Voltage_AC_S)
+			dataPoint.AddField("Voltage_AC_T", log.Section1.Voltage_AC_T)
+			dataPoint.AddField("Frequency_Grid", log.Section1.Frequency_Grid)
+			dataPoint.AddField("ActiveCharge_Power", log.Section1.ActiveCharge_Power)
+			dataPoint.AddField("ActiveInverter_Power", log.Section1.ActiveInverter_Power)
+			dataPoint.AddField("Inductor_Current", log.Section1.Inductor_Current)
+			dataPoint.AddField("Grid_Power_Factor", log.Section1.Grid_Power_Factor)
+			dataPoint.AddField("Voltage_EPS_R", log.Section1.Voltage_EPS_R)
+			dataPoint.AddField("Voltage_EPS_S", log.Section1.Voltage_EPS_S)
+			dataPoint.AddField("Voltage_EPS_T", log.Section1.Voltage_EPS_T)
+			dataPoint.AddField("Frequency_EPS", log.Section1.Frequency_EPS)
+			dataPoint.AddField("Active_EPS_Power", log.Section1.Active_EPS_Power)
+			dataPoint.AddField("Apparent_EPS_Power", log.Section1.Apparent_EPS_Power)
+			dataPoint.AddField("Power_To_Grid", log.Section1.Power_To_Grid)
+			dataPoint.AddField("Power_From_Grid", log.Section1.Power_From_Grid)
+			dataPoint.AddField("PV1_Energy_Today", log.Section1.PV1_Energy_Today)
+			dataPoint.AddField("PV2_Energy_Today", log.Section1.PV2_Energy_Today)
+			dataPoint.AddField("PV3_Energy_Today", log.Section1.PV3_Energy_Today)
+			dataPoint.AddField("ActiveInverter_Energy_Today", log.Section1.ActiveInverter_Energy_Today)
+			dataPoint.AddField("AC_Charging_Today", log.Section1.AC_Charging_Today)
+			dataPoint.AddField("Charging_Today", log.Section1.Charging_Today)
+			dataPoint.AddField("Discharging_Today", log.Section1.Discharging_Today)
+			dataPoint.AddField("EPS_Today", log.Section1.EPS_Today)
+			dataPoint.AddField("Exported_Today", log.Section1.Exported_Today)
+			dataPoint.AddField("Grid_Today", log.Section1.Grid_Today)
+			dataPoint.AddField("Bus1_Voltage", log.Section1.Bus1_Voltage)
+			dataPoint.AddField("Bus2_Voltage", log.Section1.Bus2_Voltage)
+		}
+
+		if log.Section2.Loaded {
+			dataPoint.AddField("PV1_Energy_Total", log.Section2.PV1_Energy_Total)
+			dataPoint.AddField("PV2_Energy_Total", log.Section2.PV2_Energy_Total)
+			dataPoint.AddField("PV3_Energy_Total", log.Section2.PV3_Energy_Total)
+			dataPoint.AddField("ActiveInverter_Energy_Total", log.Section2.ActiveInverter_Energy_Total)
+			dataPoint.AddField("AC_Charging_Total", log.Section2.AC_Charging_Total)
+			dataPoint.AddField("Charging_Total", log.Section2.Charging_Total)
+			dataPoint.AddField("Discharging_Total", log.Section2.Discharging_Total)
+			dataPoint.AddField("EPS_Total", log.Section2.EPS_Total)
+			dataPoint.AddField("Exported_Total", log.Section2.Exported_Total)
+			dataPoint.AddField("Grid_Total", log.Section2.Grid_Total)
+			dataPoint.AddField("FaultCode", log.Section2.FaultCode)
+			dataPoint.AddField("WarningCode", log.Section2.WarningCode)
+			dataPoint.AddField("Inner_Temperature", log.Section2.Inner_Temperature)
+			dataPoint.AddField("Radiator1_Temperature", log.Section2.Radiator1_Temperature)
+			dataPoint.AddField("Radiator2_Temperature", log.Section2.Radiator2_Temperature)
+			dataPoint.AddField("Battery_Temperature", log.Section2.Battery_Temperature)
+			dataPoint.AddField("Runtime", log.Section2.Runtime)
+		}
+
+		if log.Section3.Loaded {
+			// Written as signed integers, the type these fields had in
+			// existing buckets
+			dataPoint.AddField("BatteryComType", int64(log.Section3.BatteryComType))
+			dataPoint.AddField("Battery_Brand", int64(log.Section3.Battery_Brand))
+			dataPoint.AddField("Battery_Brand_Label", log.Section3.Battery_Brand_Label)
+			dataPoint.AddField("Battery_Protocol", log.Section3.Battery_Protocol)
+			dataPoint.AddField("BMS_Max_Charge_Current", log.Section3.BMS_Max_Charge_Current)
+			dataPoint.AddField("BMS_Max_Discharge_Current", log.Section3.BMS_Max_Discharge_Current)
+			dataPoint.AddField("BMS_Charge_Voltage_Reference", log.Section3.BMS_Charge_Voltage_Reference)
+			dataPoint.AddField("BMS_Discharge_Cutoff", log.Section3.BMS_Discharge_Cutoff)
+			dataPoint.AddField("BMS_Status", log.Section3.BMS_Status)
+			dataPoint.AddField("BMS_Inverter_Status", int64(log.Section3.BMS_Inverter_Status))
+			dataPoint.AddField("Battery_Parallel_Count", int64(log.Section3.Battery_Parallel_Count))
+			dataPoint.AddField("Battery_Capacity", log.Section3.Battery_Capacity)
+			dataPoint.AddField("Battery_Current", log.Section3.Battery_Current)
+			dataPoint.AddField("BMS_Requests", strings.Join(log.Section3.BMS_Requests, ","))
+			dataPoint.AddField("BMS_Event1", int64(log.Section3.BMS_Event1))
+			dataPoint.AddField("BMS_Protections", strings.Join(log.Section3.BMS_Protections, ","))
+			dataPoint.AddField("BMS_Event2", int64(log.Section3.BMS_Event2))
+			dataPoint.AddField("BMS_Warnings", strings.Join(log.Section3.BMS_Warnings, ","))
+			dataPoint.AddField("MaxCell_Voltage", log.Section3.MaxCell_Voltage)
+			dataPoint.AddField("MinCell_Voltage", log.Section3.MinCell_Voltage)
+			dataPoint.AddField("MaxCell_Temp", log.Section3.MaxCell_Temp)
+			dataPoint.AddField("MinCell_Temp", log.Section3.MinCell_Temp)
+			dataPoint.AddField("BMS_FW_Update_State", int64(log.Section3.BMS_FW_Update_State))
+			dataPoint.AddField("Cycle_Count", int64(log.Section3.Cycle_Count))
+			dataPoint.AddField("BatteryInverter_Voltage", log.Section3.BatteryInverter_Voltage)
+		}
+
+		if log.Section4.Loaded {
+			dataPoint.AddField("Gen_Voltage", log.Section4.Gen_Voltage)
+			dataPoint.AddField("Gen_Frequency", log.Section4.Gen_Frequency)
+			dataPoint.AddField("Gen_Power", log.Section4.Gen_Power)
+			dataPoint.AddField("Gen_Energy_Today", log.Section4.Gen_Energy_Today)
+			dataPoint.AddField("Gen_Energy_Total", log.Section4.Gen_Energy_Total)
+			dataPoint.AddField("EPS_L1_Voltage", log.Section4.EPS_L1_Voltage)
+			dataPoint.AddField("EPS_L2_Voltage", log.Section4.EPS_L2_Voltage)
+			dataPoint.AddField("EPS_L1_Power", log.Section4.EPS_L1_Power)
+			dataPoint.AddField("EPS_L2_Power", log.Section4.EPS_L2_Power)
+			dataPoint.AddField("EPS_L1_Apparent_Power", log.Section4.EPS_L1_Apparent_Power)
+			dataPoint.AddField("EPS_L2_Apparent_Power", log.Section4.EPS_L2_Apparent_Power)
+			dataPoint.AddField("EPS_L1_Energy_Today", log.Section4.EPS_L1_Energy_Today)
+			dataPoint.AddField("EPS_L2_Energy_Today", log.Section4.EPS_L2_Energy_Today)
+			dataPoint.AddField("EPS_L1_Energy_Total", log.Section4.EPS_L1_Energy_Total)
+			dataPoint.AddField("EPS_L2_Energy_Total", log.Section4.EPS_L2_Energy_Total)
+			dataPoint.AddField("AC_Couple_Power", log.Section4.AC_Couple_Power)
+			dataPoint.AddField("Load_Power_L1", log.Section4.Load_Power_L1)
+			dataPoint.AddField("Load_Power_L2", log.Section4.Load_Power_L2)
+			dataPoint.AddField("Load_Power_L3", log.Section4.Load_Power_L3)
+		}
+
+		if log.Derived.Loaded {
+			dataPoint.AddField("PV_Power", log.Derived.PV_Power)
+			dataPoint.AddField("Load_Power", log.Derived.Load_Power)
+			dataPoint.AddField("Battery_Power", log.Derived.Battery_Power)
+			dataPoint.AddField("Grid_Power", log.Derived.Grid_Power)
+			dataPoint.AddField("Self_Consumption", log.Derived.Self_Consumption)
+			dataPoint.AddField("Autarky", log.Derived.Autarky)
+			dataPoint.AddField("PV_Energy_Today", log.Derived.PV_Energy_Today)
+			dataPoint.AddField("Load_Energy_Today", log.Derived.Load_Energy_Today)
+			dataPoint.AddField("Battery_Energy_Today", log.Derived.Battery_Energy_Today)
+			dataPoint.AddField("Self_Consumption_Today", log.Derived.Self_Consumption_Today)
+			dataPoint.AddField("Autarky_Today", log.Derived.Autarky_Today)
+			dataPoint.AddField("Time_To_Empty", log.Derived.Time_To_Empty)
+			dataPoint.AddField("Time_To_Full", log.Derived.Time_To_Full)
+			dataPoint.AddField("Runtime_Confidence", log.Derived.Runtime_Confidence)
+		}
+		writter.WritePoint(dataPoint)
+	}
+}
+
+// mqttWrite publishes every field of the loaded sections of log, and the
+// tags, as its own topic below baseTopic.
+func mqttWrite(log luxproto.LogData, baseTopic string, tags map[string]string, client MQTT.Client) {
+	// The tags are retained so clients can tell the inverters apart
+	for key, value := range tags {
+		client.Publish(baseTopic+key, 1, true, value)
+	}
+
+	if log.Section1.Loaded {
+		client.Publish(baseTopic+"Status", 1, false, fmt.Sprintf("%d", log.Section1.Status))
+		client.Publish(baseTopic+"Status_Label", 1, false, log.Section1.Status_Label)
+		client.Publish(baseTopic+"PV1_Voltage", 1, false, fmt.Sprintf("%f", log.Section1.PV1_Voltage))
+		client.Publish(baseTopic+"PV2_Voltage", 1, false, fmt.Sprintf("%f", log.Section1.PV2_Voltage))
+		client.Publish(baseTopic+"PV3_Voltage", 1, false, fmt.Sprintf("%f", log.Section1.PV3_Voltage))
+		client.Publish(baseTopic+"Battery_Voltage", 1, false, fmt.Sprintf("%f", log.Section1.Battery_Voltage))
+		client.Publish(baseTopic+"SOC", 1, false, fmt.Sprintf("%f", log.Section1.SOC))
+		client.Publish(baseTopic+"SOH", 1, false, fmt.Sprintf("%f", log.Section1.SOH))
+		client.Publish(baseTopic+"PV1_Power", 1, false, fmt.Sprintf("%f", log.Section1.PV1_Power))
+		client.Publish(baseTopic+"PV2_Power", 1, false, fmt.Sprintf("%f", log.Section1.PV2_Power))
+		client.Publish(baseTopic+"PV3_Power", 1, false, fmt.Sprintf("%f", log.Section1.PV3_Power))
+		client.Publish(baseTopic+"Charge_Power", 1, false, fmt.Sprintf("%f", log.Section1.Charge_Power))
+		client.Publish(baseTopic+"Discharge_Power", 1, false, fmt.Sprintf("%f", log.Section1.Discharge_Power))
+		client.Publish(baseTopic+"Voltage_AC_R", 1, false, fmt.Sprintf("%f", log.Section1.Voltage_AC_R))
+		client.Publish(baseTopic+"Voltage_AC_S", 1, false, fmt.Sprintf("%f", log.Section1.Voltage_AC_S))
+		client.Publish(baseTopic+"Voltage_AC_T", 1, false, fmt.Sprintf("%f", log.Section1.Voltage_AC_T))
+		client.Publish(baseTopic+"Frequency_Grid", 1, false, fmt.Sprintf("%f", log.Section1.Frequency_Grid))
+		client.Publish(baseTopic+"ActiveCharge_Power", 1, false, fmt.Sprintf("%f", log.Section1.ActiveCharge_Power))
+		client.Publish(baseTopic+"ActiveInverter_Power", 1, false, fmt.Sprintf("%f", log.Section1.ActiveInverter_Power))
+		client.Publish(baseTopic+"Inductor_Current", 1, false, fmt.Sprintf("%f", log.Section1.Inductor_Current))
+		client.Publish(baseTopic+"Grid_Power_Factor", 1, false, fmt.Sprintf("%f", log.Section1.Grid_Power_Factor))
+		client.Publish(baseTopic+"Voltage_EPS_R", 1, false, fmt.Sprintf("%f", log.Section1.Voltage_EPS_R))
+		client.Publish(baseTopic+"Voltage_EPS_S", 1, false, fmt.Sprintf("%f", log.Section1.Voltage_EPS_S))
+		client.Publish(baseTopic+"Voltage_EPS_T", 1, false, fmt.Sprintf("%f", log.Section1.Voltage_EPS_T))
+		client.Publish(baseTopic+"Frequency_EPS", 1, false, fmt.Sprintf("%f", log.Section1.Frequency_EPS))
+		client.Publish(baseTopic+"Active_EPS_Power", 1, false, fmt.Sprintf("%f", log.Section1.Active_EPS_Power))
+		client.Publish(baseTopic+"Apparent_EPS_Power", 1, false, fmt.Sprintf("%f", log.Section1.Apparent_EPS_Power))
+		client.Publish(baseTopic+"Power_To_Grid", 1, false, fmt.Sprintf("%f", log.Section1.Power_To_Grid))
+		client.Publish(baseTopic+"Power_From_Grid", 1, false, fmt.Sprintf("%f", log.Section1.Power_From_Grid))
+		client.Publish(baseTopic+"PV1_Energy_Today", 1, false, fmt.Sprintf("%f", log.Section1.PV1_Energy_Today))
+		client.Publish(baseTopic+"PV2_Energy_Today", 1, false, fmt.Sprintf("%f", log.Section1.PV2_Energy_Today))
+		client.Publish(baseTopic+"PV3_Energy_Today", 1, false, fmt.Sprintf("%f", log.Section1.PV3_Energy_Today))
+		client.Publish(baseTopic+"ActiveInverter_Energy_Today", 1, false, fmt.Sprintf("%f", log.Section1.ActiveInverter_Energy_Today))
+		client.Publish(baseTopic+"AC_Charging_Today", 1, false, fmt.Sprintf("%f", log.Section1.AC_Charging_Today))
+		client.Publish(baseTopic+"Charging_Today", 1, false, fmt.Sprintf("%f", log.Section1.Charging_Today))
+		client.Publish(baseTopic+"Discharging_Today", 1, false, fmt.Sprintf("%f", log.Section1.Discharging_Today))
+		client.Publish(baseTopic+"EPS_Today", 1, false, fmt.Sprintf("%f", log.Section1.EPS_Today))
+		client.Publish(baseTopic+"Exported_Today", 1, false, fmt.Sprintf("%f", log.Section1.Exported_Today))
+		client.Publish(baseTopic+"Grid_Today", 1, false, fmt.Sprintf("%f", log.Section1.Grid_Today))
+		client.Publish(baseTopic+"Bus1_Voltage", 1, false, fmt.Sprintf("%f", log.Section1.Bus1_Voltage))
+		client.Publish(baseTopic+"Bus2_Voltage", 1, false, fmt.Sprintf("%f", log.Section1.Bus2_Voltage))
+	}
+
+	if log.Section2.Loaded {
+		client.Publish(baseTopic+"PV1_Energy_Total", 1, false, fmt.Sprintf("%f", log.Section2.PV1_Energy_Total))
+		client.Publish(baseTopic+"PV2_Energy_Total", 1, false, fmt.Sprintf("%f", log.Section2.PV2_Energy_Total))
+		client.Publish(baseTopic+"PV3_Energy_Total", 1, false, fmt.Sprintf("%f", log.Section2.PV3_Energy_Total))
+		client.Publish(baseTopic+"ActiveInverter_Energy_Total", 1, false, fmt.Sprintf("%f", log.Section2.ActiveInverter_Energy_Total))
+		client.Publish(baseTopic+"AC_Charging_Total", 1, false, fmt.Sprintf("%f", log.Section2.AC_Charging_Total))
+		client.Publish(baseTopic+"Charging_Total", 1, false, fmt.Sprintf("%f", log.Section2.Charging_Total))
+		client.Publish(baseTopic+"Discharging_Total", 1, false, fmt.Sprintf("%f", log.Section2.Discharging_Total))
+		client.Publish(baseTopic+"EPS_Total", 1, false, fmt.Sprintf("%f", log.Section2.EPS_Total))
+		client.Publish(baseTopic+"Exported_Total", 1, false, fmt.Sprintf("%f", log.Section2.Exported_Total))
+		client.Publish(baseTopic+"Grid_Total", 1, false, fmt.Sprintf("%f", log.Section2.Grid_Total))
+		client.Publish(baseTopic+"FaultCode", 1, false, fmt.Sprintf("%d", log.Section2.FaultCode))
+		client.Publish(baseTopic+"WarningCode", 1, false, fmt.Sprintf("%d", log.Section2.WarningCode))
+		faults, _ := json.Marshal(log.Section2.Faults)
+		client.Publish(baseTopic+"Faults", 1, false, faults)
+		warnings, _ := json.Marshal(log.Section2.Warnings)
+		client.Publish(baseTopic+"Warnings", 1, false, warnings)
+		client.Publish(baseTopic+"Inner_Temperature", 1, false, fmt.Sprintf("%f", log.Section2.Inner_Temperature))
+		client.Publish(baseTopic+"Radiator1_Temperature", 1, false, fmt.Sprintf("%f", log.Section2.Radiator1_Temperature))
+		client.Publish(baseTopic+"Radiator2_Temperature", 1, false, fmt.Sprintf("%f", log.Section2.Radiator2_Temperature))
+		client.Publish(baseTopic+"Battery_Temperature", 1, false, fmt.Sprintf("%f", log.Section2.Battery_Temperature))
+		client.Publish(baseTopic+"Runtime", 1, false, fmt.Sprintf("%d", log.Section2.Runtime))
+	}
+
+	if log.Section3.Loaded {
+		client.Publish(baseTopic+"BatteryComType", 1, false, fmt.Sprintf("%d", log.Section3.BatteryComType))
+		client.Publish(baseTopic+"Battery_Brand", 1, false, fmt.Sprintf("%d", log.Section3.Battery_Brand))
+		client.Publish(baseTopic+"Battery_Brand_Label", 1, false, log.Section3.Battery_Brand_Label)
+		client.Publish(baseTopic+"Battery_Protocol", 1, false, log.Section3.Battery_Protocol)
+		client.Publish(baseTopic+"BMS_Max_Charge_Current", 1, false, fmt.Sprintf("%f", log.Section3.BMS_Max_Charge_Current))
+		client.Publish(baseTopic+"BMS_Max_Discharge_Current", 1, false, fmt.Sprintf("%f", log.Section3.BMS_Max_Discharge_Current))
+		client.Publish(baseTopic+"BMS_Charge_Voltage_Reference", 1, false, fmt.Sprintf("%f", log.Section3.BMS_Charge_Voltage_Reference))
+		client.Publish(baseTopic+"BMS_Discharge_Cutoff", 1, false, fmt.Sprintf("%f", log.Section3.BMS_Discharge_Cutoff))
+		bmsStatus, _ := json.Marshal(log.Section3.BMS_Status)
+		client.Publish(baseTopic+"BMS_Status", 1, false, bmsStatus)
+		client.Publish(baseTopic+"BMS_Inverter_Status", 1, false, fmt.Sprintf("%d", log.Section3.BMS_Inverter_Status))
+		client.Publish(baseTopic+"Battery_Parallel_Count", 1, false, fmt.Sprintf("%d", log.Section3.Battery_Parallel_Count))
+		client.Publish(baseTopic+"Battery_Capacity", 1, false, fmt.Sprintf("%f", log.Section3.Battery_Capacity))
+		client.Publish(baseTopic+"Battery_Current", 1, false, fmt.Sprintf("%f", log.Section3.Battery_Current))
+		bmsRequests, _ := json.Marshal(log.Section3.BMS_Requests)
+		client.Publish(baseTopic+"BMS_Requests", 1, false, bmsRequests)
+		client.Publish(baseTopic+"BMS_Event1", 1, false, fmt.Sprintf("%d", log.Section3.BMS_Event1))
+		bmsProtections, _ := json.Marshal(log.Section3.BMS_Protections)
+		client.Publish(baseTopic+"BMS_Protections", 1, false, bmsProtections)
+		client.Publish(baseTopic+"BMS_Event2", 1, false, fmt.Sprintf("%d", log.Section3.BMS_Event2))
+		bmsWarnings, _ := json.Marshal(log.Section3.BMS_Warnings)
+		client.Publish(baseTopic+"BMS_Warnings", 1, false, bmsWarnings)
+		client.Publish(baseTopic+"MaxCell_Voltage", 1, false, fmt.Sprintf("%f", log.Section3.MaxCell_Voltage))
+		client.Publish(baseTopic+"MinCell_Voltage", 1, false, fmt.Sprintf("%f", log.Section3.MinCell_Voltage))
+		client.Publish(baseTopic+"MaxCell_Temp", 1, false, fmt.Sprintf("%f", log.Section3.MaxCell_Temp))
+		client.Publish(baseTopic+"MinCell_Temp", 1, false, fmt.Sprintf("%f", log.Section3.MinCell_Temp))
+		client.Publish(baseTopic+"BMS_FW_Update_State", 1, false, fmt.Sprintf("%d", log.Section3.BMS_FW_Update_State))
+		client.Publish(baseTopic+"Cycle_Count", 1, false, fmt.Sprintf("%d", log.Section3.Cycle_Count))
+		client.Publish(baseTopic+"BatteryInverter_Voltage", 1, false, fmt.Sprintf("%f", log.Section3.BatteryInverter_Voltage))
+	}
+
+	if log.Section4.Loaded {
+		client.Publish(baseTopic+"Gen_Voltage", 1, false, fmt.Sprintf("%f", log.Section4.Gen_Voltage))
+		client.Publish(baseTopic+"Gen_Frequency", 1, false, fmt.Sprintf("%f", log.Section4.Gen_Frequency))
+		client.Publish(baseTopic+"Gen_Power", 1, false, fmt.Sprintf("%f", log.Section4.Gen_Power))
+		client.Publish(baseTopic+"Gen_Energy_Today", 1, false, fmt.Sprintf("%f", log.Section4.Gen_Energy_Today))
+		client.Publish(baseTopic+"Gen_Energy_Total", 1, false, fmt.Sprintf("%f", log.Section4.Gen_Energy_Total))
+		client.Publish(baseTopic+"EPS_L1_Voltage", 1, false, fmt.Sprintf("%f", log.Section4.EPS_L1_Voltage))
+		client.Publish(baseTopic+"EPS_L2_Voltage", 1, false, fmt.Sprintf("%f", log.Section4.EPS_L2_Voltage))
+		client.Publish(baseTopic+"EPS_L1_Power", 1, false, fmt.Sprintf("%f", log.Section4.EPS_L1_Power))
+		client.Publish(baseTopic+"EPS_L2_Power", 1, false, fmt.Sprintf("%f", log.Section4.EPS_L2_Power))
+		client.Publish(baseTopic+"EPS_L1_Apparent_Power", 1, false, fmt.Sprintf("%f", log.Section4.EPS_L1_Apparent_Power))
+		client.Publish(baseTopic+"EPS_L2_Apparent_Power", 1, false, fmt.Sprintf("%f", log.Section4.EPS_L2_Apparent_Power))
+		client.Publish(baseTopic+"EPS_L1_Energy_Today", 1, false, fmt.Sprintf("%f", log.Section4.EPS_L1_Energy_Today))
+		client.Publish(baseTopic+"EPS_L2_Energy_Today", 1, false, fmt.Sprintf("%f", log.Section4.EPS_L2_Energy_Today))
+		client.Publish(baseTopic+"EPS_L1_Energy_Total", 1, false, fmt.Sprintf("%f", log.Section4.EPS_L1_Energy_Total))
+		client.Publish(baseTopic+"EPS_L2_Energy_Total", 1, false, fmt.Sprintf("%f", log.Section4.EPS_L2_Energy_Total))
+		client.Publish(baseTopic+"AC_Couple_Power", 1, false, fmt.Sprintf("%f", log.Section4.AC_Couple_Power))
+		client.Publish(baseTopic+"Load_Power_L1", 1, false, fmt.Sprintf("%f", log.Section4.Load_Power_L1))
+		client.Publish(baseTopic+"Load_Power_L2", 1, false, fmt.Sprintf("%f", log.Section4.Load_Power_L2))
+		client.Publish(baseTopic+"Load_Power_L3", 1, false, fmt.Sprintf("%f", log.Section4.Load_Power_L3))
+	}
+
+	if log.Derived.Loaded {
+		client.Publish(baseTopic+"PV_Power", 1, false, fmt.Sprintf("%f", log.Derived.PV_Power))
+		client.Publish(baseTopic+"Load_Power", 1, false, fmt.Sprintf("%f", log.Derived.Load_Power))
+		client.Publish(baseTopic+"Battery_Power", 1, false, fmt.Sprintf("%f", log.Derived.Battery_Power))
+		client.Publish(baseTopic+"Grid_Power", 1, false, fmt.Sprintf("%f", log.Derived.Grid_Power))
+		client.Publish(baseTopic+"Self_Consumption", 1, false, fmt.Sprintf("%f", log.Derived.Self_Consumption))
+		client.Publish(baseTopic+"Autarky", 1, false, fmt.Sprintf("%f", log.Derived.Autarky))
+		client.Publish(baseTopic+"PV_Energy_Today", 1, false, fmt.Sprintf("%f", log.Derived.PV_Energy_Today))
+		client.Publish(baseTopic+"Load_Energy_Today", 1, false, fmt.Sprintf("%f", log.Derived.Load_Energy_Today))
+		client.Publish(baseTopic+"Battery_Energy_Today", 1, false, fmt.Sprintf("%f", log.Derived.Battery_Energy_Today))
+		client.Publish(baseTopic+"Self_Consumption_Today", 1, false, fmt.Sprintf("%f", log.Derived.Self_Consumption_Today))
+		client.Publish(baseTopic+"Autarky_Today", 1, false, fmt.Sprintf("%f", log.Derived.Autarky_Today))
+		client.Publish(baseTopic+"Time_To_Empty", 1, false, fmt.Sprintf("%f", log.Derived.Time_To_Empty))
+		client.Publish(baseTopic+"Time_To_Full", 1, false, fmt.Sprintf("%f", log.Derived.Time_To_Full))
+		client.Publish(baseTopic+"Runtime_Confidence", 1, false, fmt.Sprintf("%f", log.Derived.Runtime_Confidence))
+	}
+}
+
+// mqttTopic returns the topic everything about an inverter is published
+// under, see mqttInverterTopic.
+func (sinks *sinks) mqttTopic(datalog string, inverter string) string {
+	return mqttInverterTopic(datalog, inverter, sinks.inverterTopics)
+}
+
+// mqttInverterTopic returns LuxLogger/<datalog>/, or with separate set
+// LuxLogger/<datalog>/<inverter>/ so inverters in parallel behind one dongle
+// stay apart. Data without a dongle, like inverters read over RS485, goes to
+// LuxLogger/<inverter>/.
+func mqttInverterTopic(datalog string, inverter string, separate bool) string {
+	if datalog == "" {
+		return "LuxLogger/" + inverter + "/"
+	}
+	if !separate || inverter == "" || inverter == datalog {
+		return "LuxLogger/" + datalog + "/"
+	}
+	return "LuxLogger/" + datalog + "/" + inverter + "/"
+}
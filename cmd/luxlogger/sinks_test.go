@@ -0,0 +1,145 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtest"
+)
+
+// pointWriter keeps the points written to it in place of InfluxDB.
+type pointWriter struct {
+	lock   sync.Mutex
+	points []*write.Point
+}
+
+func (writer *pointWriter) WriteRecord(line string) {}
+func (writer *pointWriter) WritePoint(point *write.Point) {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	writer.points = append(writer.points, point)
+}
+func (writer *pointWriter) Flush()                                            {}
+func (writer *pointWriter) Errors() <-chan error                              { return nil }
+func (writer *pointWriter) SetWriteFailedCallback(cb api.WriteFailedCallback) {}
+
+// messageRecorder keeps the payloads published to it by topic in place of
+// the MQTT broker.
+type messageRecorder struct {
+	MQTT.Client
+	lock     sync.Mutex
+	messages map[string]interface{}
+}
+
+func (recorder *messageRecorder) Publish(topic string, qos byte, retained bool, payload interface{}) MQTT.Token {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.messages[topic] = payload
+	return &MQTT.DummyToken{}
+}
+
+func TestMQTTTopic(t *testing.T) {
+	tests := []struct {
+		datalog  string
+		inverter string
+		separate bool
+		topic    string
+	}{
+		// Without InverterTopics the topics stay below the dongle serial
+		{"BA00000001", "0000000001", false, "LuxLogger/BA00000001/"},
+		{"BA00000001", "0000000001", true, "LuxLogger/BA00000001/0000000001/"},
+		{"BA00000001", "", true, "LuxLogger/BA00000001/"},
+		{"0000000001", "0000000001", true, "LuxLogger/0000000001/"},
+		{"", "0000000001", false, "LuxLogger/0000000001/"},
+	}
+	for _, test := range tests {
+		if topic := mqttInverterTopic(test.datalog, test.inverter, test.separate); topic != test.topic {
+			t.Errorf("%q and %q with separate %v published to %q", test.datalog, test.inverter, test.separate, topic)
+		}
+	}
+
+	sinks := newSinks(defaultConfig(), &pointWriter{}, &messageRecorder{messages: map[string]interface{}{}})
+	if topic := sinks.mqttTopic(luxtest.DATALOG, luxtest.INVERTER); topic != "LuxLogger/BA00000001/" {
+		t.Errorf("default topic %q", topic)
+	}
+
+	log := luxtest.Frame(time.Time{}, luxproto.LogDataSection1{SOC: 87})
+	recorder := &messageRecorder{messages: map[string]interface{}{}}
+	mqttWrite(log, "LuxLogger/BA00000001/", map[string]string{"Site": "farm"}, recorder)
+	for topic, payload := range map[string]string{
+		"LuxLogger/BA00000001/SOC":  "87.000000",
+		"LuxLogger/BA00000001/Site": "farm",
+	} {
+		if recorder.messages[topic] != payload {
+			t.Errorf("%s is %v", topic, recorder.messages[topic])
+		}
+	}
+}
+
+func TestInfluxSection3Integers(t *testing.T) {
+	log := luxtest.Frame(time.Time{}, luxproto.LogDataSection3{BatteryComType: 1, Battery_Parallel_Count: 2, Cycle_Count: 250})
+
+	writer := &pointWriter{}
+	influxWrite(log, nil, writer)
+	if len(writer.points) != 1 {
+		t.Fatalf("%d points", len(writer.points))
+	}
+
+	fields := map[string]interface{}{}
+	for _, field := range writer.points[0].FieldList() {
+		fields[field.Key] = field.Value
+	}
+	for _, name := range []string{"BatteryComType", "Battery_Brand", "BMS_Inverter_Status", "Battery_Parallel_Count", "BMS_Event1", "BMS_Event2", "BMS_FW_Update_State", "Cycle_Count"} {
+		if _, ok := fields[name].(int64); !ok {
+			t.Errorf("%s written as %T", name, fields[name])
+		}
+	}
+}
+
+func TestQueueKeepsOrder(t *testing.T) {
+	writer := &pointWriter{}
+	recorder := &messageRecorder{messages: map[string]interface{}{}}
+	sinks := newSinks(defaultConfig(), writer, recorder)
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		for _, inverter := range []string{luxtest.INVERTER, "0000000002"} {
+			log := luxtest.Frame(start.Add(time.Duration(i)*time.Minute), luxproto.LogDataSection2{})
+			log.InverterSerial = inverter
+			sinks.queue(log, nil)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		writer.lock.Lock()
+		points := append([]*write.Point{}, writer.points...)
+		writer.lock.Unlock()
+		if len(points) == 100 {
+			last := map[string]time.Time{}
+			for _, point := range points {
+				inverter := ""
+				for _, tag := range point.TagList() {
+					if tag.Key == "Inverter" {
+						inverter = tag.Value
+					}
+				}
+				if point.Time().Before(last[inverter]) {
+					t.Fatalf("%s stored %v after %v", inverter, point.Time(), last[inverter])
+				}
+				last[inverter] = point.Time()
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of 100 frames stored", len(points))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
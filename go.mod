@@ -2,13 +2,15 @@ module LuxLogger
 
 go 1.20
 
-require github.com/influxdata/influxdb-client-go v1.4.0
+require (
+	github.com/eclipse/paho.mqtt.golang v1.4.2
+	github.com/influxdata/influxdb-client-go v1.4.0
+	github.com/influxdata/influxdb-client-go/v2 v2.12.2
+)
 
 require (
 	github.com/deepmap/oapi-codegen v1.8.2 // indirect
-	github.com/eclipse/paho.mqtt.golang v1.4.2 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/influxdata/influxdb-client-go/v2 v2.12.2 // indirect
 	github.com/influxdata/line-protocol v0.0.0-20200327222509-2487e7298839 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/net v0.0.0-20210119194325-5f4716e94777 // indirect
@@ -0,0 +1,298 @@
+// Package luxalert evaluates alert rules over decoded data. A rule compares
+// one field of luxproto.LogData with a threshold, or fires when an inverter
+// has sent nothing for a while. Rules keep their state per inverter and
+// report when they start firing and when they resolve.
+package luxalert
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+// Severities of a rule
+const (
+	SEVERITY_INFO     = "info"
+	SEVERITY_WARNING  = "warning"
+	SEVERITY_CRITICAL = "critical"
+)
+
+// States of an Event
+const (
+	STATE_FIRING   = "firing"
+	STATE_RESOLVED = "resolved"
+)
+
+type Rule struct {
+	Name string
+	// Expression compares a field with a number, like "SOC < 15" or
+	// "FaultCode != 0". The operators are < <= > >= == and !=.
+	Expression string
+	// Silence fires the rule when no data arrived for that long, instead of
+	// an Expression
+	Silence time.Duration
+	// For is how long the expression has to hold before the rule fires
+	For time.Duration
+	// Hysteresis is how far past the threshold the value has to get back
+	// before the rule resolves
+	Hysteresis float64
+	Severity   string
+}
+
+// Event is a rule starting to fire or resolving for one inverter.
+type Event struct {
+	Rule           string
+	Severity       string
+	State          string
+	SerialNumber   string
+	InverterSerial string
+	Field          string `json:",omitempty"`
+	Value          float64
+	Threshold      float64
+	Time           time.Time
+	Message        string
+}
+
+type rule struct {
+	Rule
+	field     string
+	operator  string
+	threshold float64
+}
+
+type state struct {
+	pendingSince time.Time
+	firing       bool
+}
+
+type inverter struct {
+	serialNumber   string
+	inverterSerial string
+	lastData       time.Time
+	states         []state
+}
+
+type Engine struct {
+	lock      sync.Mutex
+	rules     []rule
+	inverters map[string]*inverter
+}
+
+func NewEngine(rules []Rule) (*Engine, error) {
+	engine := &Engine{inverters: make(map[string]*inverter)}
+	for _, config := range rules {
+		parsed, err := parseRule(config)
+		if err != nil {
+			return nil, err
+		}
+		engine.rules = append(engine.rules, parsed)
+	}
+	return engine, nil
+}
+
+func parseRule(config Rule) (rule, error) {
+	parsed := rule{Rule: config}
+	if parsed.Severity == "" {
+		parsed.Severity = SEVERITY_WARNING
+	}
+	if config.Silence > 0 {
+		if config.Expression != "" {
+			return parsed, fmt.Errorf("rule %q has both Silence and an Expression", config.Name)
+		}
+		return parsed, nil
+	}
+
+	parts := strings.Fields(config.Expression)
+	if len(parts) != 3 {
+		return parsed, fmt.Errorf("rule %q: expression %q is not <field> <operator> <number>", config.Name, config.Expression)
+	}
+	if _, ok := field(luxproto.LogData{}, parts[0], false); !ok {
+		return parsed, fmt.Errorf("rule %q: unknown field %s", config.Name, parts[0])
+	}
+	switch parts[1] {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return parsed, fmt.Errorf("rule %q: unknown operator %s", config.Name, parts[1])
+	}
+	threshold, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return parsed, fmt.Errorf("rule %q: %v", config.Name, err)
+	}
+
+	parsed.field, parsed.operator, parsed.threshold = parts[0], parts[1], threshold
+	return parsed, nil
+}
+
+// field returns the value of the named field of the sections of log. With
+// loaded set only sections that were part of the frame count.
+func field(log luxproto.LogData, name string, loaded bool) (float64, bool) {
+	sections := []interface{}{log.Section1, log.Section2, log.Section3, log.Section4, log.Derived}
+	for _, section := range sections {
+		value := reflect.ValueOf(section)
+		if loaded && !value.FieldByName("Loaded").Bool() {
+			continue
+		}
+		found := value.FieldByName(name)
+		if !found.IsValid() {
+			continue
+		}
+		switch found.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return found.Float(), true
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(found.Int()), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(found.Uint()), true
+		}
+	}
+	return 0, false
+}
+
+// active tells if value meets the expression of rule.
+func (rule rule) active(value float64) bool {
+	switch rule.operator {
+	case "<":
+		return value < rule.threshold
+	case "<=":
+		return value <= rule.threshold
+	case ">":
+		return value > rule.threshold
+	case ">=":
+		return value >= rule.threshold
+	case "==":
+		return value == rule.threshold
+	}
+	return value != rule.threshold
+}
+
+// cleared tells if value is far enough from the threshold for a firing rule
+// to resolve.
+func (rule rule) cleared(value float64) bool {
+	switch rule.operator {
+	case "<", "<=":
+		return !rule.active(value - rule.Hysteresis)
+	case ">", ">=":
+		return !rule.active(value + rule.Hysteresis)
+	}
+	return !rule.active(value)
+}
+
+// Update evaluates the rules on log and returns the events it causes.
+func (engine *Engine) Update(log luxproto.LogData) []Event {
+	now := log.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	key := log.SerialNumber + "/" + log.InverterSerial
+	current, ok := engine.inverters[key]
+	if !ok {
+		current = &inverter{
+			serialNumber:   log.SerialNumber,
+			inverterSerial: log.InverterSerial,
+			states:         make([]state, len(engine.rules)),
+		}
+		engine.inverters[key] = current
+	}
+	current.lastData = now
+
+	events := []Event{}
+	for i, rule := range engine.rules {
+		state := &current.states[i]
+		if rule.Silence > 0 {
+			if state.firing {
+				state.firing = false
+				events = append(events, current.event(rule, STATE_RESOLVED, 0, now))
+			}
+			continue
+		}
+
+		value, ok := field(log, rule.field, true)
+		if !ok {
+			continue
+		}
+		if state.firing {
+			if rule.cleared(value) {
+				state.firing = false
+				state.pendingSince = time.Time{}
+				events = append(events, current.event(rule, STATE_RESOLVED, value, now))
+			}
+			continue
+		}
+		if !rule.active(value) {
+			state.pendingSince = time.Time{}
+			continue
+		}
+		if state.pendingSince.IsZero() {
+			state.pendingSince = now
+		}
+		if now.Sub(state.pendingSince) >= rule.For {
+			state.firing = true
+			events = append(events, current.event(rule, STATE_FIRING, value, now))
+		}
+	}
+	return events
+}
+
+// Check fires the Silence rules of inverters that sent nothing for too long.
+// It is called periodically.
+func (engine *Engine) Check(now time.Time) []Event {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	events := []Event{}
+	for _, current := range engine.inverters {
+		for i, rule := range engine.rules {
+			state := &current.states[i]
+			if rule.Silence == 0 || state.firing {
+				continue
+			}
+			silent := now.Sub(current.lastData)
+			if silent >= rule.Silence {
+				state.firing = true
+				events = append(events, current.event(rule, STATE_FIRING, silent.Seconds(), now))
+			}
+		}
+	}
+	return events
+}
+
+func (current *inverter) event(rule rule, state string, value float64, now time.Time) Event {
+	event := Event{
+		Rule:           rule.Name,
+		Severity:       rule.Severity,
+		State:          state,
+		SerialNumber:   current.serialNumber,
+		InverterSerial: current.inverterSerial,
+		Field:          rule.field,
+		Value:          value,
+		Threshold:      rule.threshold,
+		Time:           now,
+	}
+
+	serial := current.inverterSerial
+	if serial == "" {
+		serial = current.serialNumber
+	}
+	switch {
+	case rule.Silence > 0 && state == STATE_FIRING:
+		event.Threshold = rule.Silence.Seconds()
+		event.Message = fmt.Sprintf("%s: no data from %s for %s", rule.Name, serial, rule.Silence)
+	case rule.Silence > 0:
+		event.Threshold = rule.Silence.Seconds()
+		event.Message = fmt.Sprintf("%s resolved: data from %s again", rule.Name, serial)
+	case state == STATE_FIRING:
+		event.Message = fmt.Sprintf("%s: %s of %s is %g (%s)", rule.Name, rule.field, serial, value, rule.Expression)
+	default:
+		event.Message = fmt.Sprintf("%s resolved: %s of %s is %g", rule.Name, rule.field, serial, value)
+	}
+	return event
+}
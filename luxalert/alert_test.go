@@ -0,0 +1,129 @@
+package luxalert
+
+import (
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtest"
+)
+
+func TestThresholdWithHysteresis(t *testing.T) {
+	engine, err := NewEngine([]Rule{{
+		Name:       "Low battery",
+		Expression: "SOC < 15",
+		For:        5 * time.Minute,
+		Hysteresis: 5,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		at    time.Duration
+		soc   float32
+		state string
+	}{
+		{0, 20, ""},
+		{time.Minute, 14, ""},
+		// Not below long enough yet
+		{3 * time.Minute, 13, ""},
+		{6 * time.Minute, 12, STATE_FIRING},
+		{7 * time.Minute, 11, ""},
+		// Back above the threshold but within the hysteresis
+		{8 * time.Minute, 17, ""},
+		{9 * time.Minute, 20, STATE_RESOLVED},
+		{10 * time.Minute, 14, ""},
+	}
+
+	for _, step := range steps {
+		events := engine.Update(luxtest.Frame(start.Add(step.at), luxproto.LogDataSection1{SOC: step.soc}))
+		state := ""
+		if len(events) == 1 {
+			state = events[0].State
+			if events[0].Rule != "Low battery" || events[0].Severity != SEVERITY_WARNING || events[0].Value != float64(step.soc) {
+				t.Errorf("event %+v", events[0])
+			}
+		}
+		if len(events) > 1 || state != step.state {
+			t.Errorf("at %v SOC %v gave %+v", step.at, step.soc, events)
+		}
+	}
+}
+
+func TestFieldOfMissingSection(t *testing.T) {
+	engine, err := NewEngine([]Rule{{Name: "Fault", Expression: "FaultCode != 0", Severity: SEVERITY_CRITICAL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := luxproto.LogData{SerialNumber: "BA00000001"}
+	log.Section2.FaultCode = 1 << 21
+	if events := engine.Update(log); len(events) != 0 {
+		t.Errorf("rule on an unloaded section gave %+v", events)
+	}
+
+	log.Section2.Loaded = true
+	events := engine.Update(log)
+	if len(events) != 1 || events[0].State != STATE_FIRING || events[0].Severity != SEVERITY_CRITICAL || events[0].Value != 1<<21 {
+		t.Errorf("fault gave %+v", events)
+	}
+}
+
+func TestSilence(t *testing.T) {
+	engine, err := NewEngine([]Rule{{Name: "No data", Silence: 10 * time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+
+	if events := engine.Check(start); len(events) != 0 {
+		t.Errorf("silence of an inverter never seen: %+v", events)
+	}
+	engine.Update(luxtest.Frame(start, luxproto.LogDataSection1{SOC: 50}))
+	if events := engine.Check(start.Add(9 * time.Minute)); len(events) != 0 {
+		t.Errorf("fired early: %+v", events)
+	}
+	events := engine.Check(start.Add(10 * time.Minute))
+	if len(events) != 1 || events[0].State != STATE_FIRING {
+		t.Fatalf("silence gave %+v", events)
+	}
+	if events := engine.Check(start.Add(20 * time.Minute)); len(events) != 0 {
+		t.Errorf("fired twice: %+v", events)
+	}
+	events = engine.Update(luxtest.Frame(start.Add(21*time.Minute), luxproto.LogDataSection1{SOC: 50}))
+	if len(events) != 1 || events[0].State != STATE_RESOLVED {
+		t.Errorf("new data gave %+v", events)
+	}
+}
+
+func TestInvertersApart(t *testing.T) {
+	engine, _ := NewEngine([]Rule{{Name: "Low battery", Expression: "SOC <= 10"}})
+	now := time.Now()
+
+	if events := engine.Update(luxtest.Frame(now, luxproto.LogDataSection1{SOC: 5})); len(events) != 1 {
+		t.Fatalf("first inverter gave %+v", events)
+	}
+	other := luxtest.Frame(now, luxproto.LogDataSection1{SOC: 5})
+	other.InverterSerial = "0000000002"
+	if events := engine.Update(other); len(events) != 1 || events[0].InverterSerial != "0000000002" {
+		t.Errorf("second inverter gave %+v", events)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	rules := []Rule{
+		{Name: "Empty"},
+		{Name: "Field", Expression: "Unknown > 1"},
+		{Name: "Operator", Expression: "SOC => 1"},
+		{Name: "Number", Expression: "SOC < low"},
+		{Name: "Both", Expression: "SOC < 1", Silence: time.Minute},
+		{Name: "Words", Expression: "SOC<1"},
+	}
+	for _, rule := range rules {
+		if _, err := NewEngine([]Rule{rule}); err == nil {
+			t.Errorf("rule %s accepted", rule.Name)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Package luxbattery tracks the health of the battery behind each inverter
+// from the BMS data: the spread of the cell voltages per state of charge,
+// the usable capacity counted from the battery current, and the SOH trend.
+// It warns when the cells drift apart in voltage or temperature.
+package luxbattery
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"LuxLogger/luxalert"
+	"LuxLogger/luxproto"
+)
+
+const (
+	DEFAULT_VOLTAGE_SPREAD     = 0.1 // V between the highest and lowest cell
+	DEFAULT_TEMPERATURE_SPREAD = 5   // °C between the warmest and coldest cell
+	DEFAULT_SOC_SWING          = 20  // % of SOC to count before estimating capacity
+	// CLEAR_RATIO of a limit is where a raised warning clears again
+	CLEAR_RATIO = 0.8
+	// MAX_SAMPLE_GAP restarts the coulomb counting, the current in between
+	// is unknown
+	MAX_SAMPLE_GAP = 5 * time.Minute
+	// CAPACITY_SMOOTHING weighs a new capacity estimate against the old
+	CAPACITY_SMOOTHING = 0.3
+	// SOH_HISTORY is the number of daily SOH values the trend is fitted to
+	SOH_HISTORY = 90
+	SOC_BANDS   = 5
+)
+
+// Names of the warnings, used as luxalert rule names
+const (
+	RULE_VOLTAGE_SPREAD     = "Cell voltage spread"
+	RULE_TEMPERATURE_SPREAD = "Cell temperature spread"
+)
+
+type Limits struct {
+	VoltageSpread     float32
+	TemperatureSpread float32
+	SOCSwing          float32
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		VoltageSpread:     DEFAULT_VOLTAGE_SPREAD,
+		TemperatureSpread: DEFAULT_TEMPERATURE_SPREAD,
+		SOCSwing:          DEFAULT_SOC_SWING,
+	}
+}
+
+// Spread summarises the cell voltage spread within one SOC band.
+type Spread struct {
+	Band    string // Like "20-40"
+	Samples int
+	Max     float32
+	Average float32
+}
+
+// Health is the state of one battery after a BMS reading.
+type Health struct {
+	SerialNumber   string
+	InverterSerial string
+	Time           time.Time
+
+	SOC                     float32
+	SOH                     float32
+	Cycle_Count             uint16
+	Cell_Voltage_Spread     float32 // V
+	Cell_Temperature_Spread float32 // °C
+	Spread_By_SOC           [SOC_BANDS]Spread
+
+	// Estimated_Capacity is counted from the battery current between SOC
+	// points, 0 until the SOC moved far enough once
+	Estimated_Capacity float32 // Ah
+	Nominal_Capacity   float32 // Ah, as the BMS reports it
+	Capacity_Ratio     float32
+	// SOH_Trend is the change of SOH in percent per 30 days
+	SOH_Trend float32
+}
+
+type sohPoint struct {
+	day time.Time
+	soh float32
+}
+
+type battery struct {
+	health   Health
+	haveSOC  bool
+	sums     [SOC_BANDS]float64
+	counting bool
+	startSOC float32
+	charge   float64 // Ah counted since startSOC
+	current  float32 // Battery current of the last reading in A
+	last     time.Time
+	history  []sohPoint
+	warnings map[string]bool
+}
+
+type Monitor struct {
+	Limits Limits
+
+	lock      sync.Mutex
+	batteries map[string]*battery
+}
+
+func NewMonitor(limits Limits) *Monitor {
+	return &Monitor{Limits: limits, batteries: make(map[string]*battery)}
+}
+
+// Update adds log to the battery of its inverter. SOC and SOH come from
+// Section1 and are kept for the BMS data of Section3, which produces a
+// Health and the warnings that were raised or cleared.
+func (monitor *Monitor) Update(log luxproto.LogData) (Health, []luxalert.Event, bool) {
+	now := log.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+
+	key := log.SerialNumber + "/" + log.InverterSerial
+	current, ok := monitor.batteries[key]
+	if !ok {
+		current = &battery{warnings: make(map[string]bool)}
+		current.health.SerialNumber = log.SerialNumber
+		current.health.InverterSerial = log.InverterSerial
+		monitor.batteries[key] = current
+	}
+
+	if log.Section1.Loaded {
+		current.health.SOC = log.Section1.SOC
+		current.health.SOH = log.Section1.SOH
+		current.haveSOC = true
+		current.trackSOH(now)
+	}
+	if !log.Section3.Loaded {
+		return Health{}, nil, false
+	}
+
+	section := log.Section3
+	health := &current.health
+	health.Time = now
+	health.Cycle_Count = section.Cycle_Count
+	health.Nominal_Capacity = section.Battery_Capacity
+	health.Cell_Voltage_Spread = section.MaxCell_Voltage - section.MinCell_Voltage
+	health.Cell_Temperature_Spread = section.MaxCell_Temp - section.MinCell_Temp
+
+	if current.haveSOC {
+		current.addSpread()
+		current.count(section.Battery_Current, now, monitor.Limits)
+	}
+	current.current = section.Battery_Current
+	current.last = now
+	if health.Estimated_Capacity > 0 && health.Nominal_Capacity > 0 {
+		health.Capacity_Ratio = health.Estimated_Capacity / health.Nominal_Capacity
+	}
+
+	events := []luxalert.Event{}
+	events = current.warn(events, RULE_VOLTAGE_SPREAD, "V", health.Cell_Voltage_Spread, monitor.Limits.VoltageSpread)
+	events = current.warn(events, RULE_TEMPERATURE_SPREAD, "°C", health.Cell_Temperature_Spread, monitor.Limits.TemperatureSpread)
+	return *health, events, true
+}
+
+// band returns the SOC band soc falls into.
+func band(soc float32) int {
+	index := int(soc) * SOC_BANDS / 100
+	if index >= SOC_BANDS {
+		index = SOC_BANDS - 1
+	}
+	if index < 0 {
+		index = 0
+	}
+	return index
+}
+
+func (current *battery) addSpread() {
+	health := &current.health
+	index := band(health.SOC)
+	spread := &health.Spread_By_SOC[index]
+	if spread.Band == "" {
+		spread.Band = fmt.Sprintf("%d-%d", index*100/SOC_BANDS, (index+1)*100/SOC_BANDS)
+	}
+	value := health.Cell_Voltage_Spread
+	if spread.Samples == 0 || value > spread.Max {
+		spread.Max = value
+	}
+	current.sums[index] += float64(value)
+	spread.Samples++
+	spread.Average = float32(current.sums[index] / float64(spread.Samples))
+}
+
+// count integrates the battery current since the last reading. Once the SOC
+// moved by the swing of limits in one direction the charge that flowed gives
+// the capacity.
+func (current *battery) count(amps float32, now time.Time, limits Limits) {
+	health := &current.health
+	gap := now.Sub(current.last)
+	if !current.counting || gap > MAX_SAMPLE_GAP || gap < 0 {
+		current.counting = true
+		current.startSOC = health.SOC
+		current.charge = 0
+		return
+	}
+
+	// Hold the current of the last reading until this one
+	current.charge += float64(current.current) * gap.Hours()
+	swing := health.SOC - current.startSOC
+
+	// A change of direction starts over from here
+	if swing != 0 && (swing > 0) != (current.charge > 0) {
+		current.startSOC = health.SOC
+		current.charge = 0
+		return
+	}
+	if float32(math.Abs(float64(swing))) < limits.SOCSwing {
+		return
+	}
+
+	capacity := float32(math.Abs(current.charge) * 100 / math.Abs(float64(swing)))
+	if health.Estimated_Capacity == 0 {
+		health.Estimated_Capacity = capacity
+	} else {
+		health.Estimated_Capacity += CAPACITY_SMOOTHING * (capacity - health.Estimated_Capacity)
+	}
+	current.startSOC = health.SOC
+	current.charge = 0
+}
+
+// trackSOH keeps one SOH value per day and fits the trend through them.
+func (current *battery) trackSOH(now time.Time) {
+	day := now.Truncate(24 * time.Hour)
+	history := current.history
+	if len(history) > 0 && history[len(history)-1].day.Equal(day) {
+		history[len(history)-1].soh = current.health.SOH
+	} else {
+		history = append(history, sohPoint{day, current.health.SOH})
+		if len(history) > SOH_HISTORY {
+			history = history[1:]
+		}
+	}
+	current.history = history
+	current.health.SOH_Trend = trend(history)
+}
+
+// trend is the least squares slope of history in SOH per 30 days.
+func trend(history []sohPoint) float32 {
+	if len(history) < 2 {
+		return 0
+	}
+	first := history[0].day
+	var sumX, sumY, sumXY, sumXX float64
+	for _, point := range history {
+		x := point.day.Sub(first).Hours() / 24
+		y := float64(point.soh)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	n := float64(len(history))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return float32(30 * (n*sumXY - sumX*sumY) / denominator)
+}
+
+// warn raises the warning rule when value is past limit and clears it once
+// value is back below CLEAR_RATIO of the limit.
+func (current *battery) warn(events []luxalert.Event, rule string, unit string, value float32, limit float32) []luxalert.Event {
+	if limit <= 0 {
+		return events
+	}
+	raised := current.warnings[rule]
+	state := ""
+	switch {
+	case !raised && value > limit:
+		state = luxalert.STATE_FIRING
+	case raised && value < limit*CLEAR_RATIO:
+		state = luxalert.STATE_RESOLVED
+	default:
+		return events
+	}
+	current.warnings[rule] = state == luxalert.STATE_FIRING
+
+	health := current.health
+	serial := health.InverterSerial
+	if serial == "" {
+		serial = health.SerialNumber
+	}
+	message := fmt.Sprintf("%s of %s is %g %s, above %g %s", rule, serial, value, unit, limit, unit)
+	if state == luxalert.STATE_RESOLVED {
+		message = fmt.Sprintf("%s resolved: %s is %g %s", rule, serial, value, unit)
+	}
+	return append(events, luxalert.Event{
+		Rule:           rule,
+		Severity:       luxalert.SEVERITY_WARNING,
+		State:          state,
+		SerialNumber:   health.SerialNumber,
+		InverterSerial: health.InverterSerial,
+		Value:          float64(value),
+		Threshold:      float64(limit),
+		Time:           health.Time,
+		Message:        message,
+	})
+}
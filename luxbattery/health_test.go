@@ -0,0 +1,129 @@
+package luxbattery
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"LuxLogger/luxalert"
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtest"
+)
+
+// cells is the BMS block of a 100 Ah battery with current and the cell
+// voltages.
+func cells(current float32, maxCell float32, minCell float32) luxproto.LogDataSection3 {
+	return luxproto.LogDataSection3{
+		Battery_Capacity: 100,
+		Battery_Current:  current,
+		MaxCell_Voltage:  maxCell,
+		MinCell_Voltage:  minCell,
+		MaxCell_Temp:     25,
+		MinCell_Temp:     23,
+	}
+}
+
+func near(a float32, b float32) bool {
+	return math.Abs(float64(a-b)) < 0.01
+}
+
+func TestCapacityFromCharge(t *testing.T) {
+	monitor := NewMonitor(DefaultLimits())
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	// 20 A into a 100 Ah battery raises the SOC by a third of a percent a minute
+	var health Health
+	for minute := 0; minute <= 60; minute++ {
+		soc := float32(math.Floor(20 + float64(minute)/3))
+		var ok bool
+		health, _, ok = monitor.Update(luxtest.Frame(start.Add(time.Duration(minute)*time.Minute), luxproto.LogDataSection1{SOC: soc, SOH: 100}, cells(20, 3.35, 3.33)))
+		if !ok {
+			t.Fatalf("no health at minute %d", minute)
+		}
+		if minute < 60 && health.Estimated_Capacity != 0 {
+			t.Fatalf("capacity %v estimated at minute %d", health.Estimated_Capacity, minute)
+		}
+	}
+	if !near(health.Estimated_Capacity, 100) || !near(health.Capacity_Ratio, 1) {
+		t.Errorf("capacity %v ratio %v", health.Estimated_Capacity, health.Capacity_Ratio)
+	}
+	if !near(health.Cell_Voltage_Spread, 0.02) || !near(health.Cell_Temperature_Spread, 2) {
+		t.Errorf("spread %v V %v °C", health.Cell_Voltage_Spread, health.Cell_Temperature_Spread)
+	}
+	bands := health.Spread_By_SOC
+	if bands[1].Band != "20-40" || bands[1].Samples != 60 || bands[2].Samples != 1 || bands[0].Samples != 0 {
+		t.Errorf("bands %+v", bands)
+	}
+}
+
+func TestCountingRestarts(t *testing.T) {
+	monitor := NewMonitor(DefaultLimits())
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	// Charging, then discharging past the start and a gap in the readings
+	readings := []struct {
+		minute  int
+		soc     float32
+		current float32
+	}{
+		{0, 50, 20},
+		{30, 60, -20},
+		{60, 45, -20},
+		{120, 30, -20},
+	}
+	for _, reading := range readings {
+		health, _, _ := monitor.Update(luxtest.Frame(start.Add(time.Duration(reading.minute)*time.Minute), luxproto.LogDataSection1{SOC: reading.soc, SOH: 100}, cells(reading.current, 3.35, 3.33)))
+		if health.Estimated_Capacity != 0 {
+			t.Errorf("capacity %v estimated at minute %d", health.Estimated_Capacity, reading.minute)
+		}
+	}
+}
+
+func TestSpreadWarnings(t *testing.T) {
+	monitor := NewMonitor(DefaultLimits())
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	spreads := []struct {
+		spread float32
+		state  string
+	}{
+		{0.02, ""},
+		{0.15, luxalert.STATE_FIRING},
+		{0.09, ""}, // Below the limit, not yet clear
+		{0.06, luxalert.STATE_RESOLVED},
+	}
+	for i, reading := range spreads {
+		_, events, _ := monitor.Update(luxtest.Frame(start.Add(time.Duration(i)*time.Minute), luxproto.LogDataSection1{SOC: 50, SOH: 100}, cells(0, 3.3+reading.spread, 3.3)))
+		if reading.state == "" {
+			if len(events) != 0 {
+				t.Errorf("spread %v raised %+v", reading.spread, events)
+			}
+			continue
+		}
+		if len(events) != 1 || events[0].State != reading.state || events[0].Rule != RULE_VOLTAGE_SPREAD {
+			t.Errorf("spread %v raised %+v", reading.spread, events)
+		}
+	}
+
+	log := luxtest.Frame(start.Add(time.Hour), luxproto.LogDataSection1{SOC: 50, SOH: 100}, cells(0, 3.3, 3.3))
+	log.Section3.MaxCell_Temp = 35
+	_, events, _ := monitor.Update(log)
+	if len(events) != 1 || events[0].Rule != RULE_TEMPERATURE_SPREAD || events[0].Severity != luxalert.SEVERITY_WARNING {
+		t.Errorf("temperature spread raised %+v", events)
+	}
+}
+
+func TestSOHTrend(t *testing.T) {
+	monitor := NewMonitor(DefaultLimits())
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var health Health
+	for day := 0; day <= 30; day++ {
+		log := luxtest.Frame(start.AddDate(0, 0, day), luxproto.LogDataSection1{SOC: 50, SOH: 100}, cells(0, 3.3, 3.3))
+		log.Section1.SOH = 100 - float32(day)/30
+		health, _, _ = monitor.Update(log)
+	}
+	if !near(health.SOH_Trend, -1) {
+		t.Errorf("SOH trend %v", health.SOH_Trend)
+	}
+}
@@ -0,0 +1,149 @@
+package luxbattery
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+const (
+	DEFAULT_EMPTY_SOC = 10
+	DEFAULT_FULL_SOC  = 100
+	DEFAULT_SMOOTHING = 10 * time.Minute
+	// IDLE_POWER is the smoothed battery power in W below which the battery
+	// counts as idle and neither time is estimated
+	IDLE_POWER = 50
+)
+
+// RuntimeLimits are the SOC the inverter stops discharging at and charges up
+// to, and the time constant the battery power is smoothed with.
+type RuntimeLimits struct {
+	EmptySOC  float32
+	FullSOC   float32
+	Smoothing time.Duration
+}
+
+func DefaultRuntimeLimits() RuntimeLimits {
+	return RuntimeLimits{
+		EmptySOC:  DEFAULT_EMPTY_SOC,
+		FullSOC:   DEFAULT_FULL_SOC,
+		Smoothing: DEFAULT_SMOOTHING,
+	}
+}
+
+type runtime struct {
+	capacity  float32 // Ah
+	cutoff    float32 // V
+	power     float32 // Smoothed battery power in W
+	deviation float32 // Smoothed deviation of the power from power
+	since     time.Time
+	last      time.Time
+}
+
+// Estimator works out how long the battery lasts at the current discharge
+// power and how long it takes to fill at the current charge power.
+type Estimator struct {
+	Limits RuntimeLimits
+
+	lock     sync.Mutex
+	runtimes map[string]*runtime
+}
+
+func NewEstimator(limits RuntimeLimits) *Estimator {
+	return &Estimator{Limits: limits, runtimes: make(map[string]*runtime)}
+}
+
+// Estimate fills in Time_To_Empty, Time_To_Full and Runtime_Confidence of
+// the derived fields of log. The capacity and cutoff voltage come from the
+// last Section3 of the inverter, so there is no estimate before one arrived.
+func (estimator *Estimator) Estimate(log *luxproto.LogData) {
+	now := log.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	estimator.lock.Lock()
+	defer estimator.lock.Unlock()
+
+	key := log.SerialNumber + "/" + log.InverterSerial
+	current, ok := estimator.runtimes[key]
+	if !ok {
+		current = &runtime{}
+		estimator.runtimes[key] = current
+	}
+
+	if log.Section3.Loaded {
+		current.capacity = log.Section3.Battery_Capacity
+		current.cutoff = log.Section3.BMS_Discharge_Cutoff
+	}
+	if !log.Derived.Loaded {
+		return
+	}
+
+	power := log.Derived.Battery_Power
+	gap := now.Sub(current.last)
+	if current.last.IsZero() || gap > MAX_SAMPLE_GAP || gap < 0 {
+		current.power = power
+		current.deviation = 0
+		current.since = now
+	} else {
+		alpha := float32(1)
+		if estimator.Limits.Smoothing > 0 {
+			alpha = float32(1 - math.Exp(-gap.Seconds()/estimator.Limits.Smoothing.Seconds()))
+		}
+		current.deviation += alpha * (abs(power-current.power) - current.deviation)
+		current.power += alpha * (power - current.power)
+	}
+	current.last = now
+
+	section := log.Section1
+	if current.capacity <= 0 || section.Battery_Voltage <= 0 {
+		return
+	}
+	energy := current.capacity * section.Battery_Voltage // Wh
+	if section.SOH > 0 {
+		energy *= section.SOH / 100
+	}
+
+	derived := &log.Derived
+	switch {
+	case current.power < -IDLE_POWER:
+		remaining := (section.SOC - estimator.Limits.EmptySOC) / 100 * energy
+		if remaining < 0 || (current.cutoff > 0 && section.Battery_Voltage <= current.cutoff) {
+			remaining = 0
+		}
+		derived.Time_To_Empty = remaining / -current.power * 60
+	case current.power > IDLE_POWER:
+		missing := (estimator.Limits.FullSOC - section.SOC) / 100 * energy
+		if missing < 0 {
+			missing = 0
+		}
+		derived.Time_To_Full = missing / current.power * 60
+	default:
+		return
+	}
+	derived.Runtime_Confidence = current.confidence(now, estimator.Limits.Smoothing)
+}
+
+// confidence grows while the smoothing settles and shrinks as the power
+// swings around its smoothed value.
+func (current *runtime) confidence(now time.Time, smoothing time.Duration) float32 {
+	warmup := float32(1)
+	if smoothing > 0 && now.Sub(current.since) < smoothing {
+		warmup = float32(now.Sub(current.since).Seconds() / smoothing.Seconds())
+	}
+	swing := current.deviation / abs(current.power)
+	if swing > 1 {
+		swing = 1
+	}
+	return warmup * (1 - swing)
+}
+
+func abs(value float32) float32 {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
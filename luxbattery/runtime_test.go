@@ -0,0 +1,87 @@
+package luxbattery
+
+import (
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtest"
+)
+
+func TestRuntimeNeedsCapacity(t *testing.T) {
+	estimator := NewEstimator(DefaultRuntimeLimits())
+	log := luxtest.Frame(time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC), luxproto.LogDataSection1{SOC: 60, SOH: 100, Battery_Voltage: 50, Discharge_Power: 1000})
+	estimator.Estimate(&log)
+	if log.Derived.Time_To_Empty != 0 || log.Derived.Runtime_Confidence != 0 {
+		t.Errorf("estimate without capacity %+v", log.Derived)
+	}
+}
+
+func TestTimeToEmpty(t *testing.T) {
+	estimator := NewEstimator(DefaultRuntimeLimits())
+	start := time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC)
+	block := luxtest.Frame(start, luxproto.LogDataSection3{Battery_Capacity: 100, BMS_Discharge_Cutoff: 46})
+	estimator.Estimate(&block)
+
+	// 5 kWh battery at 60 %, 2.5 kWh above the cutoff at 10 %, lasts 2.5 h
+	var log luxproto.LogData
+	for minute := 0; minute <= 20; minute++ {
+		log = luxtest.Frame(start.Add(time.Duration(minute)*time.Minute), luxproto.LogDataSection1{SOC: 60, SOH: 100, Battery_Voltage: 50, Discharge_Power: 1000})
+		estimator.Estimate(&log)
+		if minute == 0 && log.Derived.Runtime_Confidence != 0 {
+			t.Errorf("confidence %v on the first frame", log.Derived.Runtime_Confidence)
+		}
+	}
+	if !near(log.Derived.Time_To_Empty, 150) || log.Derived.Time_To_Full != 0 {
+		t.Errorf("time to empty %v full %v", log.Derived.Time_To_Empty, log.Derived.Time_To_Full)
+	}
+	if !near(log.Derived.Runtime_Confidence, 1) {
+		t.Errorf("steady confidence %v", log.Derived.Runtime_Confidence)
+	}
+
+	// Swinging power lowers the confidence
+	for minute := 21; minute <= 40; minute++ {
+		discharge := float32(200)
+		if minute%2 == 0 {
+			discharge = 1800
+		}
+		log = luxtest.Frame(start.Add(time.Duration(minute)*time.Minute), luxproto.LogDataSection1{SOC: 60, SOH: 100, Battery_Voltage: 50, Discharge_Power: discharge})
+		estimator.Estimate(&log)
+	}
+	if log.Derived.Runtime_Confidence > 0.8 || log.Derived.Runtime_Confidence <= 0 {
+		t.Errorf("swinging confidence %v", log.Derived.Runtime_Confidence)
+	}
+
+	// At the BMS cutoff voltage the battery is empty whatever the SOC
+	log = luxtest.Frame(start.Add(41*time.Minute), luxproto.LogDataSection1{SOC: 60, SOH: 100, Battery_Voltage: 50, Discharge_Power: 1000})
+	log.Section1.Battery_Voltage = 45.9
+	estimator.Estimate(&log)
+	if log.Derived.Time_To_Empty != 0 {
+		t.Errorf("time to empty %v below cutoff", log.Derived.Time_To_Empty)
+	}
+}
+
+func TestTimeToFull(t *testing.T) {
+	limits := DefaultRuntimeLimits()
+	limits.FullSOC = 90
+	estimator := NewEstimator(limits)
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	block := luxtest.Frame(start, luxproto.LogDataSection3{Battery_Capacity: 100, BMS_Discharge_Cutoff: 46})
+	estimator.Estimate(&block)
+
+	var log luxproto.LogData
+	for minute := 0; minute <= 10; minute++ {
+		log = luxtest.Frame(start.Add(time.Duration(minute)*time.Minute), luxproto.LogDataSection1{SOC: 50, SOH: 100, Battery_Voltage: 50, Charge_Power: 2000})
+		estimator.Estimate(&log)
+	}
+	// 40 % of 5 kWh at 2 kW
+	if !near(log.Derived.Time_To_Full, 60) || log.Derived.Time_To_Empty != 0 {
+		t.Errorf("time to full %v empty %v", log.Derived.Time_To_Full, log.Derived.Time_To_Empty)
+	}
+
+	log = luxtest.Frame(start.Add(11*time.Minute), luxproto.LogDataSection1{SOC: 50, SOH: 100, Battery_Voltage: 50, Charge_Power: 20})
+	estimator.Estimate(&log)
+	if log.Derived.Time_To_Full == 0 {
+		t.Error("one idle frame stopped the estimate")
+	}
+}
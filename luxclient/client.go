@@ -0,0 +1,430 @@
+// Package luxclient talks to a LuxPower Wi-Fi dongle over TCP. It reads the
+// frames the dongle pushes and sends register read and write requests to the
+// inverter behind it.
+package luxclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+var (
+	// ErrException is returned when the inverter answers a request with a
+	// Modbus exception.
+	ErrException = errors.New("inverter returned an exception")
+
+	// ErrTimeout is returned when no response arrived after all retries.
+	ErrTimeout = errors.New("no response from inverter")
+)
+
+const (
+	DEFAULT_TIMEOUT = 5 * time.Second
+	DEFAULT_RETRIES = 2
+)
+
+// Client is a connection to one dongle. Requests to different inverters
+// behind it may be sent from several goroutines at once.
+type Client struct {
+	// Unsolicited is called by Run with every frame that is not the response
+	// to a request, such as the input registers the dongle pushes.
+	Unsolicited func(frame []byte)
+
+	// Timeout is how long one attempt of a request waits for its response
+	Timeout time.Duration
+	// Retries is how often a request is repeated after a timeout
+	Retries int
+
+	conn     net.Conn
+	received []byte
+	buffer   luxproto.FrameBuffer
+	frames   [][]byte
+	write    sync.Mutex
+	lock     sync.Mutex
+	datalog  [10]byte
+	queues   map[[10]byte]chan struct{}
+	modifies map[[10]byte]chan struct{}
+	waiting  []*waitingRequest
+	stopped  error
+}
+
+type waitingRequest struct {
+	request  luxproto.Message
+	response chan luxproto.Message
+}
+
+// Dial connects to the dongle at address, for example "mico.lan:8000".
+func Dial(ctx context.Context, address string) (*Client, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+// New uses an already established connection to a dongle.
+func New(conn net.Conn) *Client {
+	return &Client{
+		Timeout:  DEFAULT_TIMEOUT,
+		Retries:  DEFAULT_RETRIES,
+		conn:     conn,
+		received: make([]byte, luxproto.MAX_FRAME_LENGTH),
+		queues:   make(map[[10]byte]chan struct{}),
+		modifies: make(map[[10]byte]chan struct{}),
+	}
+}
+
+// Close closes the connection, which ends Run and fails the waiting
+// requests.
+func (client *Client) Close() error {
+	return client.conn.Close()
+}
+
+// Datalog returns the serial number of the dongle. It is learned from the
+// frames the dongle sends and is zero until the first one arrived.
+func (client *Client) Datalog() [10]byte {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return client.datalog
+}
+
+// SetDatalog sets the dongle serial number used in requests when it is known
+// up front.
+func (client *Client) SetDatalog(datalog [10]byte) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.datalog = datalog
+}
+
+// ReadFrame returns the next frame from the dongle. A timeout leaves the
+// client usable, partial frames are kept for the next read. Use Run instead
+// when sending requests.
+func (client *Client) ReadFrame(ctx context.Context) ([]byte, error) {
+	stop := client.watch(ctx, client.conn.SetReadDeadline)
+	defer stop()
+
+	for len(client.frames) == 0 {
+		numRead, err := client.conn.Read(client.received)
+		client.buffer.Write(client.received[:numRead], time.Time{}, func(frame []byte, _ time.Time) {
+			client.frames = append(client.frames, append([]byte(nil), frame...))
+		})
+		if err != nil && len(client.frames) == 0 {
+			return nil, contextError(ctx, err)
+		}
+	}
+
+	frame := client.frames[0]
+	client.frames = client.frames[1:]
+	if header, _, err := luxproto.ParseFrame(frame); err == nil {
+		client.lock.Lock()
+		client.datalog = header.SerialNumber
+		client.lock.Unlock()
+	}
+	return frame, nil
+}
+
+// WriteFrame sends a complete frame to the dongle.
+func (client *Client) WriteFrame(ctx context.Context, frame []byte) error {
+	client.write.Lock()
+	defer client.write.Unlock()
+
+	stop := client.watch(ctx, client.conn.SetWriteDeadline)
+	defer stop()
+
+	_, err := client.conn.Write(frame)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+// Run reads frames from the dongle until ctx is done or the connection
+// fails. Responses are handed to the waiting Request, all other frames go to
+// Unsolicited. Request only works while Run is running.
+func (client *Client) Run(ctx context.Context) error {
+	for {
+		frame, err := client.ReadFrame(ctx)
+		if err != nil {
+			client.lock.Lock()
+			client.stopped = err
+			for _, waiting := range client.waiting {
+				close(waiting.response)
+			}
+			client.waiting = nil
+			client.lock.Unlock()
+			return err
+		}
+
+		if !client.deliver(frame) && client.Unsolicited != nil {
+			client.Unsolicited(frame)
+		}
+	}
+}
+
+// deliver passes frame to the request waiting for it, if there is one.
+func (client *Client) deliver(frame []byte) bool {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	for i, waiting := range client.waiting {
+		if response, ok := Match(waiting.request, frame); ok {
+			waiting.response <- response
+			client.waiting = append(client.waiting[:i], client.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Request sends msg to the inverter and waits for the response with the same
+// device function, register and inverter serial. Requests to one inverter
+// are sent one at a time, an attempt that gets no response within Timeout
+// is repeated up to Retries times.
+func (client *Client) Request(ctx context.Context, msg luxproto.Message) (luxproto.Message, error) {
+	msg.Address = luxproto.ADDRESS_REQUEST
+
+	queue := client.queue(msg.SerialNumber)
+	select {
+	case queue <- struct{}{}:
+		defer func() { <-queue }()
+	case <-ctx.Done():
+		return luxproto.Message{}, ctx.Err()
+	}
+
+	for attempt := 0; ; attempt++ {
+		response, err := client.attempt(ctx, msg)
+		if err == ErrTimeout && attempt < client.Retries {
+			continue
+		}
+		if err != nil {
+			return luxproto.Message{}, err
+		}
+
+		if response.DeviceFunction&luxproto.DEVICE_EXCEPTION != 0 {
+			return response, ErrException
+		}
+		return response, nil
+	}
+}
+
+func (client *Client) attempt(ctx context.Context, msg luxproto.Message) (luxproto.Message, error) {
+	waiting := &waitingRequest{request: msg, response: make(chan luxproto.Message, 1)}
+	client.lock.Lock()
+	if client.stopped != nil {
+		client.lock.Unlock()
+		return luxproto.Message{}, client.stopped
+	}
+	client.waiting = append(client.waiting, waiting)
+	client.lock.Unlock()
+	defer client.forget(waiting)
+
+	timeout := time.NewTimer(client.Timeout)
+	defer timeout.Stop()
+
+	err := client.WriteFrame(ctx, luxproto.EncodeFrame(luxproto.FUNCTION_DATA, client.Datalog(), msg.Bytes()))
+	if err != nil {
+		return luxproto.Message{}, err
+	}
+
+	select {
+	case response, ok := <-waiting.response:
+		if !ok {
+			return luxproto.Message{}, client.stopped
+		}
+		return response, nil
+	case <-timeout.C:
+		return luxproto.Message{}, ErrTimeout
+	case <-ctx.Done():
+		return luxproto.Message{}, ctx.Err()
+	}
+}
+
+func (client *Client) forget(waiting *waitingRequest) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	for i := range client.waiting {
+		if client.waiting[i] == waiting {
+			client.waiting = append(client.waiting[:i], client.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// queue returns the channel that lets one request at a time through to an
+// inverter.
+func (client *Client) queue(inverter [10]byte) chan struct{} {
+	return client.slot(client.queues, inverter)
+}
+
+// slot returns the channel of inverter in slots, which lets one holder
+// through at a time.
+func (client *Client) slot(slots map[[10]byte]chan struct{}, inverter [10]byte) chan struct{} {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	slot, ok := slots[inverter]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		slots[inverter] = slot
+	}
+	return slot
+}
+
+// Match reports whether frame carries the response to request and returns
+// the response.
+func Match(request luxproto.Message, frame []byte) (luxproto.Message, bool) {
+	header, data, err := luxproto.ParseFrame(frame)
+	if err != nil || header.Function != luxproto.FUNCTION_DATA {
+		return luxproto.Message{}, false
+	}
+
+	response, err := luxproto.ParseMessage(data)
+	if err != nil || response.Address != luxproto.ADDRESS_RESPONSE {
+		return luxproto.Message{}, false
+	}
+
+	if response.DeviceFunction&^luxproto.DEVICE_EXCEPTION != request.DeviceFunction || response.Register != request.Register {
+		return luxproto.Message{}, false
+	}
+
+	if request.SerialNumber != ([10]byte{}) && response.SerialNumber != request.SerialNumber {
+		return luxproto.Message{}, false
+	}
+
+	return response, true
+}
+
+// ReadInput reads count input registers starting at register.
+func (client *Client) ReadInput(ctx context.Context, inverter [10]byte, register uint16, count uint16) ([]uint16, error) {
+	return client.read(ctx, luxproto.DEVICE_READINPUT, inverter, register, count)
+}
+
+// ReadHold reads count holding registers starting at register.
+func (client *Client) ReadHold(ctx context.Context, inverter [10]byte, register uint16, count uint16) ([]uint16, error) {
+	return client.read(ctx, luxproto.DEVICE_READHOLD, inverter, register, count)
+}
+
+func (client *Client) read(ctx context.Context, function uint8, inverter [10]byte, register uint16, count uint16) ([]uint16, error) {
+	response, err := client.Request(ctx, luxproto.Message{
+		DeviceFunction: function,
+		SerialNumber:   inverter,
+		Register:       register,
+		Count:          count,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Registers(), nil
+}
+
+// PollInput reads the input register blocks starting at blocks from inverter,
+// see luxproto.InputBlocks, and passes each one to frame as the data frame
+// the dongle would have pushed.
+func (client *Client) PollInput(ctx context.Context, inverter [10]byte, blocks []uint16, frame func(frame []byte)) error {
+	for _, register := range blocks {
+		response, err := client.Request(ctx, luxproto.Message{
+			DeviceFunction: luxproto.DEVICE_READINPUT,
+			SerialNumber:   inverter,
+			Register:       register,
+			Count:          luxproto.SECTION_REGISTERS,
+		})
+		if err != nil {
+			return err
+		}
+		frame(luxproto.EncodeFrame(luxproto.FUNCTION_DATA, client.Datalog(), response.Bytes()))
+	}
+	return nil
+}
+
+// WriteSingle writes one holding register.
+func (client *Client) WriteSingle(ctx context.Context, inverter [10]byte, register uint16, value uint16) error {
+	_, err := client.Request(ctx, luxproto.Message{
+		DeviceFunction: luxproto.DEVICE_WRITESINGLE,
+		SerialNumber:   inverter,
+		Register:       register,
+		Count:          1,
+		Values:         luxproto.EncodeRegisters([]uint16{value}),
+	})
+	return err
+}
+
+// Modify sets the bits of mask in a holding register to value and keeps the
+// others, writing only when that changes the register. Modifications of one
+// inverter run one at a time, so two of them never write back a stale read
+// of a register like HOLD_FUNCTIONS that several settings share. It returns
+// the register as it was read.
+func (client *Client) Modify(ctx context.Context, inverter [10]byte, register uint16, mask uint16, value uint16) (uint16, error) {
+	slot := client.slot(client.modifies, inverter)
+	select {
+	case slot <- struct{}{}:
+		defer func() { <-slot }()
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	values, err := client.ReadHold(ctx, inverter, register, 1)
+	if err != nil {
+		return 0, err
+	}
+	had := values[0]
+	if want := had&^mask | value&mask; want != had {
+		if err := client.WriteSingle(ctx, inverter, register, want); err != nil {
+			return had, err
+		}
+	}
+	return had, nil
+}
+
+// WriteMulti writes consecutive holding registers starting at register.
+func (client *Client) WriteMulti(ctx context.Context, inverter [10]byte, register uint16, values []uint16) error {
+	_, err := client.Request(ctx, luxproto.Message{
+		DeviceFunction: luxproto.DEVICE_WRITEMULTI,
+		SerialNumber:   inverter,
+		Register:       register,
+		Count:          uint16(len(values)),
+		Values:         luxproto.EncodeRegisters(values),
+	})
+	return err
+}
+
+// watch applies the deadline of ctx to the connection and interrupts blocked
+// I/O when ctx is cancelled. The returned function stops watching.
+func (client *Client) watch(ctx context.Context, setDeadline func(time.Time) error) func() {
+	deadline, _ := ctx.Deadline()
+	setDeadline(deadline)
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			setDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// contextError reports I/O interrupted by ctx as the error of ctx. The
+// connection deadline can expire just before the context notices.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) && errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,255 @@
+package luxclient
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxsim"
+)
+
+func startSimulator(t *testing.T) (*luxsim.Simulator, *Client) {
+	t.Helper()
+	sim := luxsim.NewSimulator("BA00000001", "0000000001")
+	sim.Interval = 50 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go sim.Serve(listener)
+
+	client, err := Dial(context.Background(), listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.SetDatalog(sim.DatalogSerial)
+	return sim, client
+}
+
+func TestReadWriteHolding(t *testing.T) {
+	sim, client := startSimulator(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	unsolicited := make(chan []byte, 100)
+	client.Unsolicited = func(frame []byte) {
+		unsolicited <- frame
+	}
+	go client.Run(ctx)
+
+	if err := client.WriteSingle(ctx, sim.InverterSerial, 21, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteMulti(ctx, sim.InverterSerial, 68, []uint16{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the simulator time to push data in between
+	time.Sleep(120 * time.Millisecond)
+
+	values, err := client.ReadHold(ctx, sim.InverterSerial, 20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[1] != 0x1234 {
+		t.Errorf("read back %v", values)
+	}
+	if sim.Holding(70) != 3 {
+		t.Errorf("register 70 is %d", sim.Holding(70))
+	}
+	if len(unsolicited) == 0 {
+		t.Error("pushed frames were not passed to Unsolicited")
+	}
+}
+
+func TestModify(t *testing.T) {
+	sim, client := startSimulator(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	go client.Run(ctx)
+
+	// Every bit set from its own goroutine survives the others
+	group := sync.WaitGroup{}
+	for bit := 0; bit < 8; bit++ {
+		group.Add(1)
+		go func(bit uint16) {
+			defer group.Done()
+			if _, err := client.Modify(ctx, sim.InverterSerial, 30, 1<<bit, 1<<bit); err != nil {
+				t.Error(err)
+			}
+		}(uint16(bit))
+	}
+	group.Wait()
+	if sim.Holding(30) != 0xFF {
+		t.Errorf("register 30 is %#x", sim.Holding(30))
+	}
+
+	had, err := client.Modify(ctx, sim.InverterSerial, 30, 0x0F, 0)
+	if err != nil || had != 0xFF || sim.Holding(30) != 0xF0 {
+		t.Errorf("had %#x, now %#x, %v", had, sim.Holding(30), err)
+	}
+}
+
+func TestReadFrameDeadline(t *testing.T) {
+	_, client := startSimulator(t)
+
+	// The simulator sends a heartbeat as soon as it accepts the connection
+	if _, err := client.ReadFrame(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.ReadFrame(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+
+	// The connection stays usable after a timeout
+	frame, err := client.ReadFrame(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := luxproto.LogData{}
+	if !log.Decode(frame, uint16(len(frame))) {
+		t.Error("pushed frame did not decode")
+	}
+}
+
+// fakeDongle answers requests on conn after delay, ignoring the first drop
+// requests. It records the largest number of requests waiting at once.
+type fakeDongle struct {
+	drop        int
+	delay       time.Duration
+	lock        sync.Mutex
+	received    int
+	outstanding int
+	maximum     int
+}
+
+func (fake *fakeDongle) serve(conn net.Conn) {
+	client := New(conn)
+	for {
+		frame, err := client.ReadFrame(context.Background())
+		if err != nil {
+			return
+		}
+		_, data, _ := luxproto.ParseFrame(frame)
+		request, _ := luxproto.ParseMessage(data)
+
+		fake.lock.Lock()
+		fake.received++
+		if fake.received <= fake.drop {
+			fake.lock.Unlock()
+			continue
+		}
+		fake.outstanding++
+		if fake.outstanding > fake.maximum {
+			fake.maximum = fake.outstanding
+		}
+		fake.lock.Unlock()
+
+		go func() {
+			time.Sleep(fake.delay)
+			response := request
+			response.Address = luxproto.ADDRESS_RESPONSE
+			response.Values = luxproto.EncodeRegisters(make([]uint16, request.Count))
+			fake.lock.Lock()
+			fake.outstanding--
+			fake.lock.Unlock()
+			client.WriteFrame(context.Background(), luxproto.EncodeFrame(luxproto.FUNCTION_DATA, [10]byte{}, response.Bytes()))
+		}()
+	}
+}
+
+func (fake *fakeDongle) counts() (int, int) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return fake.received, fake.maximum
+}
+
+func startFake(t *testing.T, fake *fakeDongle) *Client {
+	t.Helper()
+	local, remote := net.Pipe()
+	go fake.serve(remote)
+	client := New(local)
+	client.Timeout = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	go client.Run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		local.Close()
+		remote.Close()
+	})
+	return client
+}
+
+func TestRequestRetry(t *testing.T) {
+	fake := &fakeDongle{drop: 2}
+	client := startFake(t, fake)
+
+	_, err := client.ReadInput(context.Background(), [10]byte{1}, 0, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received, _ := fake.counts(); received != 3 {
+		t.Errorf("dongle received %d requests, expected 3", received)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	fake := &fakeDongle{drop: 10}
+	client := startFake(t, fake)
+	client.Retries = 1
+
+	_, err := client.ReadInput(context.Background(), [10]byte{1}, 0, 40)
+	if err != ErrTimeout {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if received, _ := fake.counts(); received != 2 {
+		t.Errorf("dongle received %d requests, expected 2", received)
+	}
+}
+
+func TestRequestContextDeadline(t *testing.T) {
+	fake := &fakeDongle{drop: 10}
+	client := startFake(t, fake)
+	client.Timeout = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.ReadHold(ctx, [10]byte{1}, 0, 40)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("request ignored the context deadline")
+	}
+}
+
+func TestRequestsQueuedPerInverter(t *testing.T) {
+	fake := &fakeDongle{delay: 5 * time.Millisecond}
+	client := startFake(t, fake)
+
+	group := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		group.Add(1)
+		go func(register uint16) {
+			defer group.Done()
+			if _, err := client.ReadHold(context.Background(), [10]byte{1}, register, 1); err != nil {
+				t.Error(err)
+			}
+		}(uint16(i))
+	}
+	group.Wait()
+
+	if _, maximum := fake.counts(); maximum != 1 {
+		t.Errorf("%d requests were in flight at once", maximum)
+	}
+}
@@ -0,0 +1,241 @@
+// Package luxdaily follows the *_Today counters of each inverter and closes
+// a day when they reset at the inverter's midnight, with a summary holding
+// the final totals and the extremes seen during the day.
+package luxdaily
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+const (
+	// ROLLOVER_GRACE is how long after local midnight a day stays open while
+	// the counters have not reset, for inverter clocks running behind.
+	ROLLOVER_GRACE = time.Hour
+	// RESET_LEFTOVER is the most a counter holds right after a reset, in kWh.
+	// Counters going down to no more than this reset at any time of day.
+	RESET_LEFTOVER = 0.5
+	DATE_LAYOUT    = "2006-01-02"
+)
+
+// Summary is one finished day of an inverter. Energies are in kWh, powers in
+// W and temperatures in °C.
+type Summary struct {
+	Date           string // Day in the inverter's time zone
+	SerialNumber   string
+	InverterSerial string
+	Start          time.Time // First and last data of the day
+	End            time.Time
+
+	PV_Energy       float32
+	Inverter_Energy float32
+	AC_Charging     float32
+	Charging        float32
+	Discharging     float32
+	EPS             float32
+	Exported        float32
+	Grid            float32
+	Load_Energy     float32
+
+	Peak_PV_Power            float32
+	Min_SOC                  float32
+	Max_SOC                  float32
+	Min_Inner_Temperature    float32
+	Max_Inner_Temperature    float32
+	Min_Radiator_Temperature float32
+	Max_Radiator_Temperature float32
+	Min_Battery_Temperature  float32
+	Max_Battery_Temperature  float32
+}
+
+// Tracker keeps the open day of every inverter.
+type Tracker struct {
+	// Location is the time zone of the inverters, local time when nil
+	Location *time.Location
+
+	lock sync.Mutex
+	days map[string]*day
+}
+
+type day struct {
+	summary          Summary
+	counters         []float32
+	haveSOC          bool
+	haveTemperatures bool
+}
+
+func NewTracker(location *time.Location) *Tracker {
+	return &Tracker{Location: location, days: make(map[string]*day)}
+}
+
+// Update adds log to the open day of its inverter. When log starts a new day
+// the summary of the previous one is returned.
+func (tracker *Tracker) Update(log luxproto.LogData) (Summary, bool) {
+	now := log.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	key := log.SerialNumber + "/" + log.InverterSerial
+	current := tracker.days[key]
+	finished := Summary{}
+	ended := current != nil && current.ended(log, now, tracker.location())
+	if ended {
+		finished = current.finish(tracker.location())
+		current = nil
+	}
+	if current == nil {
+		current = &day{summary: Summary{
+			SerialNumber:   log.SerialNumber,
+			InverterSerial: log.InverterSerial,
+			Start:          now,
+		}}
+		tracker.days[key] = current
+	}
+	current.add(log, now)
+
+	return finished, ended
+}
+
+func (tracker *Tracker) location() *time.Location {
+	if tracker.Location == nil {
+		return time.Local
+	}
+	return tracker.Location
+}
+
+// counters returns the *_Today registers of log, which only ever grow during
+// a day.
+func counters(log luxproto.LogData) []float32 {
+	section := log.Section1
+	return []float32{
+		section.PV1_Energy_Today,
+		section.PV2_Energy_Today,
+		section.PV3_Energy_Today,
+		section.ActiveInverter_Energy_Today,
+		section.AC_Charging_Today,
+		section.Charging_Today,
+		section.Discharging_Today,
+		section.EPS_Today,
+		section.Exported_Today,
+		section.Grid_Today,
+	}
+}
+
+// ended tells if log belongs to the next day: the counters reset, or the
+// day is over in location and the grace for a late reset has passed.
+func (current *day) ended(log luxproto.LogData, now time.Time, location *time.Location) bool {
+	if log.Section1.Loaded && current.counters != nil && current.reset(counters(log), now, location) {
+		return true
+	}
+
+	// A day started by an early reset belongs to the date after it
+	start := current.summary.Start.Add(ROLLOVER_GRACE).In(location)
+	midnight := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, location)
+	return !now.Before(midnight.Add(ROLLOVER_GRACE))
+}
+
+// reset tells if values are the counters after a reset. A counter going
+// down only counts near midnight in location, or when all of them are back
+// near zero, so a glitch in one register does not end the day.
+func (current *day) reset(values []float32, now time.Time, location *time.Location) bool {
+	down := false
+	nearZero := true
+	for i, value := range values {
+		if value < current.counters[i] {
+			down = true
+		}
+		if value > RESET_LEFTOVER {
+			nearZero = false
+		}
+	}
+	if !down {
+		return false
+	}
+
+	local := now.In(location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	sinceMidnight := local.Sub(midnight)
+	nearMidnight := sinceMidnight <= ROLLOVER_GRACE || sinceMidnight >= 24*time.Hour-ROLLOVER_GRACE
+	return nearMidnight || nearZero
+}
+
+func (current *day) add(log luxproto.LogData, now time.Time) {
+	summary := &current.summary
+	summary.End = now
+
+	if log.Section1.Loaded {
+		current.counters = counters(log)
+		summary.PV_Energy = log.Derived.PV_Energy_Today
+		summary.Inverter_Energy = log.Section1.ActiveInverter_Energy_Today
+		summary.AC_Charging = log.Section1.AC_Charging_Today
+		summary.Charging = log.Section1.Charging_Today
+		summary.Discharging = log.Section1.Discharging_Today
+		summary.EPS = log.Section1.EPS_Today
+		summary.Exported = log.Section1.Exported_Today
+		summary.Grid = log.Section1.Grid_Today
+		summary.Load_Energy = log.Derived.Load_Energy_Today
+
+		if log.Derived.PV_Power > summary.Peak_PV_Power {
+			summary.Peak_PV_Power = log.Derived.PV_Power
+		}
+		soc := log.Section1.SOC
+		if !current.haveSOC || soc < summary.Min_SOC {
+			summary.Min_SOC = soc
+		}
+		if !current.haveSOC || soc > summary.Max_SOC {
+			summary.Max_SOC = soc
+		}
+		current.haveSOC = true
+	}
+
+	if log.Section2.Loaded {
+		section := log.Section2
+		first := !current.haveTemperatures
+		extremes(&summary.Min_Inner_Temperature, &summary.Max_Inner_Temperature, section.Inner_Temperature, first)
+		extremes(&summary.Min_Radiator_Temperature, &summary.Max_Radiator_Temperature, section.Radiator1_Temperature, first)
+		extremes(&summary.Min_Battery_Temperature, &summary.Max_Battery_Temperature, section.Battery_Temperature, first)
+		current.haveTemperatures = true
+	}
+}
+
+func extremes(min *float32, max *float32, value float32, first bool) {
+	if first || value < *min {
+		*min = value
+	}
+	if first || value > *max {
+		*max = value
+	}
+}
+
+// finish dates the summary by the middle of the data, which stays on the
+// right day when the inverter clock is a little off.
+func (current *day) finish(location *time.Location) Summary {
+	summary := current.summary
+	middle := summary.Start.Add(summary.End.Sub(summary.Start) / 2)
+	summary.Date = middle.In(location).Format(DATE_LAYOUT)
+	return summary
+}
+
+// AppendFile adds summary as one JSON line to the file at path.
+func AppendFile(path string, summary Summary) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	line, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
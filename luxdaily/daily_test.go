@@ -0,0 +1,147 @@
+package luxdaily
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtest"
+)
+
+func TestCounterReset(t *testing.T) {
+	location := time.FixedZone("SAST", 2*60*60)
+	tracker := NewTracker(location)
+	start := time.Date(2024, 6, 1, 6, 0, 0, 0, location)
+
+	steps := []struct {
+		at    time.Duration
+		pv    float32
+		soc   float32
+		ended bool
+	}{
+		{0, 0, 40, false},
+		{6 * time.Hour, 3, 90, false},
+		{12 * time.Hour, 5, 70, false},
+		// The inverter clock runs a few minutes ahead
+		{17*time.Hour + 55*time.Minute, 0, 60, true},
+		{18 * time.Hour, 0.1, 60, false},
+	}
+
+	for i, step := range steps {
+		summary, ended := tracker.Update(luxtest.Frame(start.Add(step.at), luxproto.LogDataSection1{PV1_Energy_Today: step.pv, PV1_Power: 1000 * step.pv, SOC: step.soc}))
+		if ended != step.ended {
+			t.Fatalf("step %d ended %v", i, ended)
+		}
+		if !ended {
+			continue
+		}
+
+		if summary.Date != "2024-06-01" {
+			t.Errorf("summary of %s", summary.Date)
+		}
+		if summary.PV_Energy != 5 || summary.Peak_PV_Power != 5000 {
+			t.Errorf("PV %v kWh, peak %v W", summary.PV_Energy, summary.Peak_PV_Power)
+		}
+		if summary.Min_SOC != 40 || summary.Max_SOC != 90 {
+			t.Errorf("SOC between %v and %v", summary.Min_SOC, summary.Max_SOC)
+		}
+		if !summary.End.Equal(start.Add(12 * time.Hour)) {
+			t.Errorf("day ended at %v", summary.End)
+		}
+	}
+}
+
+func TestCounterGlitch(t *testing.T) {
+	location := time.FixedZone("SAST", 2*60*60)
+	tracker := NewTracker(location)
+	morning := time.Date(2024, 6, 1, 8, 0, 0, 0, location)
+
+	steps := []struct {
+		at    time.Duration
+		pv    float32
+		ended bool
+	}{
+		{0, 1, false},
+		{2 * time.Hour, 5, false},
+		// A counter going down during the day is not a reset
+		{2*time.Hour + 5*time.Minute, 4, false},
+		{3 * time.Hour, 6, false},
+		// Back to zero is, even with the inverter clock far off
+		{4 * time.Hour, 0.2, true},
+	}
+	for i, step := range steps {
+		if _, ended := tracker.Update(luxtest.Frame(morning.Add(step.at), luxproto.LogDataSection1{PV1_Energy_Today: step.pv, PV1_Power: 1000 * step.pv, SOC: 50})); ended != step.ended {
+			t.Errorf("step %d ended %v", i, ended)
+		}
+	}
+}
+
+func TestMidnightWithoutReset(t *testing.T) {
+	location := time.FixedZone("SAST", 2*60*60)
+	tracker := NewTracker(location)
+	evening := time.Date(2024, 6, 1, 20, 0, 0, 0, location)
+
+	tracker.Update(luxtest.Frame(evening, luxproto.LogDataSection1{PV1_Energy_Today: 4, PV1_Power: 4000, SOC: 50}))
+	// Counters that have not reset yet still count for the old day
+	if _, ended := tracker.Update(luxtest.Frame(evening.Add(4*time.Hour+30*time.Minute), luxproto.LogDataSection1{PV1_Energy_Today: 4, PV1_Power: 4000, SOC: 45})); ended {
+		t.Error("day ended within the grace")
+	}
+	summary, ended := tracker.Update(luxtest.Frame(evening.Add(6*time.Hour), luxproto.LogDataSection1{PV1_Energy_Today: 4, PV1_Power: 4000, SOC: 44}))
+	if !ended || summary.Date != "2024-06-01" {
+		t.Errorf("ended %v, summary of %s", ended, summary.Date)
+	}
+}
+
+func TestInvertersApart(t *testing.T) {
+	tracker := NewTracker(time.UTC)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	first := luxtest.Frame(now, luxproto.LogDataSection1{PV1_Energy_Today: 5, PV1_Power: 5000, SOC: 50})
+	second := luxtest.Frame(now, luxproto.LogDataSection1{PV1_Energy_Today: 1, PV1_Power: 1000, SOC: 50})
+	second.InverterSerial = "0000000002"
+	tracker.Update(first)
+	if _, ended := tracker.Update(second); ended {
+		t.Error("second inverter ended the day of the first")
+	}
+}
+
+func TestTemperatures(t *testing.T) {
+	tracker := NewTracker(time.UTC)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, temperature := range []float32{30, -5, 45} {
+		log := luxproto.LogData{Time: now.Add(time.Duration(i) * time.Minute)}
+		log.Section2.Loaded = true
+		log.Section2.Battery_Temperature = temperature
+		tracker.Update(log)
+	}
+	summary, ended := tracker.Update(luxproto.LogData{Time: now.Add(48 * time.Hour)})
+	if !ended || summary.Min_Battery_Temperature != -5 || summary.Max_Battery_Temperature != 45 {
+		t.Errorf("ended %v, battery between %v and %v", ended, summary.Min_Battery_Temperature, summary.Max_Battery_Temperature)
+	}
+}
+
+func TestAppendFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daily.jsonl")
+	for _, date := range []string{"2024-06-01", "2024-06-02"} {
+		if err := AppendFile(path, Summary{Date: date}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for _, date := range []string{"2024-06-01", "2024-06-02"} {
+		summary := Summary{}
+		if err := decoder.Decode(&summary); err != nil || summary.Date != date {
+			t.Errorf("read %q, %v", summary.Date, err)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+// Package luxexport holds the power fed into the grid at a setpoint by
+// adjusting the export limit of the inverters. Each frame moves the limit
+// toward the setpoint, a step at a time. When the data stops coming the
+// limit falls back to a fixed fail-safe value.
+package luxexport
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtime"
+)
+
+const (
+	DEFAULT_DEADBAND    = 100 // W
+	DEFAULT_MAX_STEP    = 10  // Percent of the rated power per write
+	DEFAULT_STALE_AFTER = 2 * time.Minute
+	// DEFAULT_MIN_INTERVAL spares the inverter settings memory from a write
+	// on every frame
+	DEFAULT_MIN_INTERVAL = 30 * time.Second
+	// REQUEST_TIMEOUT limits each control step fed by Feed
+	REQUEST_TIMEOUT = 30 * time.Second
+)
+
+// Band is a setpoint for a time of day range, End before Start spans
+// midnight.
+type Band struct {
+	Start  string // Like "10:00"
+	End    string
+	Export float32 // W
+
+	start int // Minutes after midnight
+	end   int
+}
+
+type Config struct {
+	// Setpoint is the export in W held outside of the bands, 0 for zero
+	// export
+	Setpoint float32
+	Bands    []Band
+	// Deadband is how far in W the export may stray from the setpoint
+	// before the limit is touched
+	Deadband float32
+	// MaxStep limits each change of the limit, in percent, and MinInterval
+	// the time between changes
+	MaxStep     float32
+	MinInterval time.Duration
+	// RatedPower of the inverter in W, the limit register is a percentage
+	RatedPower float32
+	// FailSafe is the limit in percent written once no data came for
+	// StaleAfter
+	FailSafe   uint16
+	StaleAfter time.Duration
+	Location   *time.Location
+}
+
+// Inverter reads and writes holding registers, like luxclient.Client.
+type Inverter interface {
+	ReadHold(ctx context.Context, inverter [10]byte, register uint16, count uint16) ([]uint16, error)
+	WriteSingle(ctx context.Context, inverter [10]byte, register uint16, value uint16) error
+	Modify(ctx context.Context, inverter [10]byte, register uint16, mask uint16, value uint16) (uint16, error)
+}
+
+// Adjustment is a write of the export limit.
+type Adjustment struct {
+	SerialNumber   string // Dongle serial number of the last frame
+	InverterSerial string
+	Time           time.Time
+	Export         float32 // W, measured
+	Setpoint       float32 // W
+	From           uint16  // Percent
+	To             uint16
+	Stale          bool // Fail-safe written because data stopped
+}
+
+func (adjustment Adjustment) String() string {
+	if adjustment.Stale {
+		return fmt.Sprintf("export limit of %s from %d%% to fail-safe %d%%, no data", adjustment.InverterSerial, adjustment.From, adjustment.To)
+	}
+	return fmt.Sprintf("export limit of %s from %d%% to %d%%, exporting %g W for %g W", adjustment.InverterSerial, adjustment.From, adjustment.To, adjustment.Export, adjustment.Setpoint)
+}
+
+// controlled is the state of one inverter. Its lock is held while talking
+// to the inverter, so one slow inverter does not hold up the others.
+type controlled struct {
+	serial [10]byte
+
+	// Guarded by Controller.lock
+	last    time.Time // Latest data
+	pending *luxproto.LogData
+	via     Inverter
+	running bool
+	wake    chan struct{}
+
+	lock     sync.Mutex
+	inverter Inverter
+	datalog  string // Dongle serial number of the latest data
+	limit    uint16
+	known    bool // limit was read or written
+	written  time.Time
+	stale    bool
+}
+
+type Controller struct {
+	// Adjusted is called with every change of a limit made for Feed
+	Adjusted func(adjustment Adjustment)
+
+	config Config
+
+	lock      sync.Mutex
+	inverters map[[10]byte]*controlled
+}
+
+// NewController checks the bands of config and fills in the defaults for
+// its zero values.
+func NewController(config Config) (*Controller, error) {
+	if config.RatedPower <= 0 {
+		return nil, fmt.Errorf("rated power needed for the export limit")
+	}
+	if config.FailSafe > 100 {
+		return nil, fmt.Errorf("fail-safe %d%% is not a percentage", config.FailSafe)
+	}
+	if config.Deadband <= 0 {
+		config.Deadband = DEFAULT_DEADBAND
+	}
+	if config.MaxStep <= 0 {
+		config.MaxStep = DEFAULT_MAX_STEP
+	}
+	if config.MinInterval <= 0 {
+		config.MinInterval = DEFAULT_MIN_INTERVAL
+	}
+	if config.StaleAfter <= 0 {
+		config.StaleAfter = DEFAULT_STALE_AFTER
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	for i := range config.Bands {
+		band := &config.Bands[i]
+		var err error
+		if band.start, err = luxtime.Minutes(band.Start); err != nil {
+			return nil, err
+		}
+		if band.end, err = luxtime.Minutes(band.End); err != nil {
+			return nil, err
+		}
+	}
+	return &Controller{config: config, inverters: make(map[[10]byte]*controlled)}, nil
+}
+
+// Setpoint returns the export to hold at at.
+func (controller *Controller) Setpoint(at time.Time) float32 {
+	local := at.In(controller.config.Location)
+	now := local.Hour()*60 + local.Minute()
+	for _, band := range controller.config.Bands {
+		inside := band.start <= now && now < band.end
+		if band.end <= band.start {
+			inside = now >= band.start || now < band.end
+		}
+		if inside {
+			return band.Export
+		}
+	}
+	return controller.config.Setpoint
+}
+
+// track returns the state of serial, noting data came at now.
+func (controller *Controller) track(serial [10]byte, now time.Time) *controlled {
+	controller.lock.Lock()
+	defer controller.lock.Unlock()
+
+	current, ok := controller.inverters[serial]
+	if !ok {
+		current = &controlled{serial: serial, wake: make(chan struct{}, 1)}
+		controller.inverters[serial] = current
+	}
+	if now.After(current.last) {
+		current.last = now
+	}
+	return current
+}
+
+// Feed hands log to the goroutine controlling serial, started on the first
+// frame, and returns at once. A frame still waiting is replaced by log.
+func (controller *Controller) Feed(ctx context.Context, inverter Inverter, serial [10]byte, log luxproto.LogData) {
+	if !log.Section1.Loaded {
+		return
+	}
+	now := log.Time
+	if now.IsZero() {
+		now = time.Now()
+		log.Time = now
+	}
+	current := controller.track(serial, now)
+
+	controller.lock.Lock()
+	current.pending = &log
+	current.via = inverter
+	start := !current.running
+	current.running = true
+	controller.lock.Unlock()
+
+	if start {
+		go controller.run(ctx, current)
+	}
+	select {
+	case current.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run controls the inverter of current with the latest frame fed to it
+// until ctx is done.
+func (controller *Controller) run(ctx context.Context, current *controlled) {
+	for {
+		select {
+		case <-current.wake:
+		case <-ctx.Done():
+			return
+		}
+
+		controller.lock.Lock()
+		log, inverter := current.pending, current.via
+		current.pending = nil
+		controller.lock.Unlock()
+		if log == nil {
+			continue
+		}
+
+		requestCtx, cancel := context.WithTimeout(ctx, REQUEST_TIMEOUT)
+		adjustment, changed, err := controller.Update(requestCtx, inverter, current.serial, *log)
+		cancel()
+		if err != nil {
+			println("Export limit of", string(current.serial[:]), "failed:", err.Error())
+			continue
+		}
+		if changed && controller.Adjusted != nil {
+			controller.Adjusted(adjustment)
+		}
+	}
+}
+
+// Update moves the export limit of serial toward the setpoint when log has
+// the grid power. The first time, and after a fail-safe, it enables the
+// export limit on the inverter and reads the limit.
+func (controller *Controller) Update(ctx context.Context, inverter Inverter, serial [10]byte, log luxproto.LogData) (Adjustment, bool, error) {
+	if !log.Section1.Loaded {
+		return Adjustment{}, false, nil
+	}
+	now := log.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	current := controller.track(serial, now)
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	current.inverter = inverter
+	current.datalog = log.SerialNumber
+	current.stale = false
+	if !current.known {
+		if err := current.enable(ctx); err != nil {
+			return Adjustment{}, false, err
+		}
+	}
+
+	config := controller.config
+	if now.Sub(current.written) < config.MinInterval {
+		return Adjustment{}, false, nil
+	}
+	export := log.Section1.Power_To_Grid - log.Section1.Power_From_Grid
+	setpoint := controller.Setpoint(now)
+	difference := export - setpoint
+	if float32(math.Abs(float64(difference))) <= config.Deadband {
+		return Adjustment{}, false, nil
+	}
+
+	// Exporting too much lowers the limit, too little raises it, but only
+	// while the limit holds the export back. Without enough surplus a
+	// higher limit changes nothing but lets the next sunny spell through.
+	if difference < 0 && export < float32(current.limit)/100*config.RatedPower-config.Deadband {
+		return Adjustment{}, false, nil
+	}
+	step := -difference / config.RatedPower * 100
+	if step > config.MaxStep {
+		step = config.MaxStep
+	}
+	if step < -config.MaxStep {
+		step = -config.MaxStep
+	}
+	limit := float64(current.limit) + math.Round(float64(step))
+	limit = math.Min(100, math.Max(0, limit))
+	if uint16(limit) == current.limit {
+		return Adjustment{}, false, nil
+	}
+
+	adjustment := Adjustment{
+		SerialNumber:   log.SerialNumber,
+		InverterSerial: log.InverterSerial,
+		Time:           now,
+		Export:         export,
+		Setpoint:       setpoint,
+		From:           current.limit,
+		To:             uint16(limit),
+	}
+	if err := current.write(ctx, serial, adjustment.To); err != nil {
+		return Adjustment{}, false, err
+	}
+	current.written = now
+	return adjustment, true, nil
+}
+
+// Check writes the fail-safe limit to the inverters no data came from for
+// StaleAfter, all at the same time. It returns the adjustments made and the
+// last error.
+func (controller *Controller) Check(ctx context.Context, now time.Time) ([]Adjustment, error) {
+	controller.lock.Lock()
+	candidates := []*controlled{}
+	for _, current := range controller.inverters {
+		if now.Sub(current.last) >= controller.config.StaleAfter {
+			candidates = append(candidates, current)
+		}
+	}
+	controller.lock.Unlock()
+
+	var group sync.WaitGroup
+	var results sync.Mutex
+	adjustments := []Adjustment{}
+	var failed error
+	for _, current := range candidates {
+		group.Add(1)
+		go func(current *controlled) {
+			defer group.Done()
+			adjustment, changed, err := controller.failSafe(ctx, current, now)
+			results.Lock()
+			defer results.Unlock()
+			if err != nil {
+				failed = err
+			}
+			if changed {
+				adjustments = append(adjustments, adjustment)
+			}
+		}(current)
+	}
+	group.Wait()
+	return adjustments, failed
+}
+
+func (controller *Controller) failSafe(ctx context.Context, current *controlled, now time.Time) (Adjustment, bool, error) {
+	current.lock.Lock()
+	defer current.lock.Unlock()
+	if current.stale || current.inverter == nil {
+		return Adjustment{}, false, nil
+	}
+
+	adjustment := Adjustment{
+		SerialNumber:   current.datalog,
+		InverterSerial: string(current.serial[:]),
+		Time:           now,
+		From:           current.limit,
+		To:             controller.config.FailSafe,
+		Stale:          true,
+	}
+	if err := current.write(ctx, current.serial, adjustment.To); err != nil {
+		return Adjustment{}, false, err
+	}
+	current.stale = true
+	// Check the enable bit again once data comes back
+	current.known = false
+	return adjustment, true, nil
+}
+
+// enable sets the enable bit of the export limit, without which the limit
+// does nothing, and reads the limit.
+func (current *controlled) enable(ctx context.Context) error {
+	// The schedule sets other bits of the same register
+	_, err := current.inverter.Modify(ctx, current.serial, luxproto.HOLD_FUNCTIONS, luxproto.ENABLE_EXPORT_LIMIT, luxproto.ENABLE_EXPORT_LIMIT)
+	if err != nil {
+		return err
+	}
+
+	values, err := current.inverter.ReadHold(ctx, current.serial, luxproto.HOLD_EXPORT_LIMIT, 1)
+	if err != nil {
+		return err
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("read %d registers for the export limit", len(values))
+	}
+	current.limit = values[0]
+	current.known = true
+	return nil
+}
+
+func (current *controlled) write(ctx context.Context, serial [10]byte, limit uint16) error {
+	if err := current.inverter.WriteSingle(ctx, serial, luxproto.HOLD_EXPORT_LIMIT, limit); err != nil {
+		return err
+	}
+	current.limit = limit
+	current.known = true
+	return nil
+}
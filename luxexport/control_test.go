@@ -0,0 +1,227 @@
+package luxexport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"LuxLogger/luxclient"
+	"LuxLogger/luxproto"
+	"LuxLogger/luxsim"
+)
+
+func TestSetpoint(t *testing.T) {
+	controller, err := NewController(Config{
+		Setpoint:   1000,
+		RatedPower: 5000,
+		Bands: []Band{
+			{Start: "10:00", End: "15:00", Export: 0},
+			{Start: "22:00", End: "06:00", Export: 3000},
+		},
+		Location: time.UTC,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hour     int
+		setpoint float32
+	}{
+		{8, 1000},
+		{10, 0},
+		{14, 0},
+		{15, 1000},
+		{23, 3000},
+		{2, 3000},
+	}
+	for _, test := range tests {
+		at := time.Date(2024, 6, 3, test.hour, 0, 0, 0, time.UTC)
+		if setpoint := controller.Setpoint(at); setpoint != test.setpoint {
+			t.Errorf("%d:00: setpoint %v, want %v", test.hour, setpoint, test.setpoint)
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	bad := []Config{
+		{},
+		{RatedPower: 5000, FailSafe: 120},
+		{RatedPower: 5000, Bands: []Band{{Start: "10h", End: "12:00"}}},
+	}
+	for _, config := range bad {
+		if _, err := NewController(config); err == nil {
+			t.Errorf("config %+v accepted", config)
+		}
+	}
+}
+
+func TestControlSimulator(t *testing.T) {
+	sim := luxsim.NewSimulator("BA00000001", "0000000001")
+	sim.Push = false
+	sim.Seed(1)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go sim.Serve(listener)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := luxclient.Dial(ctx, listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDatalog(sim.DatalogSerial)
+	go client.Run(ctx)
+
+	controller, err := NewController(Config{Setpoint: 300, RatedPower: luxsim.RATED_POWER, FailSafe: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if functions := sim.Holding(luxproto.HOLD_FUNCTIONS); functions&luxproto.ENABLE_EXPORT_LIMIT != 0 {
+		t.Fatalf("export limit enabled from the start: %#x", functions)
+	}
+
+	// Midday sun with the battery taking at most 3 kW leaves plenty to export
+	noon := time.Date(2024, 6, 3, 12, 0, 0, 0, time.Local)
+	var at time.Time
+	var export float32
+	adjustments := 0
+	for minute := 0; minute < 60; minute++ {
+		at = noon.Add(time.Duration(minute) * time.Minute)
+		sim.Update(at)
+		values, err := client.ReadInput(ctx, sim.InverterSerial, luxproto.INPUT_SECTION1, luxproto.SECTION_REGISTERS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log := luxproto.LogData{InverterSerial: string(sim.InverterSerial[:]), Time: at}
+		if !log.DecodeRegisters(luxproto.INPUT_SECTION1, values) {
+			t.Fatal("registers not decoded")
+		}
+		export = log.Section1.Power_To_Grid
+		if minute == 0 && export < 1000 {
+			t.Fatalf("only %v W to export", export)
+		}
+
+		adjustment, changed, err := controller.Update(ctx, client, sim.InverterSerial, log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if functions := sim.Holding(luxproto.HOLD_FUNCTIONS); functions&luxproto.ENABLE_EXPORT_LIMIT == 0 {
+			t.Fatalf("export limit not enabled: %#x", functions)
+		}
+		if changed {
+			adjustments++
+			if step := int(adjustment.To) - int(adjustment.From); step > DEFAULT_MAX_STEP || step < -DEFAULT_MAX_STEP {
+				t.Errorf("step from %d to %d", adjustment.From, adjustment.To)
+			}
+		}
+	}
+	if adjustments == 0 || export > 300+DEFAULT_DEADBAND {
+		t.Errorf("export %v W after %d adjustments", export, adjustments)
+	}
+	if limit := sim.Holding(luxproto.HOLD_EXPORT_LIMIT); limit >= 100 {
+		t.Errorf("limit %d%%", limit)
+	}
+
+	// Nothing stale yet, then no data for longer than StaleAfter
+	if adjustments, err := controller.Check(ctx, at.Add(time.Minute)); err != nil || len(adjustments) != 0 {
+		t.Errorf("fresh data adjusted %v: %v", adjustments, err)
+	}
+	stale, err := controller.Check(ctx, at.Add(DEFAULT_STALE_AFTER))
+	if err != nil || len(stale) != 1 || !stale[0].Stale || stale[0].To != 5 {
+		t.Errorf("stale adjustments %v: %v", stale, err)
+	}
+	if limit := sim.Holding(luxproto.HOLD_EXPORT_LIMIT); limit != 5 {
+		t.Errorf("fail-safe limit %d%%", limit)
+	}
+	if again, _ := controller.Check(ctx, at.Add(2*DEFAULT_STALE_AFTER)); len(again) != 0 {
+		t.Errorf("fail-safe written again %v", again)
+	}
+
+	// The enable bit is checked again when data comes back
+	functions := sim.Holding(luxproto.HOLD_FUNCTIONS)
+	err = client.WriteSingle(ctx, sim.InverterSerial, luxproto.HOLD_FUNCTIONS, functions&^luxproto.ENABLE_EXPORT_LIMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := luxproto.LogData{InverterSerial: string(sim.InverterSerial[:]), Time: at.Add(2 * DEFAULT_STALE_AFTER)}
+	log.Section1 = luxproto.LogDataSection1{Loaded: true, Power_To_Grid: export}
+	if _, _, err := controller.Update(ctx, client, sim.InverterSerial, log); err != nil {
+		t.Fatal(err)
+	}
+	if functions := sim.Holding(luxproto.HOLD_FUNCTIONS); functions&luxproto.ENABLE_EXPORT_LIMIT == 0 {
+		t.Errorf("export limit not enabled again: %#x", functions)
+	}
+}
+
+// fakeInverter answers 100 for every register, so an export limit of 100 %
+// that is not enabled, after release is closed when it is set.
+type fakeInverter struct {
+	release chan struct{}
+}
+
+func (inverter *fakeInverter) ReadHold(ctx context.Context, serial [10]byte, register uint16, count uint16) ([]uint16, error) {
+	if inverter.release != nil {
+		select {
+		case <-inverter.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	values := make([]uint16, count)
+	for i := range values {
+		values[i] = 100
+	}
+	return values, nil
+}
+
+func (inverter *fakeInverter) WriteSingle(ctx context.Context, serial [10]byte, register uint16, value uint16) error {
+	return nil
+}
+
+func (inverter *fakeInverter) Modify(ctx context.Context, serial [10]byte, register uint16, mask uint16, value uint16) (uint16, error) {
+	values, err := inverter.ReadHold(ctx, serial, register, 1)
+	if err != nil {
+		return 0, err
+	}
+	return values[0], nil
+}
+
+func TestFeedSlowInverter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controller, _ := NewController(Config{RatedPower: 5000})
+	adjusted := make(chan Adjustment, 10)
+	controller.Adjusted = func(adjustment Adjustment) {
+		adjusted <- adjustment
+	}
+
+	frame := func(serial string) luxproto.LogData {
+		log := luxproto.LogData{InverterSerial: serial, Time: time.Now()}
+		log.Section1 = luxproto.LogDataSection1{Loaded: true, Power_To_Grid: 2000}
+		return log
+	}
+	slow := &fakeInverter{release: make(chan struct{})}
+	defer close(slow.release)
+
+	// Feed returns at once and the slow inverter does not hold up the other
+	controller.Feed(ctx, slow, [10]byte{'1'}, frame("1"))
+	controller.Feed(ctx, slow, [10]byte{'1'}, frame("1"))
+	controller.Feed(ctx, &fakeInverter{}, [10]byte{'2'}, frame("2"))
+	select {
+	case adjustment := <-adjusted:
+		if adjustment.InverterSerial != "2" || adjustment.To != 90 {
+			t.Errorf("adjustment %v", adjustment)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fast inverter not adjusted")
+	}
+}
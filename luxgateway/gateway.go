@@ -0,0 +1,231 @@
+// Package luxgateway answers Modbus requests for the inverters LuxLogger is
+// connected to. Each inverter has its own unit identifier. Reads come from
+// the registers seen in recent frames or are forwarded to the inverter.
+package luxgateway
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"LuxLogger/luxclient"
+	"LuxLogger/luxproto"
+	"LuxLogger/modbus"
+)
+
+const (
+	DEFAULT_MAX_AGE = 2 * time.Minute
+	DEFAULT_TIMEOUT = 15 * time.Second
+)
+
+// Forwarder sends a request to an inverter, like luxclient.Client does.
+type Forwarder interface {
+	Request(ctx context.Context, msg luxproto.Message) (luxproto.Message, error)
+}
+
+type Gateway struct {
+	// Units maps Modbus unit identifiers to inverter serial numbers
+	Units map[uint8][10]byte
+	// Writes lets write requests through to the inverters
+	Writes bool
+	// MaxAge is how old cached registers may be before a read is forwarded
+	MaxAge time.Duration
+	// Timeout limits a forwarded request including its retries
+	Timeout time.Duration
+
+	lock      sync.Mutex
+	inverters map[[10]byte]*inverter
+}
+
+type inverter struct {
+	forwarder Forwarder
+	input     map[uint16]cached
+	holding   map[uint16]cached
+}
+
+type cached struct {
+	value uint16
+	time  time.Time
+}
+
+func NewGateway() *Gateway {
+	return &Gateway{
+		Units:     make(map[uint8][10]byte),
+		MaxAge:    DEFAULT_MAX_AGE,
+		Timeout:   DEFAULT_TIMEOUT,
+		inverters: make(map[[10]byte]*inverter),
+	}
+}
+
+// Observe caches the registers of a data frame from the inverter. Requests
+// for that inverter are forwarded through forwarder, which may be nil.
+func (gateway *Gateway) Observe(frame []byte, forwarder Forwarder) {
+	header, data, err := luxproto.ParseFrame(frame)
+	if err != nil || header.Function != luxproto.FUNCTION_DATA {
+		return
+	}
+	msg, err := luxproto.ParseMessage(data)
+	if err != nil || msg.Address != luxproto.ADDRESS_RESPONSE || msg.SerialNumber == ([10]byte{}) {
+		return
+	}
+
+	switch msg.DeviceFunction {
+	case luxproto.DEVICE_READINPUT, luxproto.DEVICE_READHOLD:
+		gateway.ObserveRegisters(msg.SerialNumber, msg.DeviceFunction, msg.Register, msg.Registers(), forwarder)
+	case luxproto.DEVICE_WRITESINGLE, luxproto.DEVICE_WRITEMULTI:
+		gateway.ObserveRegisters(msg.SerialNumber, luxproto.DEVICE_READHOLD, msg.Register, msg.Registers(), forwarder)
+	}
+}
+
+// ObserveRegisters caches register values read from an inverter by other
+// means, such as RS485.
+func (gateway *Gateway) ObserveRegisters(serial [10]byte, function uint8, register uint16, values []uint16, forwarder Forwarder) {
+	gateway.lock.Lock()
+	defer gateway.lock.Unlock()
+
+	inverter := gateway.inverter(serial)
+	if forwarder != nil {
+		inverter.forwarder = forwarder
+	}
+	inverter.store(function, register, values, time.Now())
+}
+
+func (gateway *Gateway) inverter(serial [10]byte) *inverter {
+	found, ok := gateway.inverters[serial]
+	if !ok {
+		found = &inverter{
+			input:   make(map[uint16]cached),
+			holding: make(map[uint16]cached),
+		}
+		gateway.inverters[serial] = found
+	}
+	return found
+}
+
+func (inverter *inverter) bank(function uint8) map[uint16]cached {
+	if function == luxproto.DEVICE_READINPUT {
+		return inverter.input
+	}
+	return inverter.holding
+}
+
+func (inverter *inverter) store(function uint8, register uint16, values []uint16, now time.Time) {
+	bank := inverter.bank(function)
+	for i, value := range values {
+		bank[register+uint16(i)] = cached{value, now}
+	}
+}
+
+// ReadRegisters answers a Modbus read from the cache when every register is
+// fresh and forwards it to the inverter otherwise.
+func (gateway *Gateway) ReadRegisters(unit uint8, function uint8, register uint16, count uint16) ([]uint16, error) {
+	serial, ok := gateway.Units[unit]
+	if !ok {
+		return nil, modbus.GATEWAY_PATH_UNAVAILABLE
+	}
+
+	device := uint8(luxproto.DEVICE_READHOLD)
+	if function == modbus.FUNCTION_READ_INPUT {
+		device = luxproto.DEVICE_READINPUT
+	}
+
+	if values, ok := gateway.cached(serial, device, register, count); ok {
+		return values, nil
+	}
+
+	response, err := gateway.forward(serial, luxproto.Message{
+		DeviceFunction: device,
+		SerialNumber:   serial,
+		Register:       register,
+		Count:          count,
+	})
+	if err != nil {
+		return nil, err
+	}
+	values := response.Registers()
+	if len(values) != int(count) {
+		return nil, modbus.GATEWAY_TARGET_FAILED
+	}
+	return values, nil
+}
+
+func (gateway *Gateway) cached(serial [10]byte, function uint8, register uint16, count uint16) ([]uint16, bool) {
+	gateway.lock.Lock()
+	defer gateway.lock.Unlock()
+
+	inverter, ok := gateway.inverters[serial]
+	if !ok {
+		return nil, false
+	}
+
+	bank := inverter.bank(function)
+	values := make([]uint16, count)
+	for i := range values {
+		value, ok := bank[register+uint16(i)]
+		if !ok || time.Since(value.time) > gateway.MaxAge {
+			return nil, false
+		}
+		values[i] = value.value
+	}
+	return values, true
+}
+
+// WriteRegisters forwards a Modbus write to the inverter when Writes is set.
+func (gateway *Gateway) WriteRegisters(unit uint8, register uint16, values []uint16) error {
+	if !gateway.Writes {
+		return modbus.ILLEGAL_FUNCTION
+	}
+	serial, ok := gateway.Units[unit]
+	if !ok {
+		return modbus.GATEWAY_PATH_UNAVAILABLE
+	}
+
+	msg := luxproto.Message{
+		DeviceFunction: luxproto.DEVICE_WRITEMULTI,
+		SerialNumber:   serial,
+		Register:       register,
+		Count:          uint16(len(values)),
+		Values:         luxproto.EncodeRegisters(values),
+	}
+	if len(values) == 1 {
+		msg.DeviceFunction = luxproto.DEVICE_WRITESINGLE
+	}
+	_, err := gateway.forward(serial, msg)
+	return err
+}
+
+// forward sends msg to the inverter and caches the registers of the answer.
+func (gateway *Gateway) forward(serial [10]byte, msg luxproto.Message) (luxproto.Message, error) {
+	gateway.lock.Lock()
+	inverter, ok := gateway.inverters[serial]
+	var forwarder Forwarder
+	if ok {
+		forwarder = inverter.forwarder
+	}
+	gateway.lock.Unlock()
+	if forwarder == nil {
+		return luxproto.Message{}, modbus.GATEWAY_PATH_UNAVAILABLE
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gateway.Timeout)
+	defer cancel()
+	response, err := forwarder.Request(ctx, msg)
+	if err == luxclient.ErrException && len(response.Values) > 0 {
+		return luxproto.Message{}, modbus.Exception(response.Values[0])
+	}
+	if err != nil {
+		return luxproto.Message{}, modbus.GATEWAY_TARGET_FAILED
+	}
+
+	function := msg.DeviceFunction
+	values := response.Registers()
+	if function == luxproto.DEVICE_WRITESINGLE || function == luxproto.DEVICE_WRITEMULTI {
+		function = luxproto.DEVICE_READHOLD
+		values = msg.Registers()
+	}
+	gateway.lock.Lock()
+	inverter.store(function, msg.Register, values, time.Now())
+	gateway.lock.Unlock()
+
+	return response, nil
+}
@@ -0,0 +1,119 @@
+package luxgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"LuxLogger/luxclient"
+	"LuxLogger/luxproto"
+	"LuxLogger/luxsim"
+	"LuxLogger/modbus"
+)
+
+// modbusRequest sends pdu to unit over conn and returns the response pdu.
+func modbusRequest(t *testing.T, conn net.Conn, unit uint8, pdu []byte) []byte {
+	t.Helper()
+	request := []byte{0, 7, 0, 0, 0, uint8(len(pdu) + 1), unit}
+	if _, err := conn.Write(append(request, pdu...)); err != nil {
+		t.Fatal(err)
+	}
+
+	header := make([]byte, modbus.MBAP_LENGTH)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	if header[1] != 7 || header[6] != unit {
+		t.Fatalf("response header % X", header)
+	}
+	response := make([]byte, binary.BigEndian.Uint16(header[4:6])-1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestGateway(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sim := luxsim.NewSimulator("BA00000001", "0000000001")
+	sim.Interval = 20 * time.Millisecond
+	dongle, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dongle.Close()
+	go sim.Serve(dongle)
+
+	client, err := luxclient.Dial(ctx, dongle.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	gateway := NewGateway()
+	gateway.Units[1] = sim.InverterSerial
+	pushed := make(chan struct{}, 100)
+	client.Unsolicited = func(frame []byte) {
+		gateway.Observe(frame, client)
+		pushed <- struct{}{}
+	}
+	go client.Run(ctx)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go modbus.ServeTCP(ctx, listener, gateway)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Wait for the first push so the route to the inverter is known
+	for _, ok := gateway.cached(sim.InverterSerial, luxproto.DEVICE_READINPUT, 0, 120); !ok; _, ok = gateway.cached(sim.InverterSerial, luxproto.DEVICE_READINPUT, 0, 120) {
+		select {
+		case <-pushed:
+		case <-ctx.Done():
+			t.Fatal("no data pushed")
+		}
+	}
+
+	response := modbusRequest(t, conn, 1, []byte{modbus.FUNCTION_READ_INPUT, 0, 0, 0, 40})
+	if len(response) != 82 || response[1] != 80 {
+		t.Fatalf("input read returned % X", response)
+	}
+	values, _ := gateway.cached(sim.InverterSerial, luxproto.DEVICE_READINPUT, 0, 40)
+	if binary.BigEndian.Uint16(response[2+2*4:]) != values[4] {
+		t.Error("battery voltage does not match the cached register")
+	}
+
+	// Holding registers are not pushed, the read goes to the inverter
+	client.WriteSingle(ctx, sim.InverterSerial, 21, 0x0042)
+	response = modbusRequest(t, conn, 1, []byte{modbus.FUNCTION_READ_HOLDING, 0, 21, 0, 1})
+	if !bytes.Equal(response, []byte{3, 2, 0, 0x42}) {
+		t.Errorf("holding read returned % X", response)
+	}
+
+	write := []byte{modbus.FUNCTION_WRITE_SINGLE, 0, 22, 0x12, 0x34}
+	if response := modbusRequest(t, conn, 1, write); !bytes.Equal(response, []byte{0x86, 1}) {
+		t.Errorf("write without Writes returned % X", response)
+	}
+	gateway.Writes = true
+	if response := modbusRequest(t, conn, 1, write); !bytes.Equal(response, write) {
+		t.Errorf("write returned % X", response)
+	}
+	if sim.Holding(22) != 0x1234 {
+		t.Errorf("register 22 is %04X", sim.Holding(22))
+	}
+
+	if response := modbusRequest(t, conn, 9, []byte{modbus.FUNCTION_READ_INPUT, 0, 0, 0, 1}); !bytes.Equal(response, []byte{0x84, 0x0A}) {
+		t.Errorf("unknown unit returned % X", response)
+	}
+}
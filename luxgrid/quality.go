@@ -0,0 +1,243 @@
+// Package luxgrid watches the quality of the grid supply an inverter sees.
+// For every interval it keeps the minimum, maximum and average of the phase
+// voltages, frequency and power factor, counts excursions beyond the limits
+// and measures the imbalance between phases.
+package luxgrid
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+// Defaults after EN 50160: 10 minute intervals, 230 V ±10%, 50 Hz ±1% and
+// 2% voltage imbalance.
+const (
+	DEFAULT_INTERVAL            = 10 * time.Minute
+	DEFAULT_NOMINAL_VOLTAGE     = 230
+	DEFAULT_VOLTAGE_TOLERANCE   = 0.10
+	DEFAULT_NOMINAL_FREQUENCY   = 50
+	DEFAULT_FREQUENCY_TOLERANCE = 0.01
+	DEFAULT_IMBALANCE_LIMIT     = 2
+	// INTERRUPTION_RATIO of the nominal voltage is an interruption, which
+	// is left to the outage detection instead of counting as undervoltage
+	INTERRUPTION_RATIO = 0.05
+)
+
+type Limits struct {
+	Interval           time.Duration
+	NominalVoltage     float32
+	VoltageTolerance   float32 // Relative, 0.1 for ±10%
+	NominalFrequency   float32
+	FrequencyTolerance float32 // Relative
+	ImbalanceLimit     float32 // Percent
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		Interval:           DEFAULT_INTERVAL,
+		NominalVoltage:     DEFAULT_NOMINAL_VOLTAGE,
+		VoltageTolerance:   DEFAULT_VOLTAGE_TOLERANCE,
+		NominalFrequency:   DEFAULT_NOMINAL_FREQUENCY,
+		FrequencyTolerance: DEFAULT_FREQUENCY_TOLERANCE,
+		ImbalanceLimit:     DEFAULT_IMBALANCE_LIMIT,
+	}
+}
+
+// Stats summarises one quantity over an interval.
+type Stats struct {
+	Min     float32
+	Max     float32
+	Average float32
+
+	sum   float64
+	count int
+}
+
+func (stats *Stats) add(value float32) {
+	if stats.count == 0 || value < stats.Min {
+		stats.Min = value
+	}
+	if stats.count == 0 || value > stats.Max {
+		stats.Max = value
+	}
+	stats.sum += float64(value)
+	stats.count++
+	stats.Average = float32(stats.sum / float64(stats.count))
+}
+
+// Report is the grid quality of one inverter over one interval. Phases that
+// read 0 V are not connected and have empty Stats.
+type Report struct {
+	SerialNumber   string
+	InverterSerial string
+	Start          time.Time
+	End            time.Time
+	Samples        int
+	Phases         int
+
+	Voltage_R    Stats
+	Voltage_S    Stats
+	Voltage_T    Stats
+	Frequency    Stats
+	Power_Factor Stats
+
+	// Excursions count each time a value leaves the limits
+	Overvoltage          int
+	Undervoltage         int
+	Frequency_Excursions int
+	Interruptions        int
+
+	// Imbalance is the largest deviation of a phase voltage from the mean
+	// of the phases, in percent
+	Imbalance_Max        float32
+	Imbalance_Average    float32
+	Imbalance_Excursions int
+	imbalance            Stats
+}
+
+type inverter struct {
+	report Report
+	// Which limits are exceeded right now, so excursions count once
+	over, under, frequency, interrupted, imbalanced bool
+}
+
+type Monitor struct {
+	Limits Limits
+
+	lock      sync.Mutex
+	inverters map[string]*inverter
+}
+
+func NewMonitor(limits Limits) *Monitor {
+	return &Monitor{Limits: limits, inverters: make(map[string]*inverter)}
+}
+
+// Update adds the grid readings of log to the interval of its inverter. At
+// the first log of a new interval the report of the last one is returned.
+func (monitor *Monitor) Update(log luxproto.LogData) (Report, bool) {
+	if !log.Section1.Loaded {
+		return Report{}, false
+	}
+	now := log.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	limits := monitor.Limits
+	start := now.Truncate(limits.Interval)
+
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+
+	key := log.SerialNumber + "/" + log.InverterSerial
+	current, ok := monitor.inverters[key]
+	if !ok {
+		current = &inverter{}
+		monitor.inverters[key] = current
+	}
+
+	finished, ended := current.report, false
+	if current.report.Start != start {
+		ended = current.report.Samples > 0
+		current.report = Report{
+			SerialNumber:   log.SerialNumber,
+			InverterSerial: log.InverterSerial,
+			Start:          start,
+		}
+	}
+	current.add(log.Section1, now, limits)
+
+	return finished, ended
+}
+
+func (current *inverter) add(section luxproto.LogDataSection1, now time.Time, limits Limits) {
+	report := &current.report
+	report.End = now
+	report.Samples++
+
+	voltages := []float32{}
+	phases := []*Stats{&report.Voltage_R, &report.Voltage_S, &report.Voltage_T}
+	for i, voltage := range []float32{section.Voltage_AC_R, section.Voltage_AC_S, section.Voltage_AC_T} {
+		if voltage == 0 {
+			continue
+		}
+		phases[i].add(voltage)
+		voltages = append(voltages, voltage)
+	}
+	if len(voltages) > report.Phases {
+		report.Phases = len(voltages)
+	}
+
+	// No phase at all is an interruption too
+	lowest, highest := float32(0), float32(0)
+	for i, voltage := range voltages {
+		if i == 0 || voltage < lowest {
+			lowest = voltage
+		}
+		if i == 0 || voltage > highest {
+			highest = voltage
+		}
+	}
+	interrupted := lowest < limits.NominalVoltage*INTERRUPTION_RATIO
+	if interrupted && !current.interrupted {
+		report.Interruptions++
+	}
+	current.interrupted = interrupted
+	if interrupted {
+		return
+	}
+
+	over := highest > limits.NominalVoltage*(1+limits.VoltageTolerance)
+	if over && !current.over {
+		report.Overvoltage++
+	}
+	current.over = over
+	under := lowest < limits.NominalVoltage*(1-limits.VoltageTolerance)
+	if under && !current.under {
+		report.Undervoltage++
+	}
+	current.under = under
+
+	report.Frequency.add(section.Frequency_Grid)
+	deviation := section.Frequency_Grid - limits.NominalFrequency
+	frequency := math.Abs(float64(deviation)) > float64(limits.NominalFrequency*limits.FrequencyTolerance)
+	if frequency && !current.frequency {
+		report.Frequency_Excursions++
+	}
+	current.frequency = frequency
+
+	report.Power_Factor.add(section.Grid_Power_Factor)
+
+	if len(voltages) > 1 {
+		imbalance := Imbalance(voltages)
+		report.imbalance.add(imbalance)
+		report.Imbalance_Max = report.imbalance.Max
+		report.Imbalance_Average = report.imbalance.Average
+		imbalanced := imbalance > limits.ImbalanceLimit
+		if imbalanced && !current.imbalanced {
+			report.Imbalance_Excursions++
+		}
+		current.imbalanced = imbalanced
+	}
+}
+
+// Imbalance returns the largest deviation of voltages from their mean in
+// percent of the mean.
+func Imbalance(voltages []float32) float32 {
+	mean := float32(0)
+	for _, voltage := range voltages {
+		mean += voltage
+	}
+	mean /= float32(len(voltages))
+	if mean == 0 {
+		return 0
+	}
+
+	deviation := float32(0)
+	for _, voltage := range voltages {
+		deviation = float32(math.Max(float64(deviation), math.Abs(float64(voltage-mean))))
+	}
+	return 100 * deviation / mean
+}
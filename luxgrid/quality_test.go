@@ -0,0 +1,91 @@
+package luxgrid
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtest"
+)
+
+func TestSinglePhaseInterval(t *testing.T) {
+	monitor := NewMonitor(DefaultLimits())
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	readings := []struct {
+		voltage   float32
+		frequency float32
+	}{
+		{230, 50},
+		{255, 50},   // Overvoltage
+		{256, 50},   // Still the same excursion
+		{230, 49.4}, // Frequency excursion
+		{200, 50},   // Undervoltage
+		{0, 0},      // Interruption, not undervoltage
+		{254, 50.6}, // Second overvoltage and frequency excursion
+	}
+	for i, reading := range readings {
+		if _, ended := monitor.Update(luxtest.Frame(start.Add(time.Duration(i)*time.Minute), luxproto.LogDataSection1{Voltage_AC_R: reading.voltage, Frequency_Grid: reading.frequency, Grid_Power_Factor: 1})); ended {
+			t.Fatalf("interval ended at reading %d", i)
+		}
+	}
+
+	report, ended := monitor.Update(luxtest.Frame(start.Add(10*time.Minute), luxproto.LogDataSection1{Voltage_AC_R: 230, Frequency_Grid: 50, Grid_Power_Factor: 1}))
+	if !ended {
+		t.Fatal("interval did not end")
+	}
+	if report.Samples != len(readings) || report.Phases != 1 || !report.Start.Equal(start) {
+		t.Errorf("report of %d samples, %d phases from %v", report.Samples, report.Phases, report.Start)
+	}
+	if report.Overvoltage != 2 || report.Undervoltage != 1 || report.Frequency_Excursions != 2 || report.Interruptions != 1 {
+		t.Errorf("excursions %d over, %d under, %d frequency, %d interruptions",
+			report.Overvoltage, report.Undervoltage, report.Frequency_Excursions, report.Interruptions)
+	}
+	if report.Voltage_R.Min != 200 || report.Voltage_R.Max != 256 || report.Voltage_S.Max != 0 {
+		t.Errorf("voltages %+v %+v", report.Voltage_R, report.Voltage_S)
+	}
+	if math.Abs(float64(report.Voltage_R.Average)-(230+255+256+230+200+254)/6.0) > 1e-3 {
+		t.Errorf("average voltage %v", report.Voltage_R.Average)
+	}
+	if report.Frequency.Min != 49.4 || report.Frequency.Max != 50.6 || report.Imbalance_Max != 0 {
+		t.Errorf("frequency %+v, imbalance %v", report.Frequency, report.Imbalance_Max)
+	}
+}
+
+func TestThreePhaseImbalance(t *testing.T) {
+	monitor := NewMonitor(DefaultLimits())
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	monitor.Update(luxtest.Frame(start, luxproto.LogDataSection1{Voltage_AC_R: 230, Voltage_AC_S: 230, Voltage_AC_T: 230, Frequency_Grid: 50, Grid_Power_Factor: 1}))
+	monitor.Update(luxtest.Frame(start.Add(time.Minute), luxproto.LogDataSection1{Voltage_AC_R: 240, Voltage_AC_S: 230, Voltage_AC_T: 220, Frequency_Grid: 50, Grid_Power_Factor: 1}))
+	report, ended := monitor.Update(luxtest.Frame(start.Add(10*time.Minute), luxproto.LogDataSection1{Voltage_AC_R: 230, Voltage_AC_S: 230, Voltage_AC_T: 230, Frequency_Grid: 50, Grid_Power_Factor: 1}))
+	if !ended || report.Phases != 3 {
+		t.Fatalf("ended %v, %d phases", ended, report.Phases)
+	}
+	expected := float32(100 * 10.0 / 230)
+	if math.Abs(float64(report.Imbalance_Max-expected)) > 1e-4 || math.Abs(float64(report.Imbalance_Average-expected/2)) > 1e-4 {
+		t.Errorf("imbalance max %v, average %v", report.Imbalance_Max, report.Imbalance_Average)
+	}
+	if report.Imbalance_Excursions != 1 {
+		t.Errorf("%d imbalance excursions", report.Imbalance_Excursions)
+	}
+}
+
+func TestSectionsWithoutGrid(t *testing.T) {
+	monitor := NewMonitor(DefaultLimits())
+	log := luxproto.LogData{Time: time.Now()}
+	log.Section2.Loaded = true
+	if _, ended := monitor.Update(log); ended || len(monitor.inverters) != 0 {
+		t.Error("log without Section1 counted")
+	}
+}
+
+func TestImbalance(t *testing.T) {
+	if imbalance := Imbalance([]float32{230, 230, 230}); imbalance != 0 {
+		t.Errorf("balanced phases give %v", imbalance)
+	}
+	if imbalance := Imbalance([]float32{0, 0}); imbalance != 0 {
+		t.Errorf("dead phases give %v", imbalance)
+	}
+}
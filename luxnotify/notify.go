@@ -0,0 +1,353 @@
+// Package luxnotify sends alert events to people: JSON to generic webhooks,
+// or messages for ntfy, Gotify and Pushover. Each notifier renders its own
+// title and body templates, retries failed deliveries, limits how often it
+// sends and can keep quiet at night, sending a summary in the morning.
+package luxnotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"LuxLogger/luxalert"
+	"LuxLogger/luxtime"
+)
+
+// Kinds of notifier
+const (
+	KIND_WEBHOOK  = "webhook"
+	KIND_NTFY     = "ntfy"
+	KIND_GOTIFY   = "gotify"
+	KIND_PUSHOVER = "pushover"
+)
+
+const (
+	PUSHOVER_URL        = "https://api.pushover.net/1/messages.json"
+	DEFAULT_TITLE       = "{{.Rule}} {{.State}}"
+	DEFAULT_BODY        = "{{.Message}}"
+	DEFAULT_RETRIES     = 3
+	DEFAULT_RETRY_DELAY = 5 * time.Second
+	DEFAULT_TIMEOUT     = 10 * time.Second
+	// SUMMARY_MESSAGES is how many of the held events the quiet hours
+	// summary lists
+	SUMMARY_MESSAGES = 20
+)
+
+// Config describes one notifier. Only Kind and URL are needed.
+type Config struct {
+	Kind string
+	// URL is the webhook, the ntfy topic URL or the Gotify server
+	URL string
+	// Token is the ntfy access token, Gotify application token or
+	// Pushover application token
+	Token string
+	// User is the Pushover user key
+	User string
+
+	// Title and Body are text/template templates over a luxalert.Event.
+	// Webhooks without a Body get the event as JSON.
+	Title string
+	Body  string
+
+	Retries    int
+	RetryDelay time.Duration
+	// RateLimit is the least time between two notifications of the same
+	// rule and state for one inverter, events in between are dropped. Critical and resolved
+	// events are always sent.
+	RateLimit time.Duration
+
+	// QuietStart and QuietEnd like "22:00" and "07:00" hold back all but
+	// critical events in between, in Location or local time. Flush sends a
+	// summary of them once the quiet hours are over.
+	QuietStart string
+	QuietEnd   string
+	Location   *time.Location
+}
+
+type Notifier struct {
+	config     Config
+	title      *template.Template
+	body       *template.Template
+	quietStart int // Minutes after midnight, -1 without quiet hours
+	quietEnd   int
+
+	// Client sends the requests, tests replace it
+	Client *http.Client
+	// Sleep waits between retries, tests replace it
+	Sleep func(time.Duration)
+
+	lock sync.Mutex
+	last map[string]time.Time // Last notification per inverter, rule and state
+	held []luxalert.Event     // Held back during the quiet hours
+}
+
+func NewNotifier(config Config) (*Notifier, error) {
+	switch config.Kind {
+	case KIND_WEBHOOK, KIND_NTFY, KIND_GOTIFY:
+		if config.URL == "" {
+			return nil, fmt.Errorf("%s notifier without URL", config.Kind)
+		}
+	case KIND_PUSHOVER:
+		if config.URL == "" {
+			config.URL = PUSHOVER_URL
+		}
+		if config.Token == "" || config.User == "" {
+			return nil, fmt.Errorf("pushover notifier needs Token and User")
+		}
+	default:
+		return nil, fmt.Errorf("unknown notifier kind %q", config.Kind)
+	}
+
+	if config.Retries == 0 {
+		config.Retries = DEFAULT_RETRIES
+	}
+	if config.RetryDelay == 0 {
+		config.RetryDelay = DEFAULT_RETRY_DELAY
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	if config.Title == "" {
+		config.Title = DEFAULT_TITLE
+	}
+	if config.Body == "" && config.Kind != KIND_WEBHOOK {
+		config.Body = DEFAULT_BODY
+	}
+
+	notifier := &Notifier{
+		config:     config,
+		quietStart: -1,
+		quietEnd:   -1,
+		Client:     &http.Client{Timeout: DEFAULT_TIMEOUT},
+		Sleep:      time.Sleep,
+		last:       make(map[string]time.Time),
+	}
+
+	var err error
+	if notifier.title, err = template.New("title").Parse(config.Title); err != nil {
+		return nil, err
+	}
+	if config.Body != "" {
+		if notifier.body, err = template.New("body").Parse(config.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.QuietStart != "" || config.QuietEnd != "" {
+		if notifier.quietStart, err = luxtime.Minutes(config.QuietStart); err != nil {
+			return nil, err
+		}
+		if notifier.quietEnd, err = luxtime.Minutes(config.QuietEnd); err != nil {
+			return nil, err
+		}
+	}
+	return notifier, nil
+}
+
+// quiet tells if at falls into the quiet hours, which may span midnight.
+func (notifier *Notifier) quiet(at time.Time) bool {
+	if notifier.quietStart < 0 {
+		return false
+	}
+	local := at.In(notifier.config.Location)
+	now := local.Hour()*60 + local.Minute()
+	if notifier.quietStart <= notifier.quietEnd {
+		return now >= notifier.quietStart && now < notifier.quietEnd
+	}
+	return now >= notifier.quietStart || now < notifier.quietEnd
+}
+
+// Notify sends event unless quiet hours or the rate limit hold it back. It
+// returns once the event was delivered or the retries ran out.
+func (notifier *Notifier) Notify(event luxalert.Event) error {
+	at := event.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	notifier.lock.Lock()
+	if event.Severity != luxalert.SEVERITY_CRITICAL {
+		if notifier.quiet(at) {
+			notifier.held = append(notifier.held, event)
+			notifier.lock.Unlock()
+			return nil
+		}
+		key := event.SerialNumber + "/" + event.InverterSerial + "/" + event.Rule + "/" + event.State
+		last, sent := notifier.last[key]
+		if event.State != luxalert.STATE_RESOLVED && notifier.config.RateLimit > 0 && sent && at.Sub(last) < notifier.config.RateLimit {
+			notifier.lock.Unlock()
+			return nil
+		}
+		notifier.last[key] = at
+	}
+	notifier.lock.Unlock()
+
+	return notifier.deliver(event)
+}
+
+// Flush sends one summary of the events held back during the quiet hours
+// once they are over. It does nothing during the quiet hours or without held
+// events.
+func (notifier *Notifier) Flush(now time.Time) error {
+	notifier.lock.Lock()
+	if len(notifier.held) == 0 || notifier.quiet(now) {
+		notifier.lock.Unlock()
+		return nil
+	}
+	held := notifier.held
+	notifier.held = nil
+	notifier.lock.Unlock()
+
+	return notifier.deliver(summary(held, now))
+}
+
+// summary is an event listing the messages of held, with the highest
+// severity among them.
+func summary(held []luxalert.Event, now time.Time) luxalert.Event {
+	event := luxalert.Event{
+		Rule:     "Quiet hours",
+		Severity: luxalert.SEVERITY_INFO,
+		State:    "summary",
+		Value:    float64(len(held)),
+		Time:     now,
+	}
+	lines := []string{fmt.Sprintf("%d events during the quiet hours:", len(held))}
+	for i, held := range held {
+		if held.Severity == luxalert.SEVERITY_WARNING {
+			event.Severity = luxalert.SEVERITY_WARNING
+		}
+		if i < SUMMARY_MESSAGES {
+			lines = append(lines, held.Message)
+		}
+	}
+	if len(held) > SUMMARY_MESSAGES {
+		lines = append(lines, fmt.Sprintf("and %d more", len(held)-SUMMARY_MESSAGES))
+	}
+	event.Message = strings.Join(lines, "\n")
+	return event
+}
+
+// deliver sends event, retrying failures worth retrying.
+func (notifier *Notifier) deliver(event luxalert.Event) error {
+	request, err := notifier.request(event)
+	if err != nil {
+		return err
+	}
+
+	delay := notifier.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := notifier.send(request)
+		if err == nil || !retry || attempt >= notifier.config.Retries {
+			return err
+		}
+		notifier.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// request is an HTTP request without its body reader, so it can be sent
+// again.
+type request struct {
+	url     string
+	headers map[string]string
+	body    []byte
+}
+
+func (notifier *Notifier) request(event luxalert.Event) (request, error) {
+	title, err := render(notifier.title, event)
+	if err != nil {
+		return request{}, err
+	}
+	body := ""
+	if notifier.body != nil {
+		if body, err = render(notifier.body, event); err != nil {
+			return request{}, err
+		}
+	}
+
+	config := notifier.config
+	switch config.Kind {
+	case KIND_NTFY:
+		headers := map[string]string{
+			"Title":    title,
+			"Priority": fmt.Sprint(priority(event.Severity, 3, 4, 5)),
+			"Tags":     event.Severity,
+		}
+		if config.Token != "" {
+			headers["Authorization"] = "Bearer " + config.Token
+		}
+		return request{config.URL, headers, []byte(body)}, nil
+
+	case KIND_GOTIFY:
+		payload, _ := json.Marshal(map[string]interface{}{
+			"title":    title,
+			"message":  body,
+			"priority": priority(event.Severity, 2, 5, 8),
+		})
+		headers := map[string]string{"Content-Type": "application/json", "X-Gotify-Key": config.Token}
+		return request{strings.TrimSuffix(config.URL, "/") + "/message", headers, payload}, nil
+
+	case KIND_PUSHOVER:
+		form := url.Values{
+			"token":    {config.Token},
+			"user":     {config.User},
+			"title":    {title},
+			"message":  {body},
+			"priority": {fmt.Sprint(priority(event.Severity, -1, 0, 1))},
+		}
+		headers := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+		return request{config.URL, headers, []byte(form.Encode())}, nil
+	}
+
+	payload := []byte(body)
+	if notifier.body == nil {
+		payload, _ = json.Marshal(event)
+	}
+	headers := map[string]string{"Content-Type": "application/json", "X-Title": title}
+	return request{config.URL, headers, payload}, nil
+}
+
+func render(text *template.Template, event luxalert.Event) (string, error) {
+	buffer := strings.Builder{}
+	err := text.Execute(&buffer, event)
+	return buffer.String(), err
+}
+
+// priority picks the priority for severity on the scale of a service.
+func priority(severity string, info int, warning int, critical int) int {
+	switch severity {
+	case luxalert.SEVERITY_CRITICAL:
+		return critical
+	case luxalert.SEVERITY_INFO:
+		return info
+	}
+	return warning
+}
+
+// send posts request once and tells if a failure is worth retrying.
+func (notifier *Notifier) send(request request) (bool, error) {
+	post, err := http.NewRequest(http.MethodPost, request.url, bytes.NewReader(request.body))
+	if err != nil {
+		return false, err
+	}
+	for key, value := range request.headers {
+		post.Header.Set(key, value)
+	}
+
+	response, err := notifier.Client.Do(post)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("%s answered %s", notifier.config.Kind, response.Status)
+	}
+	return false, nil
+}
@@ -0,0 +1,307 @@
+package luxnotify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"LuxLogger/luxalert"
+)
+
+type received struct {
+	path    string
+	headers http.Header
+	body    []byte
+}
+
+// standIn is a local HTTP server that records requests and answers with the
+// queued status codes, then 200.
+type standIn struct {
+	*httptest.Server
+	lock     sync.Mutex
+	requests []received
+	statuses []int
+}
+
+func newStandIn(t *testing.T, statuses ...int) *standIn {
+	stand := &standIn{statuses: statuses}
+	stand.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		stand.lock.Lock()
+		defer stand.lock.Unlock()
+		stand.requests = append(stand.requests, received{request.URL.Path, request.Header, body})
+		if len(stand.statuses) > 0 {
+			writer.WriteHeader(stand.statuses[0])
+			stand.statuses = stand.statuses[1:]
+		}
+	}))
+	t.Cleanup(stand.Close)
+	return stand
+}
+
+func (stand *standIn) received() []received {
+	stand.lock.Lock()
+	defer stand.lock.Unlock()
+	return append([]received{}, stand.requests...)
+}
+
+func testEvent(severity string) luxalert.Event {
+	return luxalert.Event{
+		Rule:           "Low battery",
+		Severity:       severity,
+		State:          luxalert.STATE_FIRING,
+		InverterSerial: "0000000001",
+		Field:          "SOC",
+		Value:          12,
+		Threshold:      15,
+		Time:           time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		Message:        "Low battery: SOC of 0000000001 is 12 (SOC < 15)",
+	}
+}
+
+func newTestNotifier(t *testing.T, config Config) *Notifier {
+	t.Helper()
+	notifier, err := NewNotifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.Sleep = func(time.Duration) {}
+	return notifier
+}
+
+func TestWebhook(t *testing.T) {
+	stand := newStandIn(t)
+	notifier := newTestNotifier(t, Config{Kind: KIND_WEBHOOK, URL: stand.URL + "/hook"})
+	if err := notifier.Notify(testEvent(luxalert.SEVERITY_WARNING)); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := stand.received()
+	if len(requests) != 1 || requests[0].path != "/hook" || requests[0].headers.Get("Content-Type") != "application/json" {
+		t.Fatalf("requests %+v", requests)
+	}
+	event := luxalert.Event{}
+	if err := json.Unmarshal(requests[0].body, &event); err != nil || event.Rule != "Low battery" || event.Value != 12 {
+		t.Errorf("posted %s", requests[0].body)
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	stand := newStandIn(t)
+	notifier := newTestNotifier(t, Config{
+		Kind:  KIND_NTFY,
+		URL:   stand.URL + "/solar",
+		Token: "tk_secret",
+		Title: "{{.InverterSerial}}: {{.Rule}}",
+		Body:  "SOC at {{.Value}}%",
+	})
+	if err := notifier.Notify(testEvent(luxalert.SEVERITY_CRITICAL)); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := stand.received()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests", len(requests))
+	}
+	headers := requests[0].headers
+	if requests[0].path != "/solar" || headers.Get("Title") != "0000000001: Low battery" || headers.Get("Priority") != "5" ||
+		headers.Get("Authorization") != "Bearer tk_secret" || string(requests[0].body) != "SOC at 12%" {
+		t.Errorf("request %+v %s", headers, requests[0].body)
+	}
+}
+
+func TestGotify(t *testing.T) {
+	stand := newStandIn(t)
+	notifier := newTestNotifier(t, Config{Kind: KIND_GOTIFY, URL: stand.URL + "/", Token: "app-token"})
+	if err := notifier.Notify(testEvent(luxalert.SEVERITY_WARNING)); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := stand.received()
+	if len(requests) != 1 || requests[0].path != "/message" || requests[0].headers.Get("X-Gotify-Key") != "app-token" {
+		t.Fatalf("requests %+v", requests)
+	}
+	message := struct {
+		Title    string
+		Message  string
+		Priority int
+	}{}
+	json.Unmarshal(requests[0].body, &message)
+	if message.Title != "Low battery firing" || message.Message != testEvent("").Message || message.Priority != 5 {
+		t.Errorf("message %+v", message)
+	}
+}
+
+func TestPushover(t *testing.T) {
+	stand := newStandIn(t)
+	notifier := newTestNotifier(t, Config{Kind: KIND_PUSHOVER, URL: stand.URL, Token: "app", User: "user"})
+	if err := notifier.Notify(testEvent(luxalert.SEVERITY_INFO)); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := stand.received()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests", len(requests))
+	}
+	form, err := url.ParseQuery(string(requests[0].body))
+	if err != nil || form.Get("token") != "app" || form.Get("user") != "user" || form.Get("priority") != "-1" || form.Get("title") != "Low battery firing" {
+		t.Errorf("form %v", form)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	stand := newStandIn(t, http.StatusBadGateway, http.StatusTooManyRequests)
+	notifier := newTestNotifier(t, Config{Kind: KIND_WEBHOOK, URL: stand.URL})
+	delays := []time.Duration{}
+	notifier.Sleep = func(delay time.Duration) {
+		delays = append(delays, delay)
+	}
+
+	if err := notifier.Notify(testEvent(luxalert.SEVERITY_WARNING)); err != nil {
+		t.Fatal(err)
+	}
+	if len(stand.received()) != 3 || len(delays) != 2 || delays[1] != 2*delays[0] {
+		t.Errorf("%d requests, delays %v", len(stand.received()), delays)
+	}
+
+	// Client errors are not retried
+	stand.statuses = []int{http.StatusUnauthorized}
+	if err := notifier.Notify(testEvent(luxalert.SEVERITY_WARNING)); err == nil || len(stand.received()) != 4 {
+		t.Errorf("%d requests, error %v", len(stand.received()), err)
+	}
+}
+
+func TestRetriesRunOut(t *testing.T) {
+	stand := newStandIn(t, 500, 500, 500)
+	notifier := newTestNotifier(t, Config{Kind: KIND_WEBHOOK, URL: stand.URL, Retries: 2})
+	if err := notifier.Notify(testEvent(luxalert.SEVERITY_WARNING)); err == nil || len(stand.received()) != 3 {
+		t.Errorf("%d requests, error %v", len(stand.received()), err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	stand := newStandIn(t)
+	notifier := newTestNotifier(t, Config{Kind: KIND_WEBHOOK, URL: stand.URL, RateLimit: 10 * time.Minute})
+
+	tests := []struct {
+		minute   int
+		inverter string
+		rule     string
+		severity string
+		state    string
+		sent     bool
+	}{
+		{0, "0000000001", "Low battery", luxalert.SEVERITY_WARNING, luxalert.STATE_FIRING, true},
+		{1, "0000000001", "Low battery", luxalert.SEVERITY_WARNING, luxalert.STATE_FIRING, false},
+		// Another inverter firing the same rule has its own limit
+		{1, "0000000002", "Low battery", luxalert.SEVERITY_WARNING, luxalert.STATE_FIRING, true},
+		{2, "0000000002", "Low battery", luxalert.SEVERITY_WARNING, luxalert.STATE_FIRING, false},
+		// Other rules and states have their own limit
+		{2, "0000000001", "Grid down", luxalert.SEVERITY_WARNING, luxalert.STATE_FIRING, true},
+		{3, "0000000001", "Low battery", luxalert.SEVERITY_WARNING, luxalert.STATE_RESOLVED, true},
+		// Resolved and critical events are never dropped
+		{4, "0000000001", "Low battery", luxalert.SEVERITY_WARNING, luxalert.STATE_RESOLVED, true},
+		{5, "0000000001", "Low battery", luxalert.SEVERITY_CRITICAL, luxalert.STATE_FIRING, true},
+		{9, "0000000001", "Low battery", luxalert.SEVERITY_WARNING, luxalert.STATE_FIRING, false},
+		{10, "0000000001", "Low battery", luxalert.SEVERITY_WARNING, luxalert.STATE_FIRING, true},
+	}
+	for _, test := range tests {
+		before := len(stand.received())
+		event := testEvent(test.severity)
+		event.InverterSerial = test.inverter
+		event.Rule = test.rule
+		event.State = test.state
+		event.Time = testEvent("").Time.Add(time.Duration(test.minute) * time.Minute)
+		notifier.Notify(event)
+		if sent := len(stand.received()) > before; sent != test.sent {
+			t.Errorf("%s %s %s of %s at minute %d sent %v", test.severity, test.rule, test.state, test.inverter, test.minute, sent)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	stand := newStandIn(t)
+	notifier := newTestNotifier(t, Config{
+		Kind:       KIND_WEBHOOK,
+		URL:        stand.URL,
+		QuietStart: "22:00",
+		QuietEnd:   "07:00",
+		Location:   time.UTC,
+	})
+
+	tests := []struct {
+		hour     int
+		severity string
+		sent     bool
+	}{
+		{12, luxalert.SEVERITY_WARNING, true},
+		{23, luxalert.SEVERITY_WARNING, false},
+		{3, luxalert.SEVERITY_INFO, false},
+		{3, luxalert.SEVERITY_CRITICAL, true},
+		{7, luxalert.SEVERITY_WARNING, true},
+	}
+	for _, test := range tests {
+		before := len(stand.received())
+		event := testEvent(test.severity)
+		event.Time = time.Date(2024, 6, 1, test.hour, 0, 0, 0, time.UTC)
+		notifier.Notify(event)
+		if sent := len(stand.received()) > before; sent != test.sent {
+			t.Errorf("%s at %d:00 sent %v", test.severity, test.hour, sent)
+		}
+	}
+
+	// The events held back at 23:00 and 3:00 are summed up after the quiet
+	// hours, once
+	before := len(stand.received())
+	notifier.Flush(time.Date(2024, 6, 2, 6, 59, 0, 0, time.UTC))
+	if len(stand.received()) != before {
+		t.Error("summary sent during the quiet hours")
+	}
+	for i := 0; i < 2; i++ {
+		notifier.Flush(time.Date(2024, 6, 2, 7, i, 0, 0, time.UTC))
+	}
+	received := stand.received()
+	if len(received) != before+1 {
+		t.Fatalf("%d summaries sent", len(received)-before)
+	}
+	summary := luxalert.Event{}
+	if err := json.Unmarshal(received[before].body, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Severity != luxalert.SEVERITY_WARNING || summary.Value != 2 || !strings.Contains(summary.Message, testEvent("").Message) {
+		t.Errorf("summary %+v", summary)
+	}
+}
+
+func TestSummaryLength(t *testing.T) {
+	held := make([]luxalert.Event, SUMMARY_MESSAGES+5)
+	for i := range held {
+		held[i] = testEvent(luxalert.SEVERITY_INFO)
+	}
+	event := summary(held, testEvent("").Time)
+	lines := strings.Split(event.Message, "\n")
+	if event.Severity != luxalert.SEVERITY_INFO || len(lines) != SUMMARY_MESSAGES+2 || lines[len(lines)-1] != "and 5 more" {
+		t.Errorf("summary %+v", event)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	configs := []Config{
+		{Kind: "email", URL: "http://localhost"},
+		{Kind: KIND_NTFY},
+		{Kind: KIND_PUSHOVER, Token: "app"},
+		{Kind: KIND_WEBHOOK, URL: "http://localhost", Title: "{{.Rule"},
+		{Kind: KIND_WEBHOOK, URL: "http://localhost", QuietStart: "22h"},
+	}
+	for _, config := range configs {
+		if _, err := NewNotifier(config); err == nil {
+			t.Errorf("config %+v accepted", config)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package luxoutage follows the grid connection of each inverter through
+// Section1. It reports when the grid is lost, when the EPS output takes
+// over the load and when the grid comes back, the latter with the length of
+// the outage and the energy the battery supplied during it.
+package luxoutage
+
+import (
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+// Types of an Event
+const (
+	EVENT_GRID_LOST     = "grid-lost"
+	EVENT_EPS_ACTIVE    = "eps-active"
+	EVENT_GRID_RESTORED = "grid-restored"
+)
+
+const (
+	// DEFAULT_LOSS_VOLTAGE is the grid voltage below which the grid counts
+	// as lost
+	DEFAULT_LOSS_VOLTAGE = 100
+	// MAX_SAMPLE_GAP limits how long one sample counts when integrating
+	// power, so a gap in the data does not inflate the energy
+	MAX_SAMPLE_GAP = 5 * time.Minute
+)
+
+// Event is a change of the grid state of one inverter. Durations are in
+// seconds and energies in kWh.
+type Event struct {
+	Type           string
+	SerialNumber   string
+	InverterSerial string
+	Time           time.Time
+	// Start of the outage, Duration and the energies are only set once the
+	// grid is restored
+	Start          time.Time
+	Duration       float64
+	Battery_Energy float64
+	EPS_Energy     float64
+	Start_SOC      float32
+	End_SOC        float32
+	Min_SOC        float32
+}
+
+type state int
+
+const (
+	stateGrid state = iota
+	stateLost
+	stateEPS
+)
+
+type inverter struct {
+	state   state
+	outage  Event
+	last    time.Time
+	battery float32 // Discharge power of the last sample in W
+	eps     float32 // EPS power of the last sample in W
+}
+
+type Tracker struct {
+	// LossVoltage is the grid voltage below which the grid counts as lost
+	LossVoltage float32
+
+	lock      sync.Mutex
+	inverters map[string]*inverter
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		LossVoltage: DEFAULT_LOSS_VOLTAGE,
+		inverters:   make(map[string]*inverter),
+	}
+}
+
+// Update follows the grid state with log and returns the transitions it
+// shows. Logs without Section1 are ignored.
+func (tracker *Tracker) Update(log luxproto.LogData) []Event {
+	if !log.Section1.Loaded {
+		return nil
+	}
+	now := log.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	key := log.SerialNumber + "/" + log.InverterSerial
+	current, ok := tracker.inverters[key]
+	if !ok {
+		current = &inverter{}
+		tracker.inverters[key] = current
+	}
+	if current.state != stateGrid {
+		current.integrate(now)
+	}
+	current.last = now
+	current.battery = log.Section1.Discharge_Power
+	current.eps = log.Section1.Active_EPS_Power
+
+	section := log.Section1
+	offGrid := luxproto.InverterStatus(section.Status).OffGrid()
+	lost := offGrid || section.Voltage_AC_R < tracker.LossVoltage
+	epsActive := offGrid || section.Active_EPS_Power > 0
+
+	events := []Event{}
+	event := func(kind string) Event {
+		return Event{
+			Type:           kind,
+			SerialNumber:   log.SerialNumber,
+			InverterSerial: log.InverterSerial,
+			Time:           now,
+			Start:          current.outage.Start,
+		}
+	}
+
+	if current.state == stateGrid && lost {
+		current.state = stateLost
+		current.outage = Event{Start: now, Start_SOC: section.SOC, Min_SOC: section.SOC}
+		events = append(events, event(EVENT_GRID_LOST))
+	}
+	if current.state != stateGrid && section.SOC < current.outage.Min_SOC {
+		current.outage.Min_SOC = section.SOC
+	}
+	if current.state == stateLost && lost && epsActive {
+		current.state = stateEPS
+		events = append(events, event(EVENT_EPS_ACTIVE))
+	}
+	if current.state != stateGrid && !lost {
+		restored := current.outage
+		restored.Type = EVENT_GRID_RESTORED
+		restored.SerialNumber = log.SerialNumber
+		restored.InverterSerial = log.InverterSerial
+		restored.Time = now
+		restored.Duration = now.Sub(restored.Start).Seconds()
+		restored.End_SOC = section.SOC
+		current.state = stateGrid
+		events = append(events, restored)
+	}
+	return events
+}
+
+// integrate adds the energy since the last sample to the open outage,
+// holding the powers of that sample.
+func (current *inverter) integrate(now time.Time) {
+	gap := now.Sub(current.last)
+	if gap > MAX_SAMPLE_GAP {
+		gap = MAX_SAMPLE_GAP
+	}
+	if gap <= 0 {
+		return
+	}
+	hours := gap.Hours()
+	current.outage.Battery_Energy += float64(current.battery) * hours / 1000
+	current.outage.EPS_Energy += float64(current.eps) * hours / 1000
+}
@@ -0,0 +1,84 @@
+package luxoutage
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtest"
+)
+
+func TestOutage(t *testing.T) {
+	tracker := NewTracker()
+	start := time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		log    luxproto.LogData
+		events []string
+	}{
+		{luxtest.Frame(start, luxproto.LogDataSection1{Status: luxproto.STATUS_BATTERY_ON_GRID, Voltage_AC_R: 231, Discharge_Power: 500, SOC: 80}), nil},
+		// The voltage drops before the inverter switches over
+		{luxtest.Frame(start.Add(time.Minute), luxproto.LogDataSection1{Status: luxproto.STATUS_BATTERY_ON_GRID, SOC: 80}), []string{EVENT_GRID_LOST}},
+		{luxtest.Frame(start.Add(2*time.Minute), luxproto.LogDataSection1{Status: luxproto.STATUS_BATTERY_OFF_GRID, Active_EPS_Power: 1200, Discharge_Power: 1200, SOC: 79}), []string{EVENT_EPS_ACTIVE}},
+		{luxtest.Frame(start.Add(4*time.Minute), luxproto.LogDataSection1{Status: luxproto.STATUS_BATTERY_OFF_GRID, Active_EPS_Power: 1200, Discharge_Power: 1200, SOC: 70}), nil},
+		{luxtest.Frame(start.Add(6*time.Minute), luxproto.LogDataSection1{Status: luxproto.STATUS_BATTERY_ON_GRID, Voltage_AC_R: 229, Discharge_Power: 300, SOC: 71}), []string{EVENT_GRID_RESTORED}},
+		{luxtest.Frame(start.Add(7*time.Minute), luxproto.LogDataSection1{Status: luxproto.STATUS_BATTERY_ON_GRID, Voltage_AC_R: 230, Discharge_Power: 300, SOC: 71}), nil},
+	}
+
+	for i, step := range steps {
+		events := tracker.Update(step.log)
+		if len(events) != len(step.events) {
+			t.Fatalf("step %d gave %+v", i, events)
+		}
+		for j, event := range events {
+			if event.Type != step.events[j] || event.InverterSerial != "0000000001" {
+				t.Errorf("step %d gave %+v", i, event)
+			}
+		}
+		if len(events) == 0 || events[0].Type != EVENT_GRID_RESTORED {
+			continue
+		}
+
+		restored := events[0]
+		if !restored.Start.Equal(start.Add(time.Minute)) || restored.Duration != 5*60 {
+			t.Errorf("outage from %v for %vs", restored.Start, restored.Duration)
+		}
+		// 1200 W for four minutes, nothing during the first one
+		if math.Abs(restored.Battery_Energy-0.08) > 1e-9 || math.Abs(restored.EPS_Energy-0.08) > 1e-9 {
+			t.Errorf("battery %v kWh, EPS %v kWh", restored.Battery_Energy, restored.EPS_Energy)
+		}
+		if restored.Start_SOC != 80 || restored.Min_SOC != 70 || restored.End_SOC != 71 {
+			t.Errorf("SOC %v, %v, %v", restored.Start_SOC, restored.Min_SOC, restored.End_SOC)
+		}
+	}
+}
+
+func TestOutageDataGap(t *testing.T) {
+	tracker := NewTracker()
+	start := time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	tracker.Update(luxtest.Frame(start, luxproto.LogDataSection1{Status: luxproto.STATUS_BATTERY_OFF_GRID, Active_EPS_Power: 1000, Discharge_Power: 1000, SOC: 80}))
+	events := tracker.Update(luxtest.Frame(start.Add(time.Hour), luxproto.LogDataSection1{Status: luxproto.STATUS_PV_ON_GRID, Voltage_AC_R: 230, SOC: 60}))
+	if len(events) != 1 || math.Abs(events[0].Battery_Energy-1000*MAX_SAMPLE_GAP.Hours()/1000) > 1e-9 {
+		t.Errorf("events %+v", events)
+	}
+}
+
+func TestSwitchoverInOneSample(t *testing.T) {
+	tracker := NewTracker()
+	now := time.Now()
+
+	tracker.Update(luxtest.Frame(now, luxproto.LogDataSection1{Status: luxproto.STATUS_PV_ON_GRID, Voltage_AC_R: 230, SOC: 80}))
+	events := tracker.Update(luxtest.Frame(now.Add(time.Second), luxproto.LogDataSection1{Status: luxproto.STATUS_PV_OFF_GRID, Active_EPS_Power: 400, SOC: 80}))
+	if len(events) != 2 || events[0].Type != EVENT_GRID_LOST || events[1].Type != EVENT_EPS_ACTIVE {
+		t.Errorf("events %+v", events)
+	}
+
+	// Another inverter on the same dongle still has its grid
+	other := luxtest.Frame(now.Add(time.Second), luxproto.LogDataSection1{Status: luxproto.STATUS_PV_ON_GRID, Voltage_AC_R: 230, SOC: 80})
+	other.InverterSerial = "0000000002"
+	if events := tracker.Update(other); len(events) != 0 {
+		t.Errorf("second inverter gave %+v", events)
+	}
+}
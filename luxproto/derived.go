@@ -0,0 +1,71 @@
+package luxproto
+
+// LogDataDerived holds values computed from Section1, the ones otherwise
+// worked out again in every dashboard. Powers are in W, energies in kWh and
+// ratios between 0 and 1.
+type LogDataDerived struct {
+	Loaded           bool
+	PV_Power         float32 // All strings together
+	Load_Power       float32 // House consumption, on-grid and EPS
+	Battery_Power    float32 // Positive while charging
+	Grid_Power       float32 // Positive while importing
+	Self_Consumption float32 // Share of the PV power used on site
+	Autarky          float32 // Share of the load not taken from the grid
+
+	PV_Energy_Today        float32
+	Load_Energy_Today      float32
+	Battery_Energy_Today   float32
+	Self_Consumption_Today float32
+	Autarky_Today          float32
+
+	// Filled in by luxbattery.Estimator, which keeps the smoothed battery
+	// power between frames. Times are in minutes, 0 when the battery is idle.
+	Time_To_Empty      float32
+	Time_To_Full       float32
+	Runtime_Confidence float32 // 0 for no estimate up to 1
+}
+
+// Derive computes the derived values from the scaled sections. It only
+// needs Section1 and leaves Derived unloaded without it.
+func (log *LogData) Derive() {
+	log.Derived = LogDataDerived{Loaded: log.Section1.Loaded}
+	if !log.Section1.Loaded {
+		return
+	}
+	section := log.Section1
+	derived := &log.Derived
+
+	derived.PV_Power = section.PV1_Power + section.PV2_Power + section.PV3_Power
+	derived.Battery_Power = section.Charge_Power - section.Discharge_Power
+	derived.Grid_Power = section.Power_From_Grid - section.Power_To_Grid
+	derived.Load_Power = load(derived.PV_Power, derived.Battery_Power, derived.Grid_Power)
+	derived.Self_Consumption = ratio(derived.PV_Power-section.Power_To_Grid, derived.PV_Power)
+	derived.Autarky = ratio(derived.Load_Power-section.Power_From_Grid, derived.Load_Power)
+
+	derived.PV_Energy_Today = section.PV1_Energy_Today + section.PV2_Energy_Today + section.PV3_Energy_Today
+	derived.Battery_Energy_Today = section.Charging_Today - section.Discharging_Today
+	derived.Load_Energy_Today = load(derived.PV_Energy_Today, derived.Battery_Energy_Today, section.Grid_Today-section.Exported_Today)
+	derived.Self_Consumption_Today = ratio(derived.PV_Energy_Today-section.Exported_Today, derived.PV_Energy_Today)
+	derived.Autarky_Today = ratio(derived.Load_Energy_Today-section.Grid_Today, derived.Load_Energy_Today)
+}
+
+// load balances what comes in from PV and the grid against what goes into
+// the battery. Measurement noise can push it just below zero.
+func load(pv float32, battery float32, grid float32) float32 {
+	load := pv - battery + grid
+	if load < 0 {
+		return 0
+	}
+	return load
+}
+
+// ratio returns part/whole limited to 0..1, and 0 when there is no whole.
+func ratio(part float32, whole float32) float32 {
+	if whole <= 0 || part <= 0 {
+		return 0
+	}
+	if part > whole {
+		return 1
+	}
+	return part / whole
+}
@@ -0,0 +1,64 @@
+package luxproto
+
+import "testing"
+
+func TestDerive(t *testing.T) {
+	log := LogData{}
+	log.Section1 = LogDataSection1{
+		Loaded:            true,
+		PV1_Power:         3000,
+		PV2_Power:         1000,
+		Charge_Power:      1500,
+		Power_To_Grid:     500,
+		PV1_Energy_Today:  15,
+		PV2_Energy_Today:  5,
+		Charging_Today:    8,
+		Discharging_Today: 6,
+		Grid_Today:        4,
+		Exported_Today:    2,
+	}
+	log.Derive()
+
+	checks := []struct {
+		name     string
+		value    float32
+		expected float32
+	}{
+		{"PV_Power", log.Derived.PV_Power, 4000},
+		{"Battery_Power", log.Derived.Battery_Power, 1500},
+		{"Grid_Power", log.Derived.Grid_Power, -500},
+		{"Load_Power", log.Derived.Load_Power, 2000},
+		{"Self_Consumption", log.Derived.Self_Consumption, 0.875},
+		{"Autarky", log.Derived.Autarky, 1},
+		{"PV_Energy_Today", log.Derived.PV_Energy_Today, 20},
+		{"Battery_Energy_Today", log.Derived.Battery_Energy_Today, 2},
+		{"Load_Energy_Today", log.Derived.Load_Energy_Today, 20},
+		{"Self_Consumption_Today", log.Derived.Self_Consumption_Today, 0.9},
+		{"Autarky_Today", log.Derived.Autarky_Today, 0.8},
+	}
+	for _, check := range checks {
+		if check.value != check.expected {
+			t.Errorf("%s is %v, expected %v", check.name, check.value, check.expected)
+		}
+	}
+}
+
+func TestDeriveNight(t *testing.T) {
+	log := LogData{}
+	log.Section1 = LogDataSection1{
+		Loaded:          true,
+		Discharge_Power: 300,
+		Power_From_Grid: 100,
+	}
+	log.Derive()
+
+	if log.Derived.Load_Power != 400 || log.Derived.Self_Consumption != 0 || log.Derived.Autarky != 0.75 {
+		t.Errorf("derived %+v", log.Derived)
+	}
+
+	log.Section1.Loaded = false
+	log.Derive()
+	if log.Derived.Loaded || log.Derived.Load_Power != 0 {
+		t.Error("derived values without Section1")
+	}
+}
@@ -0,0 +1,94 @@
+package luxproto
+
+import "fmt"
+
+// Condition is one active fault or warning, such as E021 "PV voltage high".
+type Condition struct {
+	Code        string
+	Description string
+}
+
+// Meaning of each bit of FaultCode, from the inverter manual
+var faultDescriptions = [32]string{
+	0:  "Internal communication fault 1",
+	1:  "Model fault",
+	8:  "CAN communication error in parallel system",
+	9:  "Master lost in parallel system",
+	10: "Multiple master units in parallel system",
+	11: "AC input inconsistent in parallel system",
+	12: "UPS short circuit",
+	13: "UPS reverse current",
+	14: "Bus short circuit",
+	15: "Phase error in three phase system",
+	16: "Relay check fault",
+	17: "Internal communication fault 2",
+	18: "Internal communication fault 3",
+	19: "Bus voltage high",
+	20: "EPS connection fault",
+	21: "PV voltage high",
+	22: "Over current protection",
+	23: "Neutral fault",
+	24: "PV short circuit",
+	25: "Radiator temperature over range",
+	26: "Internal fault",
+	27: "Sample inconsistent between main CPU and redundant CPU",
+	31: "Internal communication fault 4",
+}
+
+// Meaning of each bit of WarningCode, from the inverter manual
+var warningDescriptions = [32]string{
+	0:  "Battery communication failure",
+	1:  "AFCI communication failure",
+	2:  "AFCI high",
+	3:  "Meter communication failure",
+	4:  "Both charge and discharge forbidden by battery",
+	5:  "Auto test failed",
+	7:  "LCD communication failure",
+	8:  "Firmware version mismatch",
+	9:  "Fan stuck",
+	11: "Parallel number out of range",
+	12: "Battery on MOS",
+	13: "Over temperature",
+	15: "Battery reverse connection",
+	16: "Grid power outage",
+	17: "Grid voltage out of range",
+	18: "Grid frequency out of range",
+	20: "PV insulation low",
+	21: "Leakage current high",
+	22: "DCI high",
+	23: "PV short",
+	25: "Battery voltage high",
+	26: "Battery voltage low",
+	27: "Battery open circuit",
+	28: "EPS overload",
+	29: "EPS voltage high",
+	30: "Meter reversed",
+	31: "DCV high",
+}
+
+// Faults returns the conditions set in a FaultCode, E000 for bit 0 and so on.
+func Faults(code uint32) []Condition {
+	return conditions(code, "E", faultDescriptions, "Unknown fault")
+}
+
+// Warnings returns the conditions set in a WarningCode, W000 for bit 0 and so
+// on.
+func Warnings(code uint32) []Condition {
+	return conditions(code, "W", warningDescriptions, "Unknown warning")
+}
+
+func conditions(code uint32, prefix string, descriptions [32]string, unknown string) []Condition {
+	// Never nil, so JSON shows an empty list rather than null
+	active := []Condition{}
+	for bit := 0; bit < 32; bit++ {
+		if code&(1<<bit) == 0 {
+			continue
+		}
+		description := descriptions[bit]
+		if description == "" {
+			description = unknown
+		}
+		active = append(active, Condition{fmt.Sprintf("%s%03d", prefix, bit), description})
+	}
+	return active
+}
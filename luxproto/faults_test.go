@@ -0,0 +1,39 @@
+package luxproto
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     uint32
+		expected []Condition
+	}{
+		{"None", 0, []Condition{}},
+		{"First", 1, []Condition{{"E000", "Internal communication fault 1"}}},
+		{"Two", 1<<21 | 1<<25, []Condition{{"E021", "PV voltage high"}, {"E025", "Radiator temperature over range"}}},
+		{"Last", 1 << 31, []Condition{{"E031", "Internal communication fault 4"}}},
+		{"Reserved", 1 << 4, []Condition{{"E004", "Unknown fault"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if faults := Faults(test.code); !reflect.DeepEqual(faults, test.expected) {
+				t.Errorf("got %v, expected %v", faults, test.expected)
+			}
+		})
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	warnings := Warnings(1<<16 | 1<<26 | 1<<6)
+	expected := []Condition{{"W006", "Unknown warning"}, {"W016", "Grid power outage"}, {"W026", "Battery voltage low"}}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("got %v, expected %v", warnings, expected)
+	}
+	if len(Warnings(0xFFFFFFFF)) != 32 {
+		t.Error("not every bit decoded")
+	}
+}
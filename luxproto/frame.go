@@ -0,0 +1,224 @@
+package luxproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	PROTOCOL_VERSION = 2
+	HEADER_LENGTH    = 20
+)
+
+const (
+	ADDRESS_REQUEST  = 0x00
+	ADDRESS_RESPONSE = 0x01
+)
+
+// Message is the Modbus style request or response to the inverter carried
+// in the data section of a FUNCTION_DATA frame.
+type Message struct {
+	Address        uint8
+	DeviceFunction uint8
+	SerialNumber   [10]byte
+	Register       uint16
+	Count          uint16 // Registers to read or write
+	Values         []byte // Register values, two bytes per register
+}
+
+func (msg Message) String() string {
+	return fmt.Sprintf("Message Address: %02X DeviceFunction: %02X Serial: %s Register: %d Count: %d Values: % X",
+		msg.Address,
+		msg.DeviceFunction,
+		msg.SerialNumber,
+		msg.Register,
+		msg.Count,
+		msg.Values)
+}
+
+// Bytes encodes the message including its trailing CRC. The layout depends
+// on the device function and on whether it is a request or a response.
+func (msg Message) Bytes() []byte {
+	buffer := bytes.Buffer{}
+	buffer.WriteByte(msg.Address)
+	buffer.WriteByte(msg.DeviceFunction)
+	buffer.Write(msg.SerialNumber[:])
+	binary.Write(&buffer, binary.LittleEndian, msg.Register)
+
+	request := msg.Address == ADDRESS_REQUEST
+	switch {
+	case msg.DeviceFunction&DEVICE_EXCEPTION != 0:
+		buffer.Write(msg.Values)
+	case msg.DeviceFunction == DEVICE_WRITESINGLE:
+		value := make([]byte, 2)
+		copy(value, msg.Values)
+		buffer.Write(value)
+	case msg.DeviceFunction == DEVICE_WRITEMULTI && request:
+		binary.Write(&buffer, binary.LittleEndian, msg.Count)
+		buffer.WriteByte(uint8(len(msg.Values)))
+		buffer.Write(msg.Values)
+	case request:
+		binary.Write(&buffer, binary.LittleEndian, msg.Count)
+	case msg.DeviceFunction == DEVICE_WRITEMULTI:
+		binary.Write(&buffer, binary.LittleEndian, msg.Count)
+	default:
+		buffer.WriteByte(uint8(len(msg.Values)))
+		buffer.Write(msg.Values)
+	}
+
+	binary.Write(&buffer, binary.LittleEndian, CRC16(buffer.Bytes()))
+	return buffer.Bytes()
+}
+
+// ParseMessage decodes the data section of a FUNCTION_DATA frame and checks
+// its CRC.
+func ParseMessage(data []byte) (Message, error) {
+	if len(data) < 16 {
+		return Message{}, errors.New("message too short")
+	}
+
+	if CRC16(data[:len(data)-2]) != binary.LittleEndian.Uint16(data[len(data)-2:]) {
+		return Message{}, errors.New("message CRC mismatch")
+	}
+
+	msg := Message{
+		Address:        data[0],
+		DeviceFunction: data[1],
+		Register:       binary.LittleEndian.Uint16(data[12:14]),
+	}
+	copy(msg.SerialNumber[:], data[2:12])
+	body := data[14 : len(data)-2]
+
+	request := msg.Address == ADDRESS_REQUEST
+	switch {
+	case msg.DeviceFunction&DEVICE_EXCEPTION != 0:
+		// Exception code of a failed request
+		msg.Values = body
+	case msg.DeviceFunction == DEVICE_WRITESINGLE:
+		if len(body) != 2 {
+			return Message{}, errors.New("invalid write single length")
+		}
+		msg.Count = 1
+		msg.Values = body
+	case msg.DeviceFunction == DEVICE_WRITEMULTI && request:
+		if len(body) < 3 || int(body[2]) != len(body)-3 {
+			return Message{}, errors.New("invalid write multi length")
+		}
+		msg.Count = binary.LittleEndian.Uint16(body[0:2])
+		msg.Values = body[3:]
+	case request, msg.DeviceFunction == DEVICE_WRITEMULTI:
+		if len(body) != 2 {
+			return Message{}, errors.New("invalid register count length")
+		}
+		msg.Count = binary.LittleEndian.Uint16(body[0:2])
+	default:
+		if len(body) < 1 || int(body[0]) != len(body)-1 {
+			return Message{}, errors.New("invalid value length")
+		}
+		msg.Values = body[1:]
+		msg.Count = uint16(len(msg.Values) / 2)
+	}
+
+	return msg, nil
+}
+
+// EncodeFrame wraps data in a frame header for the dongle with the given
+// serial number.
+func EncodeFrame(function uint8, serial [10]byte, data []byte) []byte {
+	header := Header{
+		Prefix:          PREFIX,
+		ProtocolVersion: PROTOCOL_VERSION,
+		PacketLength:    uint16(HEADER_LENGTH + len(data) - 6),
+		Address:         ADDRESS_RESPONSE,
+		Function:        function,
+		SerialNumber:    serial,
+		Reserved:        uint16(len(data)),
+	}
+	return header.Encode(data)
+}
+
+// Encode writes the header exactly as it is followed by data. Unlike
+// EncodeFrame it does not fill in the length fields.
+func (head Header) Encode(data []byte) []byte {
+	buffer := bytes.Buffer{}
+	binary.Write(&buffer, binary.LittleEndian, head)
+	buffer.Write(data)
+	return buffer.Bytes()
+}
+
+// EncodeHeartbeat builds the heartbeat frame, which is shorter than a full
+// header.
+func EncodeHeartbeat(serial [10]byte) []byte {
+	frame := EncodeFrame(FUNCTION_HEARTBEAT, serial, nil)
+	frame = append(frame[:HEADER_LENGTH-2], 0)
+	binary.LittleEndian.PutUint16(frame[4:6], uint16(len(frame)-6))
+	return frame
+}
+
+// ParseFrame checks the prefix and length of a frame and splits it into its
+// header and data section. Frames shorter than a full header, such as the
+// heartbeat, return an empty data section.
+func ParseFrame(frame []byte) (Header, []byte, error) {
+	header := Header{}
+	if len(frame) < HEADER_LENGTH-2 {
+		return header, nil, errors.New("frame too short")
+	}
+
+	header.Prefix = binary.LittleEndian.Uint16(frame[0:2])
+	header.ProtocolVersion = binary.LittleEndian.Uint16(frame[2:4])
+	header.PacketLength = binary.LittleEndian.Uint16(frame[4:6])
+	header.Address = frame[6]
+	header.Function = frame[7]
+	copy(header.SerialNumber[:], frame[8:18])
+
+	if header.Prefix != PREFIX {
+		return header, nil, fmt.Errorf("invalid header prefix %04X", header.Prefix)
+	}
+
+	if int(header.PacketLength)+6 != len(frame) {
+		return header, nil, fmt.Errorf("invalid length %d", header.PacketLength)
+	}
+
+	if len(frame) < HEADER_LENGTH {
+		return header, nil, nil
+	}
+
+	header.Reserved = binary.LittleEndian.Uint16(frame[18:20])
+	return header, frame[HEADER_LENGTH:], nil
+}
+
+// CRC16 is the Modbus CRC protecting the data section of a frame.
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Registers returns the register values of the message.
+func (msg Message) Registers() []uint16 {
+	registers := make([]uint16, len(msg.Values)/2)
+	for i := range registers {
+		registers[i] = binary.LittleEndian.Uint16(msg.Values[2*i:])
+	}
+	return registers
+}
+
+// EncodeRegisters turns register values into the Values of a message.
+func EncodeRegisters(registers []uint16) []byte {
+	values := make([]byte, 2*len(registers))
+	for i, register := range registers {
+		binary.LittleEndian.PutUint16(values[2*i:], register)
+	}
+	return values
+}
@@ -0,0 +1,54 @@
+package luxproto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	// Check value of CRC-16/MODBUS
+	if crc := CRC16([]byte("123456789")); crc != 0x4B37 {
+		t.Errorf("CRC16 is %04X, expected 4B37", crc)
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	serial := [10]byte{'0', '1', '2', '3', '4', '5', '6', '7', '8', '9'}
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"ReadInputRequest", Message{Address: ADDRESS_REQUEST, DeviceFunction: DEVICE_READINPUT, SerialNumber: serial, Register: 40, Count: 40}},
+		{"ReadHoldRequest", Message{Address: ADDRESS_REQUEST, DeviceFunction: DEVICE_READHOLD, SerialNumber: serial, Register: 0, Count: 127}},
+		{"ReadInputResponse", Message{Address: ADDRESS_RESPONSE, DeviceFunction: DEVICE_READINPUT, SerialNumber: serial, Register: 80, Count: 2, Values: []byte{1, 2, 3, 4}}},
+		{"WriteSingleRequest", Message{Address: ADDRESS_REQUEST, DeviceFunction: DEVICE_WRITESINGLE, SerialNumber: serial, Register: 21, Count: 1, Values: []byte{0x34, 0x12}}},
+		{"WriteSingleResponse", Message{Address: ADDRESS_RESPONSE, DeviceFunction: DEVICE_WRITESINGLE, SerialNumber: serial, Register: 21, Count: 1, Values: []byte{0x34, 0x12}}},
+		{"WriteMultiRequest", Message{Address: ADDRESS_REQUEST, DeviceFunction: DEVICE_WRITEMULTI, SerialNumber: serial, Register: 68, Count: 2, Values: []byte{1, 0, 2, 0}}},
+		{"WriteMultiResponse", Message{Address: ADDRESS_RESPONSE, DeviceFunction: DEVICE_WRITEMULTI, SerialNumber: serial, Register: 68, Count: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame := EncodeFrame(FUNCTION_DATA, serial, test.msg.Bytes())
+
+			header, data, err := ParseFrame(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Function != FUNCTION_DATA || header.SerialNumber != serial {
+				t.Errorf("unexpected header %v", header)
+			}
+
+			msg, err := ParseMessage(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.String() != test.msg.String() {
+				t.Errorf("got %v\nexpected %v", msg, test.msg)
+			}
+			if !bytes.Equal(header.Encode(msg.Bytes()), frame) {
+				t.Error("re-encoded frame differs")
+			}
+		})
+	}
+}
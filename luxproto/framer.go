@@ -0,0 +1,95 @@
+package luxproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+const (
+	MAX_FRAME_LENGTH = 1024
+)
+
+var prefixBytes = []byte{PREFIX & 0xFF, PREFIX >> 8}
+
+// ScanFrames is a bufio.SplitFunc that splits a dongle byte stream into
+// complete frames. Bytes in front of a frame prefix are dropped so that the
+// stream resynchronises after a partial or corrupted frame.
+func ScanFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	skipped := 0
+	for {
+		start := bytes.Index(data[skipped:], prefixBytes)
+		if start < 0 {
+			if atEOF {
+				return len(data), nil, nil
+			}
+			// Keep the last byte, it may be the first half of a prefix
+			if len(data)-1 > skipped {
+				return len(data) - 1, nil, nil
+			}
+			return skipped, nil, nil
+		}
+		skipped += start
+		frame := data[skipped:]
+
+		if len(frame) < 6 {
+			if atEOF {
+				return len(data), nil, nil
+			}
+			return skipped, nil, nil
+		}
+
+		length := int(binary.LittleEndian.Uint16(frame[4:6])) + 6
+		if length > MAX_FRAME_LENGTH || (atEOF && len(frame) < length) {
+			// Not a real frame, skip the prefix and look for the next one
+			skipped += len(prefixBytes)
+			continue
+		}
+
+		if len(frame) < length {
+			return skipped, nil, nil
+		}
+
+		return skipped + length, frame[:length], nil
+	}
+}
+
+// FrameBuffer feeds stream data that arrives in pieces through ScanFrames.
+// It is the push based counterpart of a bufio.Scanner using ScanFrames.
+type FrameBuffer struct {
+	buffer []byte
+}
+
+// Write appends data to the buffer and calls frame for every frame that is
+// now complete. The frame slice is only valid during the call.
+func (buf *FrameBuffer) Write(data []byte, when time.Time, frame func([]byte, time.Time)) {
+	buf.buffer = append(buf.buffer, data...)
+	buf.scan(false, when, frame)
+}
+
+// Flush processes whatever is left in the buffer as the end of the stream.
+func (buf *FrameBuffer) Flush(when time.Time, frame func([]byte, time.Time)) {
+	buf.scan(true, when, frame)
+	buf.buffer = nil
+}
+
+// Reset drops buffered data, for example after a gap in the stream.
+func (buf *FrameBuffer) Reset() {
+	buf.buffer = buf.buffer[:0]
+}
+
+func (buf *FrameBuffer) scan(atEOF bool, when time.Time, frame func([]byte, time.Time)) {
+	for len(buf.buffer) > 0 {
+		advance, token, _ := ScanFrames(buf.buffer, atEOF)
+		if advance == 0 {
+			break
+		}
+		if token != nil {
+			frame(token, when)
+		}
+		buf.buffer = buf.buffer[advance:]
+	}
+
+	// Move the remainder to the front so the buffer does not grow forever
+	buf.buffer = append(buf.buffer[:0:0], buf.buffer...)
+}
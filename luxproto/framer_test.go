@@ -0,0 +1,122 @@
+package luxproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func scanAll(data []byte) [][]byte {
+	frames := [][]byte{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, MAX_FRAME_LENGTH), MAX_FRAME_LENGTH)
+	scanner.Split(ScanFrames)
+	for scanner.Scan() {
+		frames = append(frames, bytes.Clone(scanner.Bytes()))
+	}
+	return frames
+}
+
+func bufferAll(parts ...[]byte) [][]byte {
+	frames := [][]byte{}
+	collect := func(frame []byte, when time.Time) {
+		frames = append(frames, bytes.Clone(frame))
+	}
+
+	buffer := FrameBuffer{}
+	for _, part := range parts {
+		buffer.Write(part, time.Time{}, collect)
+	}
+	buffer.Flush(time.Time{}, collect)
+	return frames
+}
+
+func TestScanFrames(t *testing.T) {
+	first := readFrame(t, "testdata/frames/sim_input_0_40.hex")
+	second := readFrame(t, "testdata/frames/sim_input_0_127_day.hex")
+	heartbeat := EncodeHeartbeat([10]byte{})
+
+	oversized := bytes.Clone(first[:6])
+	binary.LittleEndian.PutUint16(oversized[4:6], MAX_FRAME_LENGTH)
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tests := []struct {
+		name     string
+		stream   []byte
+		expected [][]byte
+	}{
+		{"Empty", nil, [][]byte{}},
+		{"Single", first, [][]byte{first}},
+		{"Consecutive", join(first, heartbeat, second), [][]byte{first, heartbeat, second}},
+		{"LeadingGarbage", join([]byte{0x00, 0xA1, 0x42}, first), [][]byte{first}},
+		{"GarbageBetween", join(first, []byte{0x1A, 0xA1}, second), [][]byte{first, second}},
+		{"TruncatedEnd", join(first, second[:50]), [][]byte{first}},
+		{"OversizedLength", join(oversized, first), [][]byte{first}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frames := scanAll(test.stream)
+			if len(frames) != len(test.expected) {
+				t.Fatalf("got %d frames, expected %d", len(frames), len(test.expected))
+			}
+			for i := range frames {
+				if !bytes.Equal(frames[i], test.expected[i]) {
+					t.Errorf("frame %d differs", i)
+				}
+			}
+		})
+	}
+}
+
+func TestFrameBufferSplitWrites(t *testing.T) {
+	first := readFrame(t, "testdata/frames/sim_input_40_40.hex")
+	second := readFrame(t, "testdata/frames/sim_input_80_40.hex")
+	stream := append(bytes.Clone(first), second...)
+
+	// Split everywhere, including in the middle of the prefix
+	for split := 1; split < len(stream); split++ {
+		frames := bufferAll(stream[:split], stream[split:])
+		if len(frames) != 2 || !bytes.Equal(frames[0], first) || !bytes.Equal(frames[1], second) {
+			t.Fatalf("split at %d returned %d frames", split, len(frames))
+		}
+	}
+}
+
+func FuzzScanFrames(f *testing.F) {
+	first := readFrame(f, "testdata/frames/sim_input_0_40.hex")
+	f.Add(first, 10)
+	f.Add(append(bytes.Clone(first), first...), len(first)+1)
+	f.Add([]byte{0xA1, 0x1A, 0xA1, 0x1A, 0x00, 0x00}, 3)
+
+	f.Fuzz(func(t *testing.T, stream []byte, split int) {
+		frames := scanAll(stream)
+		for _, frame := range frames {
+			if !bytes.HasPrefix(frame, prefixBytes) {
+				t.Fatalf("frame without prefix: % X", frame)
+			}
+			if int(binary.LittleEndian.Uint16(frame[4:6]))+6 != len(frame) {
+				t.Fatalf("frame length does not match header: % X", frame)
+			}
+		}
+
+		// Data arriving in pieces must give the same frames
+		if split < 0 || split > len(stream) {
+			split = len(stream) / 2
+		}
+		buffered := bufferAll(stream[:split], stream[split:])
+		if len(buffered) != len(frames) {
+			t.Fatalf("FrameBuffer returned %d frames, scanner %d", len(buffered), len(frames))
+		}
+		for i := range frames {
+			if !bytes.Equal(frames[i], buffered[i]) {
+				t.Fatalf("frame %d differs between FrameBuffer and scanner", i)
+			}
+		}
+	})
+}
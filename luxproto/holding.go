@@ -0,0 +1,35 @@
+package luxproto
+
+// Holding registers LuxLogger writes. Each charge and discharge schedule has
+// HOLD_WINDOWS windows of a start and an end time, see HoldTime.
+const (
+	HOLD_FUNCTIONS              = 21
+	HOLD_AC_CHARGE_POWER        = 66 // Percent of the rated power
+	HOLD_AC_CHARGE_SOC          = 67 // Stop charging at this SOC
+	HOLD_AC_CHARGE_TIMES        = 68
+	HOLD_FORCED_DISCHARGE_POWER = 82 // Percent of the rated power
+	HOLD_FORCED_DISCHARGE_SOC   = 83 // Stop discharging at this SOC
+	HOLD_FORCED_DISCHARGE_TIMES = 84
+	HOLD_WINDOWS                = 3
+	// HOLD_EXPORT_LIMIT caps the power fed into the grid, in percent of the
+	// rated power, while ENABLE_EXPORT_LIMIT is set
+	HOLD_EXPORT_LIMIT = 103
+)
+
+// Bits of HOLD_FUNCTIONS
+const (
+	ENABLE_AC_CHARGE        = 1 << 7
+	ENABLE_FORCED_DISCHARGE = 1 << 10
+	ENABLE_EXPORT_LIMIT     = 1 << 15
+)
+
+// HoldTime encodes a time of day as the schedule registers hold it, the hour
+// in the low byte and the minute in the high byte.
+func HoldTime(hour int, minute int) uint16 {
+	return uint16(hour&0xFF) | uint16(minute&0xFF)<<8
+}
+
+// SplitHoldTime decodes a time of day of the schedule registers.
+func SplitHoldTime(value uint16) (hour int, minute int) {
+	return int(value & 0xFF), int(value >> 8)
+}
@@ -0,0 +1,13 @@
+package luxproto
+
+import "testing"
+
+func TestHoldTime(t *testing.T) {
+	value := HoldTime(23, 30)
+	if value != 0x1E17 {
+		t.Errorf("23:30 encoded as %04X", value)
+	}
+	if hour, minute := SplitHoldTime(value); hour != 23 || minute != 30 {
+		t.Errorf("%04X decoded as %d:%d", value, hour, minute)
+	}
+}
@@ -0,0 +1,474 @@
+package luxproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// LogDataRawSection1 is input registers 0 to 39 as sent by the inverter.
+type LogDataRawSection1 struct {
+	Status                      uint16
+	PV1_Voltage                 uint16
+	PV2_Voltage                 uint16
+	PV3_Voltage                 uint16
+	Battery_Voltage             uint16
+	SOC                         uint8
+	SOH                         uint8
+	_                           int16
+	PV1_Power                   uint16
+	PV2_Power                   uint16
+	PV3_Power                   uint16
+	Charge_Power                uint16
+	Discharge_Power             uint16
+	Voltage_AC_R                uint16
+	Voltage_AC_S                uint16
+	Voltage_AC_T                uint16
+	Frequency_Grid              uint16
+	ActiveInverter_Power        uint16
+	ActiveCharge_Power          uint16
+	Inductor_Current            uint16
+	Grid_Power_Factor           int16
+	Voltage_EPS_R               uint16
+	Voltage_EPS_S               uint16
+	Voltage_EPS_T               uint16
+	Frequency_EPS               uint16
+	Active_EPS_Power            uint16
+	Apparent_EPS_Power          uint16
+	Power_To_Grid               uint16
+	Power_From_Grid             uint16
+	PV1_Energy_Today            uint16
+	PV2_Energy_Today            uint16
+	PV3_Energy_Today            uint16
+	ActiveInverter_Energy_Today uint16
+	AC_Charging_Today           uint16
+	Charging_Today              uint16
+	Discharging_Today           uint16
+	EPS_Today                   uint16
+	Exported_Today              uint16
+	Grid_Today                  uint16
+	Bus1_Voltage                uint16
+	Bus2_Voltage                uint16
+}
+
+// LogDataSection1 is LogDataRawSection1 scaled to engineering units.
+type LogDataSection1 struct {
+	Loaded                      bool
+	Status                      uint16
+	Status_Label                string
+	PV1_Voltage                 float32
+	PV2_Voltage                 float32
+	PV3_Voltage                 float32
+	Battery_Voltage             float32
+	SOC                         float32
+	SOH                         float32
+	PV1_Power                   float32
+	PV2_Power                   float32
+	PV3_Power                   float32
+	Charge_Power                float32
+	Discharge_Power             float32
+	Voltage_AC_R                float32
+	Voltage_AC_S                float32
+	Voltage_AC_T                float32
+	Frequency_Grid              float32
+	ActiveCharge_Power          float32
+	ActiveInverter_Power        float32
+	Inductor_Current            float32
+	Grid_Power_Factor           float32
+	Voltage_EPS_R               float32
+	Voltage_EPS_S               float32
+	Voltage_EPS_T               float32
+	Frequency_EPS               float32
+	Active_EPS_Power            float32
+	Apparent_EPS_Power          float32
+	Power_To_Grid               float32
+	Power_From_Grid             float32
+	PV1_Energy_Today            float32
+	PV2_Energy_Today            float32
+	PV3_Energy_Today            float32
+	ActiveInverter_Energy_Today float32
+	AC_Charging_Today           float32
+	Charging_Today              float32
+	Discharging_Today           float32
+	EPS_Today                   float32
+	Exported_Today              float32
+	Grid_Today                  float32
+	Bus1_Voltage                float32
+	Bus2_Voltage                float32
+}
+
+// LogDataRawSection2 is input registers 40 to 79 as sent by the inverter.
+type LogDataRawSection2 struct {
+	PV1_Energy_Total            U32
+	PV2_Energy_Total            U32
+	PV3_Energy_Total            U32
+	ActiveInverter_Energy_Total U32
+	AC_Charging_Total           U32
+	Charging_Total              U32
+	Discharging_Total           U32
+	EPS_Total                   U32
+	Exported_Total              U32
+	Grid_Total                  U32
+	FaultCode                   U32
+	WarningCode                 U32
+	Inner_Temperature           int16
+	Radiator1_Temperature       int16
+	Radiator2_Temperature       int16
+	Battery_Temperature         int16
+	_                           int16
+	Runtime                     U32
+	_                           [9]uint16
+}
+
+// LogDataSection2 is LogDataRawSection2 scaled to engineering units.
+type LogDataSection2 struct {
+	Loaded                      bool
+	PV1_Energy_Total            float32
+	PV2_Energy_Total            float32
+	PV3_Energy_Total            float32
+	ActiveInverter_Energy_Total float32
+	AC_Charging_Total           float32
+	Charging_Total              float32
+	Discharging_Total           float32
+	EPS_Total                   float32
+	Exported_Total              float32
+	Grid_Total                  float32
+	FaultCode                   uint32
+	WarningCode                 uint32
+	Faults                      []Condition // Decoded from FaultCode
+	Warnings                    []Condition // Decoded from WarningCode
+	Inner_Temperature           float32
+	Radiator1_Temperature       float32
+	Radiator2_Temperature       float32
+	Battery_Temperature         float32
+	Runtime                     uint32
+}
+
+// LogDataRawSection3 is input registers 80 to 119 as sent by the inverter.
+type LogDataRawSection3 struct {
+	BatteryComType               U8Pair
+	BMS_Max_Charge_Current       uint16
+	BMS_Max_Discharge_Current    uint16
+	BMS_Charge_Voltage_Reference uint16
+	BMS_Discharge_Cutoff         uint16
+	BMS_Status                   [10]uint16
+	BMS_Inverter_Status          uint16
+	Battery_Parallel_Count       uint16
+	Battery_Capacity             uint16
+	Battery_Current              int16
+	BMS_Event1                   uint16
+	BMS_Event2                   uint16
+	MaxCell_Voltage              uint16
+	MinCell_Voltage              uint16
+	MaxCell_Temp                 int16
+	MinCell_Temp                 int16
+	BMS_FW_Update_State          uint16
+	Cycle_Count                  uint16
+	BatteryInverter_Voltage      uint16
+	_                            [12]int16
+}
+
+// LogDataSection3 is LogDataRawSection3 scaled to engineering units.
+type LogDataSection3 struct {
+	Loaded                       bool
+	BatteryComType               uint16
+	Battery_Brand                uint8
+	Battery_Brand_Label          string
+	Battery_Protocol             string
+	BMS_Max_Charge_Current       float32
+	BMS_Max_Discharge_Current    float32
+	BMS_Charge_Voltage_Reference float32
+	BMS_Discharge_Cutoff         float32
+	BMS_Status                   [10]uint16
+	BMS_Requests                 []string // Decoded from BMS_Status[0]
+	BMS_Inverter_Status          uint16
+	Battery_Parallel_Count       uint16
+	Battery_Capacity             float32
+	Battery_Current              float32
+	BMS_Event1                   uint16
+	BMS_Protections              []string // Decoded from BMS_Event1
+	BMS_Event2                   uint16
+	BMS_Warnings                 []string // Decoded from BMS_Event2
+	MaxCell_Voltage              float32
+	MinCell_Voltage              float32
+	MaxCell_Temp                 float32
+	MinCell_Temp                 float32
+	BMS_FW_Update_State          uint16
+	Cycle_Count                  uint16
+	BatteryInverter_Voltage      float32
+}
+
+// LogDataRawSection4 is input registers 120 to 159 as sent by inverters with
+// newer firmware. L1 and L2 are the two legs of a split-phase EPS output.
+type LogDataRawSection4 struct {
+	_                     int16
+	Gen_Voltage           uint16
+	Gen_Frequency         uint16
+	Gen_Power             uint16
+	Gen_Energy_Today      uint16
+	Gen_Energy_Total      U32
+	EPS_L1_Voltage        uint16
+	EPS_L2_Voltage        uint16
+	EPS_L1_Power          uint16
+	EPS_L2_Power          uint16
+	EPS_L1_Apparent_Power uint16
+	EPS_L2_Apparent_Power uint16
+	EPS_L1_Energy_Today   uint16
+	EPS_L2_Energy_Today   uint16
+	EPS_L1_Energy_Total   U32
+	EPS_L2_Energy_Total   U32
+	_                     [14]int16
+	AC_Couple_Power       uint16
+	Load_Power_L1         uint16
+	Load_Power_L2         uint16
+	Load_Power_L3         uint16
+	_                     [3]int16
+}
+
+// LogDataSection4 is LogDataRawSection4 scaled to engineering units.
+type LogDataSection4 struct {
+	Loaded                bool
+	Gen_Voltage           float32
+	Gen_Frequency         float32
+	Gen_Power             float32
+	Gen_Energy_Today      float32
+	Gen_Energy_Total      float32
+	EPS_L1_Voltage        float32
+	EPS_L2_Voltage        float32
+	EPS_L1_Power          float32
+	EPS_L2_Power          float32
+	EPS_L1_Apparent_Power float32
+	EPS_L2_Apparent_Power float32
+	EPS_L1_Energy_Today   float32
+	EPS_L2_Energy_Today   float32
+	EPS_L1_Energy_Total   float32
+	EPS_L2_Energy_Total   float32
+	AC_Couple_Power       float32
+	Load_Power_L1         float32 // On-grid load of each phase
+	Load_Power_L2         float32
+	Load_Power_L3         float32
+}
+
+// LogDataRaw is the input register blocks in the order the inverter sends them.
+type LogDataRaw struct {
+	Section1 LogDataRawSection1
+	Section2 LogDataRawSection2
+	Section3 LogDataRawSection3
+	Section4 LogDataRawSection4
+}
+
+// LogData is one decoded frame. Only the sections with Loaded set were part
+// of the frame.
+type LogData struct {
+	Raw            LogDataRaw
+	SerialNumber   string // Dongle serial number from the frame header
+	InverterSerial string // Serial number of the inverter that sent the data
+	Time           time.Time
+	Section1       LogDataSection1
+	Section2       LogDataSection2
+	Section3       LogDataSection3
+	Section4       LogDataSection4
+	Derived        LogDataDerived
+}
+
+func (log LogData) String() string {
+	json, err := json.MarshalIndent(log, "", "\t")
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
+
+	return string(json)
+}
+
+// Decode reads an input register frame into log and scales it. It returns
+// false for frames that are not input register data.
+func (log *LogData) Decode(frame []byte, length uint16) bool {
+	if int(length) > len(frame) {
+		println("Invalid length:", length)
+		return false
+	}
+
+	header, data, err := ParseFrame(frame[:length])
+	if err != nil {
+		println("Error reading header:", err.Error())
+		return false
+	}
+
+	if header.Function != FUNCTION_DATA {
+		println("Unhandled header function:", header.Function)
+		return false
+	}
+
+	log.SerialNumber = fmt.Sprintf("%s", header.SerialNumber)
+
+	msg, err := ParseMessage(data)
+	if err != nil {
+		println("Error reading Translated data:", err.Error())
+		return false
+	}
+
+	if msg.DeviceFunction != DEVICE_READINPUT {
+		println("Unhandled device function:", msg.DeviceFunction)
+		return false
+	}
+
+	// Inverters in parallel share one dongle, only the message tells them apart
+	log.InverterSerial = fmt.Sprintf("%s", msg.SerialNumber)
+
+	return log.DecodeRegisters(msg.Register, msg.Registers())
+}
+
+// DecodeRegisters reads a block of input registers starting at register into
+// log, scales it and derives the computed values. Every section the block
+// covers completely is loaded. Sections it only covers in part are left out,
+// as a Loaded section with some fields missing would pass their zeros off as
+// readings: the 0 to 126 block of older firmware leaves out the generator
+// registers from 120, which need the extended block. A block that covers no
+// section completely is rejected.
+func (log *LogData) DecodeRegisters(register uint16, values []uint16) bool {
+	end := int(register) + len(values)
+	decoded := false
+	for _, section := range log.sections() {
+		*section.loaded = false
+		start := int(section.start)
+		size := binary.Size(section.raw) / 2
+		if start < int(register) || start+size > end {
+			continue
+		}
+
+		offset := start - int(register)
+		reader := bytes.NewReader(EncodeRegisters(values[offset : offset+size]))
+		err := binary.Read(reader, binary.LittleEndian, section.raw)
+		if err != nil {
+			println("Error reading LogData section at", start, ":", err.Error())
+			return false
+		}
+		*section.loaded = true
+		decoded = true
+	}
+
+	if !decoded {
+		println("Unhandled register:", register, "count", len(values))
+		return false
+	}
+
+	log.Scale()
+	log.Derive()
+	return true
+}
+
+// Scale converts the raw register values into the scaled sections.
+func (log *LogData) Scale() {
+	log.Section1.Status = log.Raw.Section1.Status
+	log.Section1.Status_Label = InverterStatus(log.Section1.Status).Label
+	log.Section1.PV1_Voltage = float32(log.Raw.Section1.PV1_Voltage) / 10
+	log.Section1.PV2_Voltage = float32(log.Raw.Section1.PV2_Voltage) / 10
+	log.Section1.PV3_Voltage = float32(log.Raw.Section1.PV3_Voltage) / 10
+	log.Section1.Battery_Voltage = float32(log.Raw.Section1.Battery_Voltage) / 10
+	log.Section1.SOC = float32(log.Raw.Section1.SOC)
+	log.Section1.SOH = float32(log.Raw.Section1.SOH)
+	log.Section1.PV1_Power = float32(log.Raw.Section1.PV1_Power)
+	log.Section1.PV2_Power = float32(log.Raw.Section1.PV2_Power)
+	log.Section1.PV3_Power = float32(log.Raw.Section1.PV3_Power)
+	log.Section1.Charge_Power = float32(log.Raw.Section1.Charge_Power)
+	log.Section1.Discharge_Power = float32(log.Raw.Section1.Discharge_Power)
+	log.Section1.Voltage_AC_R = float32(log.Raw.Section1.Voltage_AC_R) / 10
+	log.Section1.Voltage_AC_S = float32(log.Raw.Section1.Voltage_AC_S) / 10
+	log.Section1.Voltage_AC_T = float32(log.Raw.Section1.Voltage_AC_T) / 10
+	log.Section1.Frequency_Grid = float32(log.Raw.Section1.Frequency_Grid) / 100
+	log.Section1.ActiveCharge_Power = float32(log.Raw.Section1.ActiveCharge_Power)
+	log.Section1.ActiveInverter_Power = float32(log.Raw.Section1.ActiveInverter_Power)
+	log.Section1.Inductor_Current = float32(log.Raw.Section1.Inductor_Current) / 100
+	log.Section1.Grid_Power_Factor = float32(log.Raw.Section1.Grid_Power_Factor) / 1000
+	log.Section1.Voltage_EPS_R = float32(log.Raw.Section1.Voltage_EPS_R) / 10
+	log.Section1.Voltage_EPS_S = float32(log.Raw.Section1.Voltage_EPS_S) / 10
+	log.Section1.Voltage_EPS_T = float32(log.Raw.Section1.Voltage_EPS_T) / 10
+	log.Section1.Frequency_EPS = float32(log.Raw.Section1.Frequency_EPS) / 100
+	log.Section1.Active_EPS_Power = float32(log.Raw.Section1.Active_EPS_Power)
+	log.Section1.Apparent_EPS_Power = float32(log.Raw.Section1.Apparent_EPS_Power)
+	log.Section1.Power_To_Grid = float32(log.Raw.Section1.Power_To_Grid)
+	log.Section1.Power_From_Grid = float32(log.Raw.Section1.Power_From_Grid)
+	log.Section1.PV1_Energy_Today = float32(log.Raw.Section1.PV1_Energy_Today) / 10
+	log.Section1.PV2_Energy_Today = float32(log.Raw.Section1.PV2_Energy_Today) / 10
+	log.Section1.PV3_Energy_Today = float32(log.Raw.Section1.PV3_Energy_Today) / 10
+	log.Section1.ActiveInverter_Energy_Today = float32(log.Raw.Section1.ActiveInverter_Energy_Today) / 10
+	log.Section1.AC_Charging_Today = float32(log.Raw.Section1.AC_Charging_Today) / 10
+	log.Section1.Charging_Today = float32(log.Raw.Section1.Charging_Today) / 10
+	log.Section1.Discharging_Today = float32(log.Raw.Section1.Discharging_Today) / 10
+	log.Section1.EPS_Today = float32(log.Raw.Section1.EPS_Today) / 10
+	log.Section1.Exported_Today = float32(log.Raw.Section1.Exported_Today) / 10
+	log.Section1.Grid_Today = float32(log.Raw.Section1.Grid_Today) / 10
+	log.Section1.Bus1_Voltage = float32(log.Raw.Section1.Bus1_Voltage)
+	log.Section1.Bus2_Voltage = float32(log.Raw.Section1.Bus2_Voltage)
+
+	log.Section2.PV1_Energy_Total = float32(log.Raw.Section2.PV1_Energy_Total.Uint32()) / 10
+	log.Section2.PV2_Energy_Total = float32(log.Raw.Section2.PV2_Energy_Total.Uint32()) / 10
+	log.Section2.PV3_Energy_Total = float32(log.Raw.Section2.PV3_Energy_Total.Uint32()) / 10
+	log.Section2.ActiveInverter_Energy_Total = float32(log.Raw.Section2.ActiveInverter_Energy_Total.Uint32()) / 10
+	log.Section2.AC_Charging_Total = float32(log.Raw.Section2.AC_Charging_Total.Uint32()) / 10
+	log.Section2.Charging_Total = float32(log.Raw.Section2.Charging_Total.Uint32()) / 10
+	log.Section2.Discharging_Total = float32(log.Raw.Section2.Discharging_Total.Uint32()) / 10
+	log.Section2.EPS_Total = float32(log.Raw.Section2.EPS_Total.Uint32()) / 10
+	log.Section2.Exported_Total = float32(log.Raw.Section2.Exported_Total.Uint32()) / 10
+	log.Section2.Grid_Total = float32(log.Raw.Section2.Grid_Total.Uint32()) / 10
+	log.Section2.FaultCode = log.Raw.Section2.FaultCode.Uint32()
+	log.Section2.WarningCode = log.Raw.Section2.WarningCode.Uint32()
+	log.Section2.Faults = Faults(log.Section2.FaultCode)
+	log.Section2.Warnings = Warnings(log.Section2.WarningCode)
+	log.Section2.Inner_Temperature = float32(log.Raw.Section2.Inner_Temperature)
+	log.Section2.Radiator1_Temperature = float32(log.Raw.Section2.Radiator1_Temperature)
+	log.Section2.Radiator2_Temperature = float32(log.Raw.Section2.Radiator2_Temperature)
+	log.Section2.Battery_Temperature = float32(log.Raw.Section2.Battery_Temperature)
+	log.Section2.Runtime = log.Raw.Section2.Runtime.Uint32()
+
+	log.Section3.BatteryComType = log.Raw.Section3.BatteryComType.Uint16()
+	brand, protocol := BatteryType(log.Section3.BatteryComType)
+	log.Section3.Battery_Brand = uint8(brand.Code)
+	log.Section3.Battery_Brand_Label = brand.Label
+	log.Section3.Battery_Protocol = protocol.Label
+	log.Section3.BMS_Max_Charge_Current = float32(log.Raw.Section3.BMS_Max_Charge_Current) / 100
+	log.Section3.BMS_Max_Discharge_Current = float32(log.Raw.Section3.BMS_Max_Discharge_Current) / 100
+	log.Section3.BMS_Charge_Voltage_Reference = float32(log.Raw.Section3.BMS_Charge_Voltage_Reference) / 10
+	log.Section3.BMS_Discharge_Cutoff = float32(log.Raw.Section3.BMS_Discharge_Cutoff) / 10
+	log.Section3.BMS_Status = log.Raw.Section3.BMS_Status
+	log.Section3.BMS_Requests = BMSRequests(log.Section3.BMS_Status[0])
+	log.Section3.BMS_Inverter_Status = log.Raw.Section3.BMS_Inverter_Status
+	log.Section3.Battery_Parallel_Count = log.Raw.Section3.Battery_Parallel_Count
+	log.Section3.Battery_Capacity = float32(log.Raw.Section3.Battery_Capacity)
+	log.Section3.Battery_Current = float32(log.Raw.Section3.Battery_Current) / 100
+	log.Section3.BMS_Event1 = log.Raw.Section3.BMS_Event1
+	log.Section3.BMS_Protections = BMSProtections(log.Section3.BMS_Event1)
+	log.Section3.BMS_Event2 = log.Raw.Section3.BMS_Event2
+	log.Section3.BMS_Warnings = BMSWarnings(log.Section3.BMS_Event2)
+	// The cell voltages are in mV
+	log.Section3.MaxCell_Voltage = float32(log.Raw.Section3.MaxCell_Voltage) / 1000
+	log.Section3.MinCell_Voltage = float32(log.Raw.Section3.MinCell_Voltage) / 1000
+	log.Section3.MaxCell_Temp = float32(log.Raw.Section3.MaxCell_Temp)
+	log.Section3.MinCell_Temp = float32(log.Raw.Section3.MinCell_Temp)
+	log.Section3.BMS_FW_Update_State = log.Raw.Section3.BMS_FW_Update_State
+	log.Section3.Cycle_Count = log.Raw.Section3.Cycle_Count
+	log.Section3.BatteryInverter_Voltage = float32(log.Raw.Section3.BatteryInverter_Voltage) / 10
+
+	log.Section4.Gen_Voltage = float32(log.Raw.Section4.Gen_Voltage) / 10
+	log.Section4.Gen_Frequency = float32(log.Raw.Section4.Gen_Frequency) / 100
+	log.Section4.Gen_Power = float32(log.Raw.Section4.Gen_Power)
+	log.Section4.Gen_Energy_Today = float32(log.Raw.Section4.Gen_Energy_Today) / 10
+	log.Section4.Gen_Energy_Total = float32(log.Raw.Section4.Gen_Energy_Total.Uint32()) / 10
+	log.Section4.EPS_L1_Voltage = float32(log.Raw.Section4.EPS_L1_Voltage) / 10
+	log.Section4.EPS_L2_Voltage = float32(log.Raw.Section4.EPS_L2_Voltage) / 10
+	log.Section4.EPS_L1_Power = float32(log.Raw.Section4.EPS_L1_Power)
+	log.Section4.EPS_L2_Power = float32(log.Raw.Section4.EPS_L2_Power)
+	log.Section4.EPS_L1_Apparent_Power = float32(log.Raw.Section4.EPS_L1_Apparent_Power)
+	log.Section4.EPS_L2_Apparent_Power = float32(log.Raw.Section4.EPS_L2_Apparent_Power)
+	log.Section4.EPS_L1_Energy_Today = float32(log.Raw.Section4.EPS_L1_Energy_Today) / 10
+	log.Section4.EPS_L2_Energy_Today = float32(log.Raw.Section4.EPS_L2_Energy_Today) / 10
+	log.Section4.EPS_L1_Energy_Total = float32(log.Raw.Section4.EPS_L1_Energy_Total.Uint32()) / 10
+	log.Section4.EPS_L2_Energy_Total = float32(log.Raw.Section4.EPS_L2_Energy_Total.Uint32()) / 10
+	log.Section4.AC_Couple_Power = float32(log.Raw.Section4.AC_Couple_Power)
+	log.Section4.Load_Power_L1 = float32(log.Raw.Section4.Load_Power_L1)
+	log.Section4.Load_Power_L2 = float32(log.Raw.Section4.Load_Power_L2)
+	log.Section4.Load_Power_L3 = float32(log.Raw.Section4.Load_Power_L3)
+}
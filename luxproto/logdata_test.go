@@ -0,0 +1,369 @@
+package luxproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the expected JSON of the golden frames")
+
+func readFrame(t testing.TB, name string) []byte {
+	t.Helper()
+	text, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := hex.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func goldenFrames(t testing.TB) []string {
+	t.Helper()
+	names, err := filepath.Glob("testdata/frames/*.hex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("no golden frames in testdata/frames")
+	}
+	return names
+}
+
+func TestDecodeGolden(t *testing.T) {
+	captured, _ := filepath.Glob("testdata/frames/capture_*.hex")
+	if len(captured) == 0 {
+		t.Log("no frames captured from a real inverter, only the sim_ frames are checked")
+	}
+	for _, name := range goldenFrames(t) {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			frame := readFrame(t, name)
+
+			log := LogData{}
+			if !log.Decode(frame, uint16(len(frame))) {
+				t.Fatal("Decode failed")
+			}
+
+			decoded, err := json.MarshalIndent(log, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedName := strings.TrimSuffix(name, ".hex") + ".json"
+			if *update {
+				if err := os.WriteFile(expectedName, append(decoded, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := os.ReadFile(expectedName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bytes.TrimSpace(expected)) != string(decoded) {
+				t.Errorf("decoded frame differs from %s:\n%s", expectedName, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeSections(t *testing.T) {
+	tests := []struct {
+		name     string
+		register uint16
+		count    uint16
+		loaded   [4]bool
+	}{
+		{"Section1", 0, 40, [4]bool{true, false, false, false}},
+		{"Section2", 40, 40, [4]bool{false, true, false, false}},
+		{"Section3", 80, 40, [4]bool{false, false, true, false}},
+		{"Section4", 120, 40, [4]bool{false, false, false, true}},
+		{"All", 0, 127, [4]bool{true, true, true, false}},
+		{"Unaligned", 20, 100, [4]bool{false, true, true, false}},
+		{"Extended", 80, 80, [4]bool{false, false, true, true}},
+	}
+
+	raw := LogDataRaw{}
+	raw.Section1.SOC = 50
+	raw.Section2.Runtime = NewU32(3600)
+	raw.Section3.Cycle_Count = 12
+	raw.Section4.Gen_Power = 1500
+	registers := make([]uint16, 256)
+	copy(registers, raw.Registers())
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := make([]byte, 2*test.count)
+			for i := 0; i < int(test.count); i++ {
+				binary.LittleEndian.PutUint16(values[2*i:], registers[int(test.register)+i])
+			}
+			msg := Message{
+				Address:        ADDRESS_RESPONSE,
+				DeviceFunction: DEVICE_READINPUT,
+				Register:       test.register,
+				Values:         values,
+			}
+			frame := EncodeFrame(FUNCTION_DATA, [10]byte([]byte("BA00000001")), msg.Bytes())
+
+			log := LogData{}
+			if !log.Decode(frame, uint16(len(frame))) {
+				t.Fatal("Decode failed")
+			}
+
+			loaded := [4]bool{log.Section1.Loaded, log.Section2.Loaded, log.Section3.Loaded, log.Section4.Loaded}
+			if loaded != test.loaded {
+				t.Errorf("loaded sections %v, expected %v", loaded, test.loaded)
+			}
+			if log.SerialNumber != "BA00000001" {
+				t.Errorf("serial number %q", log.SerialNumber)
+			}
+			if log.Section1.Loaded && log.Section1.SOC != 50 ||
+				log.Section2.Loaded && log.Section2.Runtime != 3600 ||
+				log.Section3.Loaded && log.Section3.Cycle_Count != 12 ||
+				log.Section4.Loaded && log.Section4.Gen_Power != 1500 {
+				t.Error("decoded values differ from the registers sent")
+			}
+		})
+	}
+}
+
+// withMessage replaces the data section of frame, keeping the header.
+func withMessage(frame []byte, msg Message) []byte {
+	return EncodeFrame(frame[7], [10]byte(frame[8:18]), msg.Bytes())
+}
+
+func TestDecodeRejectedBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		register uint16
+		count    int
+	}{
+		{"Empty", 0, 0},
+		{"PartOfSection1", 0, 39},
+		{"AcrossSections", 20, 40},
+		{"EndOfSection3", 100, 40},
+		{"PartOfSection4", 120, 20},
+		{"BeyondSections", 160, 40},
+		{"Unknown", 200, 40},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := LogData{}
+			if log.DecodeRegisters(test.register, make([]uint16, test.count)) {
+				t.Error("block accepted")
+			}
+			if log.Section1.Loaded || log.Section2.Loaded || log.Section3.Loaded || log.Section4.Loaded {
+				t.Error("section loaded")
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := readFrame(t, "testdata/frames/sim_input_0_40.hex")
+	_, data, _ := ParseFrame(valid)
+	msg, _ := ParseMessage(data)
+
+	badPrefix := bytes.Clone(valid)
+	badPrefix[0] = 0x00
+
+	badLength := bytes.Clone(valid)
+	binary.LittleEndian.PutUint16(badLength[4:6], 112)
+
+	badFunction := bytes.Clone(valid)
+	badFunction[7] = FUNCTION_READ
+
+	badCRC := bytes.Clone(valid)
+	badCRC[len(badCRC)-1] ^= 0xFF
+
+	holding := msg
+	holding.DeviceFunction = DEVICE_READHOLD
+
+	unknownRegister := msg
+	unknownRegister.Register = 200
+
+	shortBlock := msg
+	shortBlock.Values = msg.Values[:40]
+
+	badValueLength := bytes.Clone(valid)
+	badValueLength[HEADER_LENGTH+14]--
+	crc := CRC16(badValueLength[HEADER_LENGTH : len(badValueLength)-2])
+	binary.LittleEndian.PutUint16(badValueLength[len(badValueLength)-2:], crc)
+
+	tests := []struct {
+		name   string
+		frame  []byte
+		length int
+	}{
+		{"Empty", nil, 0},
+		{"ShortHeader", valid[:10], 10},
+		{"BadPrefix", badPrefix, len(badPrefix)},
+		{"BadPacketLength", badLength, len(badLength)},
+		{"LengthBeyondFrame", valid, len(valid) + 1},
+		{"Truncated", valid[:len(valid)-1], len(valid) - 1},
+		{"Heartbeat", EncodeHeartbeat([10]byte{}), 19},
+		{"HeaderFunction", badFunction, len(badFunction)},
+		{"BadCRC", badCRC, len(badCRC)},
+		{"BadValueLength", badValueLength, len(badValueLength)},
+		{"DeviceFunction", withMessage(valid, holding), len(valid)},
+		{"UnhandledRegister", withMessage(valid, unknownRegister), len(valid)},
+		{"UnhandledBlockLength", withMessage(valid, shortBlock), len(valid) - 40},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := LogData{}
+			if log.Decode(test.frame, uint16(test.length)) {
+				t.Error("Decode succeeded")
+			}
+		})
+	}
+}
+
+func TestScale(t *testing.T) {
+	log := LogData{}
+	log.Raw.Section1.Battery_Voltage = 532
+	log.Raw.Section1.Frequency_Grid = 4998
+	log.Raw.Section1.Grid_Power_Factor = -950
+	log.Raw.Section1.SOC = 87
+	log.Raw.Section2.PV1_Energy_Total = NewU32(123456)
+	log.Raw.Section3.Battery_Current = -1234
+	log.Raw.Section3.MaxCell_Voltage = 3345
+	log.Scale()
+
+	checks := []struct {
+		name     string
+		value    float32
+		expected float32
+	}{
+		{"Battery_Voltage", log.Section1.Battery_Voltage, 53.2},
+		{"Frequency_Grid", log.Section1.Frequency_Grid, 49.98},
+		{"Grid_Power_Factor", log.Section1.Grid_Power_Factor, -0.95},
+		{"SOC", log.Section1.SOC, 87},
+		{"PV1_Energy_Total", log.Section2.PV1_Energy_Total, 12345.6},
+		{"Battery_Current", log.Section3.Battery_Current, -12.34},
+		{"MaxCell_Voltage", log.Section3.MaxCell_Voltage, 3.345},
+	}
+	for _, check := range checks {
+		if check.value != check.expected {
+			t.Errorf("%s is %v, expected %v", check.name, check.value, check.expected)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, name := range goldenFrames(f) {
+		frame := readFrame(f, name)
+		f.Add(frame, uint16(len(frame)))
+	}
+	f.Add(EncodeHeartbeat([10]byte{}), uint16(19))
+	f.Add([]byte{0xA1, 0x1A}, uint16(2))
+
+	f.Fuzz(func(t *testing.T, frame []byte, length uint16) {
+		// Any input, valid or not, must not panic
+		log := LogData{}
+		if !log.Decode(frame, length) {
+			return
+		}
+		frame = frame[:length]
+
+		// Every accepted frame must survive a round trip unchanged
+		header, data, err := ParseFrame(frame)
+		if err != nil {
+			t.Fatal("ParseFrame failed on a decoded frame:", err)
+		}
+		msg, err := ParseMessage(data)
+		if err != nil {
+			t.Fatal("ParseMessage failed on a decoded frame:", err)
+		}
+		encoded := header.Encode(msg.Bytes())
+		if !bytes.Equal(encoded, frame) {
+			t.Errorf("re-encoded frame differs\n got % X\nwant % X", encoded, frame)
+		}
+
+		// The decoded data must match the frame it came from
+		if log.SerialNumber != string(header.SerialNumber[:]) || log.InverterSerial != string(msg.SerialNumber[:]) {
+			t.Errorf("serials %q and %q from a frame of %q and %q", log.SerialNumber, log.InverterSerial, header.SerialNumber, msg.SerialNumber)
+		}
+		values := msg.Registers()
+		loaded := false
+		for _, section := range log.sections() {
+			if !*section.loaded {
+				continue
+			}
+			loaded = true
+			offset := int(section.start) - int(msg.Register)
+			size := binary.Size(section.raw) / 2
+			if offset < 0 || offset+size > len(values) {
+				t.Fatalf("section at %d loaded from registers %d to %d", section.start, msg.Register, int(msg.Register)+len(values))
+			}
+			raw := bytes.Buffer{}
+			binary.Write(&raw, binary.LittleEndian, section.raw)
+			if !bytes.Equal(raw.Bytes(), EncodeRegisters(values[offset:offset+size])) {
+				t.Errorf("section at %d differs from the registers", section.start)
+			}
+		}
+		if !loaded {
+			t.Error("no section loaded")
+		}
+		if log.Derived.Loaded != log.Section1.Loaded {
+			t.Errorf("derived loaded %v with section 1 loaded %v", log.Derived.Loaded, log.Section1.Loaded)
+		}
+
+		// Scaling again changes nothing and the result can be published
+		scaled := log
+		scaled.Scale()
+		scaled.Derive()
+		if !reflect.DeepEqual(scaled, log) {
+			t.Error("scaling again changed the decoded data")
+		}
+		if _, err := json.Marshal(log); err != nil {
+			t.Error("decoded data does not marshal:", err)
+		}
+	})
+}
+
+func TestInputRegister(t *testing.T) {
+	tests := []struct {
+		field    string
+		register uint16
+	}{
+		{"Status", 0},
+		{"SOC", 5},
+		{"PV1_Power", 7},
+		{"Bus2_Voltage", 39},
+		{"PV1_Energy_Total", 40},
+		{"FaultCode", 60},
+		{"Runtime", 69},
+		{"BatteryComType", 80},
+		{"BMS_Inverter_Status", 95},
+		{"BatteryInverter_Voltage", 107},
+		{"Gen_Voltage", 121},
+		{"Gen_Energy_Total", 125},
+		{"EPS_L2_Energy_Total", 137},
+		{"AC_Couple_Power", 153},
+		{"Load_Power_L3", 156},
+	}
+
+	for _, test := range tests {
+		register, ok := InputRegister(test.field)
+		if !ok || register != test.register {
+			t.Errorf("%s is at register %d, expected %d", test.field, register, test.register)
+		}
+	}
+
+	if _, ok := InputRegister("Unknown"); ok {
+		t.Error("found register for unknown field")
+	}
+}
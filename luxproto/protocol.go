@@ -0,0 +1,65 @@
+// Package luxproto implements the frame protocol spoken by LuxPower Wi-Fi
+// dongles on TCP port 8000 and decodes the inverter registers they carry.
+package luxproto
+
+import (
+	"fmt"
+)
+
+// Frame prefix and the functions of the frame header
+const (
+	PREFIX             = 0x1AA1
+	FUNCTION_HEARTBEAT = 0xC1
+	FUNCTION_DATA      = 0xC2
+	FUNCTION_READ      = 0xC3
+	FUNCTION_WRITE     = 0xC4
+)
+
+// Modbus functions of the messages sent to and from the inverter
+const (
+	DEVICE_READHOLD    = 0x03
+	DEVICE_READINPUT   = 0x04
+	DEVICE_WRITESINGLE = 0x06
+	DEVICE_WRITEMULTI  = 0x10
+	DEVICE_EXCEPTION   = 0x80
+)
+
+// Header starts every frame. Its serial number is the one of the dongle.
+type Header struct {
+	Prefix          uint16   // 0..2
+	ProtocolVersion uint16   // 2..4
+	PacketLength    uint16   // 4..6
+	Address         uint8    // 6
+	Function        uint8    // 7
+	SerialNumber    [10]byte // 8..18
+	Reserved        uint16   // 18..20
+}
+
+func (head Header) String() string {
+	return fmt.Sprintf("Header Prefix: %04X\nHeader Protocol: %04X\nHeader PacketLength: %d\nHeader Address: %02X\nHeader Function: %02X\nHeader Serial: %s\nHeader Reserved: %d\n",
+		head.Prefix,
+		head.ProtocolVersion,
+		head.PacketLength,
+		head.Address,
+		head.Function,
+		head.SerialNumber,
+		head.Reserved)
+}
+
+// TranslatedData is the fixed start of a register read response. Message
+// handles every layout of the data section.
+type TranslatedData struct {
+	Address        uint8
+	DeviceFunction uint8
+	SerialNumber   [10]byte
+	Register       uint16
+	_              uint8
+}
+
+func (trans TranslatedData) String() string {
+	return fmt.Sprintf("Translated Address: %02X\nTranslated DeviceFunction: %02X\nTranslated Serial Number %s\nTranslated Register: %04X\n",
+		trans.Address,
+		trans.DeviceFunction,
+		trans.SerialNumber,
+		trans.Register)
+}
@@ -0,0 +1,112 @@
+package luxproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+)
+
+// Input register blocks as the dongle pushes them. Section 4 only exists on
+// newer firmware.
+const (
+	INPUT_SECTION1    = 0
+	INPUT_SECTION2    = 40
+	INPUT_SECTION3    = 80
+	INPUT_SECTION4    = 120
+	SECTION_REGISTERS = 40
+)
+
+// InputBlocks returns the first register of each block to read, with the
+// extended registers from 120 when extended is set.
+func InputBlocks(extended bool) []uint16 {
+	blocks := []uint16{INPUT_SECTION1, INPUT_SECTION2, INPUT_SECTION3}
+	if extended {
+		blocks = append(blocks, INPUT_SECTION4)
+	}
+	return blocks
+}
+
+type inputSection struct {
+	start  uint16
+	raw    interface{} // Pointer to the raw section
+	loaded *bool
+}
+
+// sections returns the raw sections of log with their first register.
+func (log *LogData) sections() []inputSection {
+	return []inputSection{
+		{INPUT_SECTION1, &log.Raw.Section1, &log.Section1.Loaded},
+		{INPUT_SECTION2, &log.Raw.Section2, &log.Section2.Loaded},
+		{INPUT_SECTION3, &log.Raw.Section3, &log.Section3.Loaded},
+		{INPUT_SECTION4, &log.Raw.Section4, &log.Section4.Loaded},
+	}
+}
+
+// Registers returns the input register values raw was decoded from,
+// starting at register 0.
+func (raw LogDataRaw) Registers() []uint16 {
+	buffer := bytes.Buffer{}
+	binary.Write(&buffer, binary.LittleEndian, raw)
+	registers := make([]uint16, buffer.Len()/2)
+	binary.Read(&buffer, binary.LittleEndian, registers)
+	return registers
+}
+
+// InputRegister returns the first input register holding a field of the
+// LogDataRaw sections, for example "Battery_Voltage" or "Runtime".
+func InputRegister(field string) (uint16, bool) {
+	for _, section := range (&LogData{}).sections() {
+		fields := reflect.TypeOf(section.raw).Elem()
+		offset := 0
+		for i := 0; i < fields.NumField(); i++ {
+			if fields.Field(i).Name == field {
+				return section.start + uint16(offset/2), true
+			}
+			// Go may pad struct fields, the wire format never does
+			offset += binary.Size(reflect.Zero(fields.Field(i).Type).Interface())
+		}
+	}
+
+	return 0, false
+}
+
+// Register types of the raw sections besides uint16 and int16. Each field of
+// a raw section is one of them, the wire format is the same little-endian
+// words either way.
+
+// U32 is a 32-bit value over two registers, the low word first.
+type U32 struct {
+	Low  uint16
+	High uint16
+}
+
+func NewU32(value uint32) U32 {
+	return U32{uint16(value), uint16(value >> 16)}
+}
+
+func (value U32) Uint32() uint32 {
+	return uint32(value.High)<<16 | uint32(value.Low)
+}
+
+func (value U32) MarshalJSON() ([]byte, error) {
+	return json.Marshal(value.Uint32())
+}
+
+// U8Pair is two byte-sized values sharing one register, Low in the low byte.
+type U8Pair struct {
+	Low  uint8
+	High uint8
+}
+
+func NewU8Pair(value uint16) U8Pair {
+	return U8Pair{uint8(value), uint8(value >> 8)}
+}
+
+func (value U8Pair) Uint16() uint16 {
+	return uint16(value.High)<<8 | uint16(value.Low)
+}
+
+func (value U8Pair) MarshalJSON() ([]byte, error) {
+	return json.Marshal(value.Uint16())
+}
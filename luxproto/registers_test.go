@@ -0,0 +1,95 @@
+package luxproto
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestRegisterTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    string
+		offset   uint16 // Register within the field
+		value    uint16
+		decoded  func(log LogData) float64
+		expected float64
+	}{
+		{"U16Top", "Power_To_Grid", 0, 0xFFFF, func(log LogData) float64 { return float64(log.Section1.Power_To_Grid) }, 65535},
+		{"U16Sign", "Power_To_Grid", 0, 0x8000, func(log LogData) float64 { return float64(log.Section1.Power_To_Grid) }, 32768},
+		{"S16Negative", "Grid_Power_Factor", 0, 0xFC4A, func(log LogData) float64 { return float64(log.Raw.Section1.Grid_Power_Factor) }, -950},
+		{"S16Bottom", "Inner_Temperature", 0, 0x8000, func(log LogData) float64 { return float64(log.Section2.Inner_Temperature) }, -32768},
+		{"U8PairLow", "SOC", 0, 0x64FF, func(log LogData) float64 { return float64(log.Section1.SOC) }, 255},
+		{"U8PairHigh", "SOC", 0, 0x64FF, func(log LogData) float64 { return float64(log.Section1.SOH) }, 100},
+		{"U32Low", "PV1_Energy_Total", 0, 0xFFFF, func(log LogData) float64 { return float64(log.Raw.Section2.PV1_Energy_Total.Uint32()) }, 65535},
+		{"U32High", "PV1_Energy_Total", 1, 0x0001, func(log LogData) float64 { return float64(log.Raw.Section2.PV1_Energy_Total.Uint32()) }, 65536},
+		{"U32Sign", "FaultCode", 1, 0x8000, func(log LogData) float64 { return float64(log.Section2.FaultCode) }, 1 << 31},
+		{"Capacity", "Battery_Capacity", 0, 0x9C40, func(log LogData) float64 { return float64(log.Section3.Battery_Capacity) }, 40000},
+		{"CellVoltage", "MaxCell_Voltage", 0, 0x8235, func(log LogData) float64 { return float64(log.Raw.Section3.MaxCell_Voltage) }, 33333},
+		{"BatteryCurrent", "Battery_Current", 0, 0xFFFF, func(log LogData) float64 { return float64(log.Raw.Section3.Battery_Current) }, -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			register, ok := InputRegister(test.field)
+			if !ok {
+				t.Fatal("no register for", test.field)
+			}
+			register += test.offset
+			values := make([]uint16, 160)
+			values[register] = test.value
+
+			log := LogData{}
+			if !log.DecodeRegisters(0, values[:120]) {
+				t.Fatal("DecodeRegisters failed")
+			}
+			if decoded := test.decoded(log); decoded != test.expected {
+				t.Errorf("register %d = %04X decoded as %v, expected %v", register, test.value, decoded, test.expected)
+			}
+		})
+	}
+}
+
+func TestRegisterTypesRoundTrip(t *testing.T) {
+	for _, value := range []uint32{0, 0xFFFF, 0x10000, 0x7FFFFFFF, 0x80000000, 0xFFFFFFFF} {
+		if NewU32(value).Uint32() != value {
+			t.Errorf("U32 %08X round trips to %08X", value, NewU32(value).Uint32())
+		}
+	}
+	for _, value := range []uint16{0, 0x00FF, 0xFF00, 0xFFFF} {
+		if NewU8Pair(value).Uint16() != value {
+			t.Errorf("U8Pair %04X round trips to %04X", value, NewU8Pair(value).Uint16())
+		}
+	}
+}
+
+// TestRawSectionLayout makes sure the raw sections only use the register
+// types and fill their 40 registers exactly.
+func TestRawSectionLayout(t *testing.T) {
+	allowed := map[reflect.Type]bool{
+		reflect.TypeOf(uint16(0)): true,
+		reflect.TypeOf(int16(0)):  true,
+		reflect.TypeOf(U32{}):     true,
+		reflect.TypeOf(U8Pair{}):  true,
+	}
+
+	for _, section := range (&LogData{}).sections() {
+		fields := reflect.TypeOf(section.raw).Elem()
+		if size := binary.Size(section.raw); size != 2*SECTION_REGISTERS {
+			t.Errorf("%s is %d bytes", fields.Name(), size)
+		}
+
+		for i := 0; i < fields.NumField(); i++ {
+			field := fields.Field(i)
+			switch {
+			case field.Name == "_" || allowed[field.Type]:
+			case field.Type.Kind() == reflect.Array && allowed[field.Type.Elem()]:
+			case field.Type.Kind() == reflect.Uint8 && i+1 < fields.NumField() && fields.Field(i+1).Type.Kind() == reflect.Uint8:
+				// Two bytes of one register, the low one first
+				i++
+			default:
+				t.Errorf("%s.%s has no register type: %s", fields.Name(), field.Name, field.Type)
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package luxproto
+
+import "fmt"
+
+// Enum is a register value together with its meaning.
+type Enum struct {
+	Code  uint16
+	Label string
+}
+
+// Inverter states in input register 0. The bits combine the power sources:
+// 0x04 PV, 0x08 charging from PV, 0x10 battery, 0x20 charging from AC and
+// 0x40 and 0x80 for battery and PV while off-grid.
+const (
+	STATUS_STANDBY             = 0x00
+	STATUS_FAULT               = 0x01
+	STATUS_PROGRAMMING         = 0x02
+	STATUS_PV_ON_GRID          = 0x04
+	STATUS_PV_CHARGE           = 0x08
+	STATUS_PV_CHARGE_ON_GRID   = 0x0C
+	STATUS_BATTERY_ON_GRID     = 0x10
+	STATUS_BYPASS              = 0x11
+	STATUS_PV_BATTERY_ON_GRID  = 0x14
+	STATUS_AC_CHARGE           = 0x20
+	STATUS_PV_AC_CHARGE        = 0x28
+	STATUS_BATTERY_OFF_GRID    = 0x40
+	STATUS_PV_OFF_GRID         = 0x80
+	STATUS_PV_CHARGE_OFF_GRID  = 0x88
+	STATUS_PV_BATTERY_OFF_GRID = 0xC0
+)
+
+var statusLabels = map[uint16]string{
+	STATUS_STANDBY:             "Standby",
+	STATUS_FAULT:               "Fault",
+	STATUS_PROGRAMMING:         "Firmware update",
+	STATUS_PV_ON_GRID:          "Grid-tied, PV supplying load",
+	STATUS_PV_CHARGE:           "Charging from PV",
+	STATUS_PV_CHARGE_ON_GRID:   "Grid-tied, charging from PV",
+	STATUS_BATTERY_ON_GRID:     "Grid-tied, discharging battery",
+	STATUS_BYPASS:              "Bypass",
+	STATUS_PV_BATTERY_ON_GRID:  "Grid-tied, PV and battery supplying load",
+	STATUS_AC_CHARGE:           "Grid-tied, charging from AC",
+	STATUS_PV_AC_CHARGE:        "Grid-tied, charging from PV and AC",
+	STATUS_BATTERY_OFF_GRID:    "EPS, discharging battery",
+	STATUS_PV_OFF_GRID:         "EPS, PV supplying load",
+	STATUS_PV_CHARGE_OFF_GRID:  "EPS, charging from PV",
+	STATUS_PV_BATTERY_OFF_GRID: "EPS, PV and battery supplying load",
+}
+
+// InverterStatus returns the meaning of input register 0.
+func InverterStatus(code uint16) Enum {
+	label, ok := statusLabels[code]
+	if !ok {
+		label = fmt.Sprintf("Unknown status 0x%02X", code)
+	}
+	return Enum{code, label}
+}
+
+// OffGrid reports whether the inverter runs on its EPS output.
+func (status Enum) OffGrid() bool {
+	return status.Code&(STATUS_BATTERY_OFF_GRID|STATUS_PV_OFF_GRID) != 0
+}
+
+// Communication protocols between inverter and battery, in the high byte of
+// input register 80
+const (
+	BATTERY_PROTOCOL_CAN   = 0
+	BATTERY_PROTOCOL_RS485 = 1
+)
+
+var batteryProtocolLabels = map[uint16]string{
+	BATTERY_PROTOCOL_CAN:   "CAN",
+	BATTERY_PROTOCOL_RS485: "RS485",
+}
+
+// Battery brands of the lithium brand setting, in the low byte of input
+// register 80. 0 is no brand set.
+const (
+	BATTERY_BRAND_NONE      = 0
+	BATTERY_BRAND_PYLONTECH = 1
+	BATTERY_BRAND_DYNESS    = 2
+	BATTERY_BRAND_SOLUNA    = 3
+	BATTERY_BRAND_ALPHA_ESS = 4
+	BATTERY_BRAND_HUAWEI    = 5
+)
+
+var batteryBrandLabels = map[uint16]string{
+	BATTERY_BRAND_NONE:      "",
+	BATTERY_BRAND_PYLONTECH: "Pylontech",
+	BATTERY_BRAND_DYNESS:    "Dyness",
+	BATTERY_BRAND_SOLUNA:    "Soluna",
+	BATTERY_BRAND_ALPHA_ESS: "Alpha ESS",
+	BATTERY_BRAND_HUAWEI:    "Huawei",
+}
+
+// BatteryType splits input register 80 into the battery brand setting in the
+// low byte and the protocol in the high byte. Inverters without a battery
+// report 0, which has empty labels.
+func BatteryType(code uint16) (brand Enum, protocol Enum) {
+	if code == 0 {
+		return Enum{}, Enum{}
+	}
+
+	brand.Code = code & 0xFF
+	label, ok := batteryBrandLabels[brand.Code]
+	if !ok {
+		label = fmt.Sprintf("Unknown brand %d", brand.Code)
+	}
+	brand.Label = label
+
+	protocol.Code = code >> 8
+	label, ok = batteryProtocolLabels[protocol.Code]
+	if !ok {
+		label = fmt.Sprintf("Unknown protocol %d", protocol.Code)
+	}
+	protocol.Label = label
+	return brand, protocol
+}
+
+// Bits of the first BMS status register, the charge request flags of the
+// Pylontech CAN protocol most batteries use
+var bmsRequestLabels = map[int]string{
+	3: "Full charge requested",
+	4: "Force charge II requested",
+	5: "Force charge I requested",
+	6: "Discharge enabled",
+	7: "Charge enabled",
+}
+
+// Bits of BMS_Event1, the protection flags of the Pylontech CAN protocol
+var bmsProtectionLabels = map[int]string{
+	1:  "Cell over voltage",
+	2:  "Cell under voltage",
+	3:  "Cell over temperature",
+	4:  "Cell under temperature",
+	7:  "Discharge over current",
+	8:  "Charge over current",
+	11: "System error",
+}
+
+// Bits of BMS_Event2, the warning flags of the Pylontech CAN protocol
+var bmsWarningLabels = map[int]string{
+	1:  "Cell voltage high",
+	2:  "Cell voltage low",
+	3:  "Cell temperature high",
+	4:  "Cell temperature low",
+	7:  "Discharge current high",
+	8:  "Charge current high",
+	11: "Internal communication failure",
+}
+
+// BMSRequests returns the flags set in the first BMS status register.
+func BMSRequests(word uint16) []string {
+	return flags(word, bmsRequestLabels)
+}
+
+// BMSProtections returns the protections active in BMS_Event1.
+func BMSProtections(word uint16) []string {
+	return flags(word, bmsProtectionLabels)
+}
+
+// BMSWarnings returns the warnings active in BMS_Event2.
+func BMSWarnings(word uint16) []string {
+	return flags(word, bmsWarningLabels)
+}
+
+func flags(word uint16, labels map[int]string) []string {
+	active := []string{}
+	for bit := 0; bit < 16; bit++ {
+		if word&(1<<bit) == 0 {
+			continue
+		}
+		label, ok := labels[bit]
+		if !ok {
+			label = fmt.Sprintf("Bit %d", bit)
+		}
+		active = append(active, label)
+	}
+	return active
+}
@@ -0,0 +1,65 @@
+package luxproto
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInverterStatus(t *testing.T) {
+	tests := []struct {
+		code    uint16
+		label   string
+		offGrid bool
+	}{
+		{0x00, "Standby", false},
+		{0x0C, "Grid-tied, charging from PV", false},
+		{0x14, "Grid-tied, PV and battery supplying load", false},
+		{0x40, "EPS, discharging battery", true},
+		{0xC0, "EPS, PV and battery supplying load", true},
+		{0x33, "Unknown status 0x33", false},
+	}
+
+	for _, test := range tests {
+		status := InverterStatus(test.code)
+		if status.Code != test.code || status.Label != test.label || status.OffGrid() != test.offGrid {
+			t.Errorf("status %02X decoded as %+v off-grid %v", test.code, status, status.OffGrid())
+		}
+	}
+}
+
+func TestBatteryType(t *testing.T) {
+	tests := []struct {
+		code     uint16
+		brand    Enum
+		protocol Enum
+	}{
+		{0x0000, Enum{}, Enum{}},
+		{0x0001, Enum{BATTERY_BRAND_PYLONTECH, "Pylontech"}, Enum{BATTERY_PROTOCOL_CAN, "CAN"}},
+		{0x0102, Enum{BATTERY_BRAND_DYNESS, "Dyness"}, Enum{BATTERY_PROTOCOL_RS485, "RS485"}},
+		{0x0100, Enum{BATTERY_BRAND_NONE, ""}, Enum{BATTERY_PROTOCOL_RS485, "RS485"}},
+		{0x0106, Enum{6, "Unknown brand 6"}, Enum{BATTERY_PROTOCOL_RS485, "RS485"}},
+		{0x0501, Enum{BATTERY_BRAND_PYLONTECH, "Pylontech"}, Enum{5, "Unknown protocol 5"}},
+	}
+
+	for _, test := range tests {
+		brand, protocol := BatteryType(test.code)
+		if brand != test.brand || protocol != test.protocol {
+			t.Errorf("%04X decoded as brand %+v protocol %+v", test.code, brand, protocol)
+		}
+	}
+}
+
+func TestBMSFlags(t *testing.T) {
+	if flags := BMSRequests(0xC0); !reflect.DeepEqual(flags, []string{"Discharge enabled", "Charge enabled"}) {
+		t.Errorf("requests %v", flags)
+	}
+	if flags := BMSProtections(1<<2 | 1<<8); !reflect.DeepEqual(flags, []string{"Cell under voltage", "Charge over current"}) {
+		t.Errorf("protections %v", flags)
+	}
+	if flags := BMSWarnings(1 << 15); !reflect.DeepEqual(flags, []string{"Bit 15"}) {
+		t.Errorf("warnings %v", flags)
+	}
+	if flags := BMSWarnings(0); flags == nil || len(flags) != 0 {
+		t.Error("no warnings should be an empty list")
+	}
+}
@@ -0,0 +1,228 @@
+// Package luxproxy shares one dongle connection between several clients, such
+// as LuxLogger and the LuxPower app. Frames from the dongle are copied to
+// every client and their requests are passed on one at a time.
+//
+// Every client has its own queue of frames from the dongle, so a slow client
+// loses frames instead of holding up the others.
+package luxproxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"LuxLogger/luxclient"
+	"LuxLogger/luxproto"
+)
+
+const (
+	DEFAULT_RECONNECT_DELAY = 10 * time.Second
+	// CLIENT_WRITE_TIMEOUT is how long a client may take to accept a frame
+	// before it is disconnected
+	CLIENT_WRITE_TIMEOUT = 5 * time.Second
+	// CLIENT_QUEUE_LENGTH is how many frames from the dongle may wait for a
+	// client, more are dropped
+	CLIENT_QUEUE_LENGTH = 32
+)
+
+// UPSTREAM is the source passed to Frame for frames from the dongle.
+const UPSTREAM = "dongle"
+
+type Proxy struct {
+	// Upstream is the address of the dongle
+	Upstream string
+	// ReconnectDelay is the time between attempts to reach the dongle
+	ReconnectDelay time.Duration
+
+	// Frame is called with every frame the proxy sees. Source is UPSTREAM for
+	// frames from the dongle and the client address for the others.
+	Frame func(source string, frame []byte)
+
+	lock     sync.Mutex
+	upstream *luxclient.Client
+	clients  map[*downstream]struct{}
+	// requests lets one client request through to the dongle at a time
+	requests sync.Mutex
+}
+
+// downstream is a connected client with the frames from the dongle waiting
+// to be written to it.
+type downstream struct {
+	client *luxclient.Client
+	source string
+	queue  chan []byte
+}
+
+func NewProxy(upstream string) *Proxy {
+	return &Proxy{
+		Upstream:       upstream,
+		ReconnectDelay: DEFAULT_RECONNECT_DELAY,
+		clients:        make(map[*downstream]struct{}),
+	}
+}
+
+func (proxy *Proxy) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return proxy.Serve(ctx, listener)
+}
+
+// Serve keeps the connection to the dongle and accepts clients on listener
+// until ctx is done.
+func (proxy *Proxy) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go proxy.connect(ctx)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		go proxy.handle(ctx, conn)
+	}
+}
+
+// connect holds the upstream connection, reconnecting after failures.
+func (proxy *Proxy) connect(ctx context.Context) {
+	for {
+		upstream, err := luxclient.Dial(ctx, proxy.Upstream)
+		if err == nil {
+			upstream.Unsolicited = proxy.broadcast
+			// The clients repeat their own requests
+			upstream.Retries = 0
+			proxy.setUpstream(upstream)
+			err = upstream.Run(ctx)
+			proxy.setUpstream(nil)
+			upstream.Close()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		println("Upstream", proxy.Upstream, "failed:", err.Error())
+
+		select {
+		case <-time.After(proxy.ReconnectDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (proxy *Proxy) setUpstream(upstream *luxclient.Client) {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	proxy.upstream = upstream
+}
+
+func (proxy *Proxy) currentUpstream() *luxclient.Client {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	return proxy.upstream
+}
+
+// broadcast queues a frame from the dongle for every client, dropping it for
+// clients whose queue is full.
+func (proxy *Proxy) broadcast(frame []byte) {
+	proxy.frame(UPSTREAM, frame)
+
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+	for downstream := range proxy.clients {
+		select {
+		case downstream.queue <- frame:
+		default:
+			println("Dropped frame for", downstream.source+": client too slow")
+		}
+	}
+}
+
+// send writes the frames queued for downstream until its queue is closed. A
+// failed write disconnects the client.
+func (proxy *Proxy) send(downstream *downstream) {
+	for frame := range downstream.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), CLIENT_WRITE_TIMEOUT)
+		if err := downstream.client.WriteFrame(ctx, frame); err != nil {
+			downstream.client.Close()
+		}
+		cancel()
+	}
+}
+
+func (proxy *Proxy) handle(ctx context.Context, conn net.Conn) {
+	client := luxclient.New(conn)
+	source := conn.RemoteAddr().String()
+	println("Client connected:", source)
+
+	downstream := &downstream{client: client, source: source, queue: make(chan []byte, CLIENT_QUEUE_LENGTH)}
+	proxy.lock.Lock()
+	proxy.clients[downstream] = struct{}{}
+	proxy.lock.Unlock()
+	go proxy.send(downstream)
+
+	defer func() {
+		proxy.lock.Lock()
+		delete(proxy.clients, downstream)
+		close(downstream.queue)
+		proxy.lock.Unlock()
+		client.Close()
+	}()
+
+	for {
+		frame, err := client.ReadFrame(ctx)
+		if err != nil {
+			println("Client disconnected:", source, err.Error())
+			return
+		}
+		proxy.frame(source, frame)
+		proxy.forward(ctx, client, source, frame)
+	}
+}
+
+// forward passes a frame from client to the dongle. Register requests are
+// sent once and wait for their response, which only goes back to client.
+func (proxy *Proxy) forward(ctx context.Context, client *luxclient.Client, source string, frame []byte) {
+	upstream := proxy.currentUpstream()
+	if upstream == nil {
+		println("Dropped frame from", source+": dongle not connected")
+		return
+	}
+
+	proxy.requests.Lock()
+	defer proxy.requests.Unlock()
+
+	header, data, err := luxproto.ParseFrame(frame)
+	if err != nil || header.Function != luxproto.FUNCTION_DATA {
+		upstream.WriteFrame(ctx, frame)
+		return
+	}
+	request, err := luxproto.ParseMessage(data)
+	if err != nil || request.Address != luxproto.ADDRESS_REQUEST {
+		upstream.WriteFrame(ctx, frame)
+		return
+	}
+
+	response, err := upstream.Request(ctx, request)
+	if err != nil && err != luxclient.ErrException {
+		println("Request from", source, "failed:", err.Error())
+		return
+	}
+
+	answer := luxproto.EncodeFrame(luxproto.FUNCTION_DATA, header.SerialNumber, response.Bytes())
+	proxy.frame(UPSTREAM, answer)
+	client.WriteFrame(ctx, answer)
+}
+
+func (proxy *Proxy) frame(source string, frame []byte) {
+	if proxy.Frame != nil {
+		proxy.Frame(source, frame)
+	}
+}
@@ -0,0 +1,152 @@
+package luxproxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"LuxLogger/luxclient"
+	"LuxLogger/luxproto"
+	"LuxLogger/luxsim"
+)
+
+func TestProxySharesDongle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sim := luxsim.NewSimulator("BA00000001", "0000000001")
+	sim.Interval = 20 * time.Millisecond
+	dongle, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dongle.Close()
+	go sim.Serve(dongle)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewProxy(dongle.Addr().String())
+	seen := map[string]int{}
+	lock := sync.Mutex{}
+	proxy.Frame = func(source string, frame []byte) {
+		lock.Lock()
+		seen[source]++
+		lock.Unlock()
+	}
+	go proxy.Serve(ctx, listener)
+
+	// Both clients see the pushed data, only the asking one sees its answer
+	holding := make([]chan []byte, 2)
+	clients := make([]*luxclient.Client, 2)
+	for i := range clients {
+		client, err := luxclient.Dial(ctx, listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		pushed := make(chan struct{})
+		holding[i] = make(chan []byte, 10)
+		var once sync.Once
+		answers := holding[i]
+		client.Unsolicited = func(frame []byte) {
+			_, data, _ := luxproto.ParseFrame(frame)
+			msg, err := luxproto.ParseMessage(data)
+			if err != nil {
+				return
+			}
+			switch msg.DeviceFunction {
+			case luxproto.DEVICE_READINPUT:
+				once.Do(func() { close(pushed) })
+			case luxproto.DEVICE_READHOLD:
+				answers <- frame
+			}
+		}
+		go client.Run(ctx)
+		clients[i] = client
+
+		select {
+		case <-pushed:
+		case <-ctx.Done():
+			t.Fatalf("client %d got no pushed data", i)
+		}
+	}
+
+	if err := clients[0].WriteSingle(ctx, sim.InverterSerial, 21, 0x55); err != nil {
+		t.Fatal(err)
+	}
+	values, err := clients[0].ReadHold(ctx, sim.InverterSerial, 21, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != 0x55 || sim.Holding(21) != 0x55 {
+		t.Errorf("read back %v", values)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if len(holding[1]) != 0 {
+		t.Error("the answer to a request was copied to another client")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	// Only the first client sent anything
+	if seen[UPSTREAM] == 0 || len(seen) != 2 {
+		t.Errorf("proxy saw frames from %v", seen)
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	proxy := NewProxy("")
+
+	// One client never reads, the other one does
+	stalled, stalledEnd := net.Pipe()
+	defer stalledEnd.Close()
+	reading, readingEnd := net.Pipe()
+	defer readingEnd.Close()
+	go proxy.handle(ctx, stalled)
+	go proxy.handle(ctx, reading)
+	for connected := 0; connected < 2; {
+		time.Sleep(time.Millisecond)
+		proxy.lock.Lock()
+		connected = len(proxy.clients)
+		proxy.lock.Unlock()
+	}
+
+	received := make(chan []byte, 1)
+	go func() {
+		frame, err := luxclient.New(readingEnd).ReadFrame(ctx)
+		if err == nil {
+			received <- frame
+		}
+	}()
+
+	frame := luxproto.EncodeFrame(luxproto.FUNCTION_HEARTBEAT, [10]byte{'B', 'A'}, []byte{0})
+	broadcast := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*CLIENT_QUEUE_LENGTH; i++ {
+			proxy.broadcast(frame)
+		}
+		close(broadcast)
+	}()
+
+	select {
+	case <-broadcast:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast waited for the stalled client")
+	}
+	select {
+	case got := <-received:
+		if string(got) != string(frame) {
+			t.Errorf("received % X", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("reading client got no frame")
+	}
+}
@@ -0,0 +1,290 @@
+// Package luxschedule keeps the AC charge and forced discharge schedule of
+// the inverters in line with the one in the config. It reads the schedule
+// registers, writes the ones that differ and flags settings changed behind
+// its back, in the app for example.
+package luxschedule
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtime"
+)
+
+// Window is a time of day range, End before Start spans midnight.
+type Window struct {
+	Start string // Like "23:30"
+	End   string
+}
+
+// Mode is one of the schedules of the inverter. It is switched off when it
+// has no windows.
+type Mode struct {
+	Power   uint16 // Percent of the rated power
+	SOC     uint16 // Target SOC
+	Windows []Window
+}
+
+// Profile is the schedule for some days of the week in some part of the
+// year.
+type Profile struct {
+	Name string
+	// Days limits the profile to "weekday", "weekend" or days like "Mon",
+	// all days when empty
+	Days []string
+	// From and To like "04-01" limit the profile to a season, which may span
+	// the new year
+	From string
+	To   string
+
+	AC_Charge        Mode
+	Forced_Discharge Mode
+
+	days     [7]bool
+	from, to int // Month*100 + day
+}
+
+// Inverter reads and writes holding registers, like luxclient.Client.
+type Inverter interface {
+	ReadHold(ctx context.Context, inverter [10]byte, register uint16, count uint16) ([]uint16, error)
+	WriteSingle(ctx context.Context, inverter [10]byte, register uint16, value uint16) error
+	Modify(ctx context.Context, inverter [10]byte, register uint16, mask uint16, value uint16) (uint16, error)
+}
+
+// Change is a register the scheduler wrote.
+type Change struct {
+	Profile  string
+	Register uint16
+	Name     string
+	Had      uint16
+	Wrote    uint16
+	// Outside is set when the register was changed since the scheduler
+	// last set it
+	Outside bool
+}
+
+func (change Change) String() string {
+	text := fmt.Sprintf("%s (register %d) from %d to %d for %s", change.Name, change.Register, change.Had, change.Wrote, change.Profile)
+	if change.Outside {
+		text += ", it was changed outside LuxLogger"
+	}
+	return text
+}
+
+// setting is the part of a register selected by mask that should be value.
+type setting struct {
+	register uint16
+	name     string
+	mask     uint16
+	value    uint16
+}
+
+type Scheduler struct {
+	profiles []Profile
+	location *time.Location
+
+	lock    sync.Mutex
+	desired map[[10]byte]map[uint16]uint16
+}
+
+// NewScheduler checks profiles and picks them by the time in location. The
+// first profile matching the day wins.
+func NewScheduler(profiles []Profile, location *time.Location) (*Scheduler, error) {
+	if location == nil {
+		location = time.Local
+	}
+	for i := range profiles {
+		if err := profiles[i].parse(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profiles[i].Name, err)
+		}
+	}
+	return &Scheduler{profiles: profiles, location: location, desired: make(map[[10]byte]map[uint16]uint16)}, nil
+}
+
+func (profile *Profile) parse() error {
+	var err error
+	if profile.days, err = luxtime.Days(profile.Days); err != nil {
+		return err
+	}
+
+	if (profile.From == "") != (profile.To == "") {
+		return fmt.Errorf("season needs both From and To")
+	}
+	if profile.From != "" {
+		if profile.from, err = dayOfYear(profile.From); err != nil {
+			return err
+		}
+		if profile.to, err = dayOfYear(profile.To); err != nil {
+			return err
+		}
+	}
+
+	for _, mode := range []Mode{profile.AC_Charge, profile.Forced_Discharge} {
+		if mode.Power > 100 || mode.SOC > 100 {
+			return fmt.Errorf("power %d%% and SOC %d%% must be percentages", mode.Power, mode.SOC)
+		}
+		if len(mode.Windows) > luxproto.HOLD_WINDOWS {
+			return fmt.Errorf("%d windows, the inverter has %d", len(mode.Windows), luxproto.HOLD_WINDOWS)
+		}
+		for _, window := range mode.Windows {
+			if _, err := luxtime.Minutes(window.Start); err != nil {
+				return err
+			}
+			if _, err := luxtime.Minutes(window.End); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dayOfYear parses a date like "04-01" into month*100 + day.
+func dayOfYear(text string) (int, error) {
+	parsed, err := time.Parse("01-02", text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid date %q", text)
+	}
+	return int(parsed.Month())*100 + parsed.Day(), nil
+}
+
+func (profile *Profile) matches(at time.Time) bool {
+	if !profile.days[at.Weekday()] {
+		return false
+	}
+	if profile.from == 0 {
+		return true
+	}
+	today := int(at.Month())*100 + at.Day()
+	if profile.from <= profile.to {
+		return profile.from <= today && today <= profile.to
+	}
+	return today >= profile.from || today <= profile.to
+}
+
+// Active returns the profile for the day of at, nil if none matches.
+func (scheduler *Scheduler) Active(at time.Time) *Profile {
+	local := at.In(scheduler.location)
+	for i := range scheduler.profiles {
+		if scheduler.profiles[i].matches(local) {
+			return &scheduler.profiles[i]
+		}
+	}
+	return nil
+}
+
+// settings returns the register values of profile.
+func (profile *Profile) settings() []setting {
+	enable := uint16(0)
+	if len(profile.AC_Charge.Windows) > 0 {
+		enable |= luxproto.ENABLE_AC_CHARGE
+	}
+	if len(profile.Forced_Discharge.Windows) > 0 {
+		enable |= luxproto.ENABLE_FORCED_DISCHARGE
+	}
+	settings := []setting{{luxproto.HOLD_FUNCTIONS, "Schedule enable", luxproto.ENABLE_AC_CHARGE | luxproto.ENABLE_FORCED_DISCHARGE, enable}}
+	settings = append(settings, profile.AC_Charge.settings("AC charge", luxproto.HOLD_AC_CHARGE_POWER, luxproto.HOLD_AC_CHARGE_SOC, luxproto.HOLD_AC_CHARGE_TIMES)...)
+	settings = append(settings, profile.Forced_Discharge.settings("Forced discharge", luxproto.HOLD_FORCED_DISCHARGE_POWER, luxproto.HOLD_FORCED_DISCHARGE_SOC, luxproto.HOLD_FORCED_DISCHARGE_TIMES)...)
+	return settings
+}
+
+func (mode Mode) settings(name string, power uint16, soc uint16, times uint16) []setting {
+	// A switched off mode keeps the power and SOC it has
+	settings := []setting{}
+	if len(mode.Windows) > 0 {
+		settings = append(settings,
+			setting{power, name + " power", 0xFFFF, mode.Power},
+			setting{soc, name + " SOC", 0xFFFF, mode.SOC})
+	}
+	for i := 0; i < luxproto.HOLD_WINDOWS; i++ {
+		start, end := uint16(0), uint16(0)
+		if i < len(mode.Windows) {
+			start = holdTime(mode.Windows[i].Start)
+			end = holdTime(mode.Windows[i].End)
+		}
+		register := times + uint16(2*i)
+		settings = append(settings,
+			setting{register, fmt.Sprintf("%s start %d", name, i+1), 0xFFFF, start},
+			setting{register + 1, fmt.Sprintf("%s end %d", name, i+1), 0xFFFF, end})
+	}
+	return settings
+}
+
+// holdTime encodes a time of day checked by parse.
+func holdTime(text string) uint16 {
+	parsed, _ := time.Parse("15:04", text)
+	return luxproto.HoldTime(parsed.Hour(), parsed.Minute())
+}
+
+// Apply brings the schedule registers of serial in line with the profile
+// active at now. It returns the registers it wrote, also when a write fails.
+func (scheduler *Scheduler) Apply(ctx context.Context, inverter Inverter, serial [10]byte, now time.Time) ([]Change, error) {
+	profile := scheduler.Active(now)
+	if profile == nil {
+		return nil, nil
+	}
+	settings := profile.settings()
+
+	current := map[uint16]uint16{}
+	blocks := []struct{ register, count uint16 }{
+		{luxproto.HOLD_FUNCTIONS, 1},
+		{luxproto.HOLD_AC_CHARGE_POWER, luxproto.HOLD_FORCED_DISCHARGE_TIMES + 2*luxproto.HOLD_WINDOWS - luxproto.HOLD_AC_CHARGE_POWER},
+	}
+	for _, block := range blocks {
+		values, err := inverter.ReadHold(ctx, serial, block.register, block.count)
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			current[block.register+uint16(i)] = value
+		}
+	}
+
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	desired, ok := scheduler.desired[serial]
+	if !ok {
+		desired = make(map[uint16]uint16)
+		scheduler.desired[serial] = desired
+	}
+
+	sort.SliceStable(settings, func(i, j int) bool { return settings[i].register < settings[j].register })
+	changes := []Change{}
+	for _, setting := range settings {
+		have := current[setting.register]
+		want := have&^setting.mask | setting.value
+		if have == want {
+			desired[setting.register] = want
+			continue
+		}
+
+		// Set to the same before and no longer there: someone else
+		// changed it
+		before, known := desired[setting.register]
+		outside := known && before&setting.mask == want&setting.mask
+		if setting.mask == 0xFFFF {
+			if err := inverter.WriteSingle(ctx, serial, setting.register, want); err != nil {
+				return changes, err
+			}
+		} else {
+			// Bits of a register others write too, like the export
+			// limit enable in HOLD_FUNCTIONS: change only ours
+			had, err := inverter.Modify(ctx, serial, setting.register, setting.mask, setting.value)
+			if err != nil {
+				return changes, err
+			}
+			have, want = had, had&^setting.mask|setting.value
+			current[setting.register] = want
+			if have == want {
+				desired[setting.register] = want
+				continue
+			}
+		}
+		desired[setting.register] = want
+		changes = append(changes, Change{profile.Name, setting.register, setting.name, have, want, outside})
+	}
+	return changes, nil
+}
@@ -0,0 +1,172 @@
+package luxschedule
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"LuxLogger/luxclient"
+	"LuxLogger/luxproto"
+	"LuxLogger/luxsim"
+)
+
+func profiles() []Profile {
+	return []Profile{
+		{
+			Name: "Winter weekday",
+			Days: []string{"weekday"},
+			From: "05-01",
+			To:   "08-31",
+			AC_Charge: Mode{Power: 50, SOC: 90, Windows: []Window{
+				{Start: "23:30", End: "05:00"},
+			}},
+			Forced_Discharge: Mode{Power: 80, SOC: 30, Windows: []Window{
+				{Start: "17:00", End: "19:30"},
+			}},
+		},
+		{
+			Name: "Summer",
+			From: "11-01",
+			To:   "02-28",
+		},
+		{
+			Name:      "Default",
+			AC_Charge: Mode{Power: 30, SOC: 60, Windows: []Window{{Start: "01:00", End: "04:00"}}},
+		},
+	}
+}
+
+func TestActive(t *testing.T) {
+	scheduler, err := NewScheduler(profiles(), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at      time.Time
+		profile string
+	}{
+		{time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC), "Winter weekday"},
+		{time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), "Default"}, // Saturday
+		{time.Date(2024, 12, 25, 12, 0, 0, 0, time.UTC), "Summer"},
+		{time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC), "Summer"},
+		{time.Date(2024, 9, 2, 12, 0, 0, 0, time.UTC), "Default"},
+	}
+	for _, test := range tests {
+		if profile := scheduler.Active(test.at); profile == nil || profile.Name != test.profile {
+			t.Errorf("%v: profile %+v, want %s", test.at, profile, test.profile)
+		}
+	}
+}
+
+func TestInvalidProfiles(t *testing.T) {
+	bad := []Profile{
+		{Name: "Day", Days: []string{"Someday"}},
+		{Name: "Season", From: "04-01"},
+		{Name: "Date", From: "13-01", To: "04-01"},
+		{Name: "Time", AC_Charge: Mode{Windows: []Window{{Start: "25:00", End: "01:00"}}}},
+		{Name: "Power", AC_Charge: Mode{Power: 150}},
+		{Name: "Windows", AC_Charge: Mode{Windows: make([]Window, 4)}},
+	}
+	for _, profile := range bad {
+		if _, err := NewScheduler([]Profile{profile}, time.UTC); err == nil {
+			t.Errorf("profile %q accepted", profile.Name)
+		}
+	}
+}
+
+func startSimulator(t *testing.T) (*luxsim.Simulator, *luxclient.Client) {
+	t.Helper()
+	sim := luxsim.NewSimulator("BA00000001", "0000000001")
+	sim.Push = false
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go sim.Serve(listener)
+
+	client, err := luxclient.Dial(context.Background(), listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.SetDatalog(sim.DatalogSerial)
+	return sim, client
+}
+
+func TestApplyToSimulator(t *testing.T) {
+	sim, client := startSimulator(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Run(ctx)
+
+	scheduler, _ := NewScheduler(profiles(), time.UTC)
+	monday := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+
+	changes, err := scheduler.Apply(ctx, client, sim.InverterSerial, monday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enable bits, then power, SOC, start and end of both modes
+	if len(changes) != 9 {
+		t.Errorf("changes %v", changes)
+	}
+	for _, change := range changes {
+		if change.Outside {
+			t.Errorf("first change flagged: %v", change)
+		}
+	}
+
+	want := map[uint16]uint16{
+		luxproto.HOLD_FUNCTIONS:                  luxproto.ENABLE_AC_CHARGE | luxproto.ENABLE_FORCED_DISCHARGE,
+		luxproto.HOLD_AC_CHARGE_POWER:            50,
+		luxproto.HOLD_AC_CHARGE_SOC:              90,
+		luxproto.HOLD_AC_CHARGE_TIMES:            luxproto.HoldTime(23, 30),
+		luxproto.HOLD_AC_CHARGE_TIMES + 1:        luxproto.HoldTime(5, 0),
+		luxproto.HOLD_AC_CHARGE_TIMES + 2:        0,
+		luxproto.HOLD_FORCED_DISCHARGE_POWER:     80,
+		luxproto.HOLD_FORCED_DISCHARGE_SOC:       30,
+		luxproto.HOLD_FORCED_DISCHARGE_TIMES:     luxproto.HoldTime(17, 0),
+		luxproto.HOLD_FORCED_DISCHARGE_TIMES + 1: luxproto.HoldTime(19, 30),
+	}
+	for register, value := range want {
+		if have := sim.Holding(register); have != value {
+			t.Errorf("register %d is %04X, want %04X", register, have, value)
+		}
+	}
+
+	if changes, err := scheduler.Apply(ctx, client, sim.InverterSerial, monday); err != nil || len(changes) != 0 {
+		t.Errorf("second apply changed %v: %v", changes, err)
+	}
+
+	// Someone lowers the charge SOC in the app and sets another bit
+	client.WriteSingle(ctx, sim.InverterSerial, luxproto.HOLD_AC_CHARGE_SOC, 70)
+	client.WriteSingle(ctx, sim.InverterSerial, luxproto.HOLD_FUNCTIONS, luxproto.ENABLE_AC_CHARGE|luxproto.ENABLE_FORCED_DISCHARGE|1)
+	changes, err = scheduler.Apply(ctx, client, sim.InverterSerial, monday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || !changes[0].Outside || changes[0].Had != 70 || changes[0].Wrote != 90 {
+		t.Errorf("changes %v", changes)
+	}
+	if sim.Holding(luxproto.HOLD_FUNCTIONS)&1 == 0 {
+		t.Error("bit outside the schedule cleared")
+	}
+
+	// The weekend profile switches forced discharge off
+	changes, err = scheduler.Apply(ctx, client, sim.InverterSerial, monday.AddDate(0, 0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range changes {
+		if change.Outside {
+			t.Errorf("profile switch flagged: %v", change)
+		}
+	}
+	if sim.Holding(luxproto.HOLD_FUNCTIONS) != luxproto.ENABLE_AC_CHARGE|1 || sim.Holding(luxproto.HOLD_FORCED_DISCHARGE_TIMES) != 0 {
+		t.Errorf("weekend left functions %04X", sim.Holding(luxproto.HOLD_FUNCTIONS))
+	}
+}
@@ -0,0 +1,246 @@
+// Package luxserver takes the place of the LuxPower cloud. Dongles configured
+// to use it as their server connect in, and it answers their heartbeats and
+// polls the input registers of every inverter seen behind them.
+package luxserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"LuxLogger/luxclient"
+	"LuxLogger/luxproto"
+)
+
+const (
+	DEFAULT_POLL_INTERVAL = 30 * time.Second
+)
+
+// Dongle is a dongle connected to the server.
+type Dongle struct {
+	Client  *luxclient.Client
+	Address net.Addr
+
+	lock       sync.Mutex
+	identified bool
+	datalog    [10]byte
+	inverters  [][10]byte // In the order they were seen
+}
+
+// Datalog returns the serial number of the dongle, taken from the frame
+// headers it sends.
+func (dongle *Dongle) Datalog() [10]byte {
+	dongle.lock.Lock()
+	defer dongle.lock.Unlock()
+	return dongle.datalog
+}
+
+// Inverters returns the serial numbers of the inverters behind the dongle
+// that have answered a request or pushed data, several when they run in
+// parallel.
+func (dongle *Dongle) Inverters() [][10]byte {
+	dongle.lock.Lock()
+	defer dongle.lock.Unlock()
+	return append([][10]byte{}, dongle.inverters...)
+}
+
+func (dongle *Dongle) String() string {
+	return fmt.Sprintf("%s (%s)", dongle.Datalog(), dongle.Address)
+}
+
+type Server struct {
+	// PollInterval is the time between reads of the input registers
+	PollInterval time.Duration
+	// Extended also polls the input registers from 120 of newer firmware
+	Extended bool
+
+	// Frame is called with every data frame, both the ones pushed by the
+	// dongle and the answers to polls. It is called from the goroutines of
+	// the connection.
+	Frame func(dongle *Dongle, frame []byte)
+
+	lock    sync.Mutex
+	dongles map[[10]byte]*Dongle
+}
+
+func NewServer() *Server {
+	return &Server{
+		PollInterval: DEFAULT_POLL_INTERVAL,
+		dongles:      make(map[[10]byte]*Dongle),
+	}
+}
+
+func (server *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return server.Serve(ctx, listener)
+}
+
+// Serve accepts dongle connections on listener until ctx is done.
+func (server *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		go server.handle(ctx, conn)
+	}
+}
+
+// Dongles returns the dongles that are connected and identified.
+func (server *Server) Dongles() []*Dongle {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	dongles := make([]*Dongle, 0, len(server.dongles))
+	for _, dongle := range server.dongles {
+		dongles = append(dongles, dongle)
+	}
+	return dongles
+}
+
+func (server *Server) handle(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer conn.Close()
+
+	dongle := &Dongle{Client: luxclient.New(conn), Address: conn.RemoteAddr()}
+	identified := make(chan struct{})
+	dongle.Client.Unsolicited = func(frame []byte) {
+		header, _, err := luxproto.ParseFrame(frame)
+		// Without a serial number the dongle cannot be told apart
+		if err != nil || header.SerialNumber == ([10]byte{}) {
+			return
+		}
+
+		// The first frame identifies the dongle for the whole connection
+		dongle.lock.Lock()
+		first := !dongle.identified
+		if first {
+			dongle.datalog = header.SerialNumber
+			dongle.identified = true
+		}
+		dongle.lock.Unlock()
+		if first {
+			server.register(dongle)
+			close(identified)
+		}
+
+		switch header.Function {
+		case luxproto.FUNCTION_HEARTBEAT:
+			// The cloud answers heartbeats by sending them back
+			dongle.Client.WriteFrame(ctx, frame)
+		case luxproto.FUNCTION_DATA:
+			dongle.learnInverter(frame)
+			server.frame(dongle, frame)
+		}
+	}
+
+	go func() {
+		select {
+		case <-identified:
+			println("Dongle connected:", dongle.String())
+			server.poll(ctx, dongle)
+		case <-ctx.Done():
+		}
+	}()
+
+	err := dongle.Client.Run(ctx)
+	server.unregister(dongle)
+	println("Dongle disconnected:", dongle.String(), err.Error())
+}
+
+// register makes dongle the current connection for its serial number. A
+// dongle that reconnects replaces its old connection.
+func (server *Server) register(dongle *Dongle) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	datalog := dongle.Datalog()
+	if old, ok := server.dongles[datalog]; ok {
+		old.Client.Close()
+	}
+	server.dongles[datalog] = dongle
+}
+
+func (server *Server) unregister(dongle *Dongle) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	datalog := dongle.Datalog()
+	if server.dongles[datalog] == dongle {
+		delete(server.dongles, datalog)
+	}
+}
+
+// learnInverter adds the inverter serial number of a data frame to the
+// inverters of dongle.
+func (dongle *Dongle) learnInverter(frame []byte) {
+	_, data, err := luxproto.ParseFrame(frame)
+	if err != nil {
+		return
+	}
+	msg, err := luxproto.ParseMessage(data)
+	if err != nil || msg.SerialNumber == ([10]byte{}) {
+		return
+	}
+	dongle.lock.Lock()
+	defer dongle.lock.Unlock()
+	for _, inverter := range dongle.inverters {
+		if inverter == msg.SerialNumber {
+			return
+		}
+	}
+	dongle.inverters = append(dongle.inverters, msg.SerialNumber)
+}
+
+// poll reads the input register blocks of every inverter behind dongle each
+// PollInterval until ctx is done. Until an inverter is known the dongle is
+// asked without a serial number and answers for its own.
+func (server *Server) poll(ctx context.Context, dongle *Dongle) {
+	ticker := time.NewTicker(server.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		inverters := dongle.Inverters()
+		if len(inverters) == 0 {
+			inverters = [][10]byte{{}}
+		}
+		for _, inverter := range inverters {
+			err := dongle.Client.PollInput(ctx, inverter, luxproto.InputBlocks(server.Extended), func(frame []byte) {
+				dongle.learnInverter(frame)
+				server.frame(dongle, frame)
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				println("Poll of", string(inverter[:]), "behind", dongle.String(), "failed:", err.Error())
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (server *Server) frame(dongle *Dongle, frame []byte) {
+	if server.Frame != nil {
+		server.Frame(dongle, frame)
+	}
+}
@@ -0,0 +1,175 @@
+package luxserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"LuxLogger/luxclient"
+	"LuxLogger/luxproto"
+	"LuxLogger/luxsim"
+)
+
+func TestServePollsConnectedDongle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := make(chan luxproto.LogData, 10)
+	server := NewServer()
+	server.PollInterval = time.Hour
+	server.Extended = true
+	server.Frame = func(dongle *Dongle, frame []byte) {
+		log := luxproto.LogData{}
+		if log.Decode(frame, uint16(len(frame))) {
+			frames <- log
+		}
+	}
+	go server.Serve(ctx, listener)
+
+	sim := luxsim.NewSimulator("BA00000001", "0000000001")
+	sim.Push = false
+	go sim.DialAndServe(listener.Addr().String())
+
+	// The simulator does not push, so every block has to come from a poll
+	for _, register := range luxproto.InputBlocks(true) {
+		select {
+		case log := <-frames:
+			loaded := []bool{log.Section1.Loaded, log.Section2.Loaded, log.Section3.Loaded, log.Section4.Loaded}
+			if !loaded[register/luxproto.SECTION_REGISTERS] {
+				t.Errorf("poll of register %d decoded as %+v", register, loaded)
+			}
+			if log.SerialNumber != string(sim.DatalogSerial[:]) {
+				t.Errorf("frame carries datalog %s", log.SerialNumber)
+			}
+		case <-ctx.Done():
+			t.Fatal("no polled frame arrived")
+		}
+	}
+
+	dongles := server.Dongles()
+	if len(dongles) != 1 || dongles[0].Datalog() != sim.DatalogSerial || len(dongles[0].Inverters()) != 1 || dongles[0].Inverters()[0] != sim.InverterSerial {
+		t.Errorf("dongles %v", dongles)
+	}
+}
+
+func TestPollParallelInverters(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer()
+	server.PollInterval = 10 * time.Millisecond
+	go server.Serve(ctx, listener)
+
+	// A dongle with two inverters in parallel pushes data of both, then
+	// answers the polls of each one from its simulator
+	sims := map[[10]byte]*luxsim.Simulator{}
+	for _, inverter := range []string{"0000000001", "0000000002"} {
+		sim := luxsim.NewSimulator("BA00000001", inverter)
+		sims[sim.InverterSerial] = sim
+	}
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, sim := range sims {
+		if _, err := conn.Write(sim.InputFrame(0, luxproto.SECTION_REGISTERS)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dongle := luxclient.New(conn)
+	polled := map[[10]byte]bool{}
+	for len(polled) < len(sims) {
+		frame, err := dongle.ReadFrame(ctx)
+		if err != nil {
+			t.Fatalf("polled %v: %v", polled, err)
+		}
+		_, data, _ := luxproto.ParseFrame(frame)
+		request, err := luxproto.ParseMessage(data)
+		if err != nil {
+			continue
+		}
+		sim, ok := sims[request.SerialNumber]
+		if !ok {
+			// The first poll may come before the pushed data was seen
+			continue
+		}
+		polled[request.SerialNumber] = true
+		if _, err := conn.Write(sim.Respond(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dongles := server.Dongles()
+	if len(dongles) != 1 || len(dongles[0].Inverters()) != 2 {
+		t.Errorf("dongles %v", dongles)
+	}
+}
+
+func TestIdentifyOnce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer()
+	server.PollInterval = time.Hour
+	go server.Serve(ctx, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Frames without a serial number are ignored, later serials do not
+	// register the dongle again
+	datalog := [10]byte{}
+	copy(datalog[:], "BA00000001")
+	other := [10]byte{}
+	copy(other[:], "BA00000002")
+	for _, serial := range [][10]byte{{}, {}, datalog, datalog, other} {
+		if _, err := conn.Write(luxproto.EncodeFrame(luxproto.FUNCTION_HEARTBEAT, serial, []byte{0})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Heartbeats come back once the dongle is identified, the echo of the
+	// last one shows all of them were handled. The first poll comes in
+	// between.
+	client := luxclient.New(conn)
+	for _, serial := range [][10]byte{datalog, datalog, other} {
+		for {
+			echo, err := client.ReadFrame(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, _, err := luxproto.ParseFrame(echo)
+			if err != nil || header.Function != luxproto.FUNCTION_HEARTBEAT {
+				continue
+			}
+			if header.SerialNumber != serial {
+				t.Fatalf("echo from %s, expected one from %s", header.SerialNumber, serial)
+			}
+			break
+		}
+	}
+
+	dongles := server.Dongles()
+	if len(dongles) != 1 || dongles[0].Datalog() != datalog {
+		t.Errorf("dongles %v", dongles)
+	}
+}
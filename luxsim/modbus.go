@@ -0,0 +1,52 @@
+package luxsim
+
+import (
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/modbus"
+)
+
+// ReadRegisters answers a Modbus register read, so the simulator can stand in
+// for the inverter on its RS485 port. Input registers are refreshed once per
+// Interval as nothing pushes them.
+func (sim *Simulator) ReadRegisters(unit uint8, function uint8, register uint16, count uint16) ([]uint16, error) {
+	var bank []uint16
+	switch function {
+	case luxproto.DEVICE_READINPUT:
+		sim.lock.Lock()
+		due := time.Since(sim.updated) >= sim.Interval
+		sim.lock.Unlock()
+		if due {
+			sim.Update(time.Now())
+		}
+		bank = sim.input[:]
+	case luxproto.DEVICE_READHOLD:
+		bank = sim.holding[:]
+	default:
+		return nil, modbus.ILLEGAL_FUNCTION
+	}
+	if !inRange(register, int(count)) {
+		return nil, modbus.ILLEGAL_DATA_ADDRESS
+	}
+
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	values := make([]uint16, count)
+	for i := range values {
+		if int(register)+i < len(bank) {
+			values[i] = bank[int(register)+i]
+		}
+	}
+	return values, nil
+}
+
+// WriteRegisters answers a Modbus write of holding registers.
+func (sim *Simulator) WriteRegisters(unit uint8, register uint16, values []uint16) error {
+	if !inRange(register, len(values)) {
+		return modbus.ILLEGAL_DATA_ADDRESS
+	}
+	sim.write(register, luxproto.EncodeRegisters(values))
+	return nil
+}
@@ -0,0 +1,534 @@
+// Package luxsim simulates a LuxPower Wi-Fi dongle and the inverter behind it,
+// so that LuxLogger and other clients can be tested without hardware.
+package luxsim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/modbus"
+)
+
+const (
+	INPUT_REGISTERS   = 256
+	HOLDING_REGISTERS = 256
+	RATED_POWER       = 5000 // W, the export limit is a percentage of it
+)
+
+// Faults are the probabilities of the simulator misbehaving. Each one is
+// checked once per frame or, for disconnects and stale data, once per poll.
+type Faults struct {
+	SplitFrames float64 // Frame written in two parts with a pause between them
+	BadCRC      float64 // Frame sent with a corrupted CRC
+	Disconnect  float64 // Connection dropped by the dongle
+	StaleData   float64 // Values stop changing for StaleCycles polls
+	StaleCycles int
+}
+
+// Simulator behaves like a LuxPower Wi-Fi dongle with an inverter behind it.
+// It pushes heartbeats and input register blocks to every connected client
+// and answers read and write requests from an in-memory register bank.
+type Simulator struct {
+	DatalogSerial  [10]byte
+	InverterSerial [10]byte
+	Interval       time.Duration // Time between pushes of input registers
+	Heartbeat      time.Duration
+	Push           bool // Push input registers without being asked
+	Faults         Faults
+
+	lock    sync.Mutex
+	random  *rand.Rand
+	input   [INPUT_REGISTERS]uint16
+	holding [HOLDING_REGISTERS]uint16
+	model   simulatedInverter
+	stale   int
+	updated time.Time
+}
+
+// simulatedInverter is the physical state the register values are made from.
+type simulatedInverter struct {
+	cloud       float64 // 0 is clear sky, 1 is overcast
+	load        float64 // House load in W
+	soc         float64 // State of charge in %
+	energyToday [10]float64
+	energyTotal [10]float64
+	runtime     float64
+	cycles      float64
+	day         int
+	exportLimit float64 // W, from the holding register
+}
+
+// Index of the today and total energy counters in simulatedInverter
+const (
+	energyPV1 = iota
+	energyPV2
+	energyPV3
+	energyInverter
+	energyACCharge
+	energyCharge
+	energyDischarge
+	energyEPS
+	energyExport
+	energyImport
+)
+
+func NewSimulator(datalog string, inverter string) *Simulator {
+	sim := &Simulator{
+		Interval:  10 * time.Second,
+		Heartbeat: 60 * time.Second,
+		Push:      true,
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	copy(sim.DatalogSerial[:], datalog)
+	copy(sim.InverterSerial[:], inverter)
+
+	sim.model.soc = 50
+	sim.model.load = 400
+	for i := range sim.model.energyTotal {
+		sim.model.energyTotal[i] = 1000 + 100*float64(i)
+	}
+	sim.model.runtime = 3600 * 24 * 365
+	sim.model.cycles = 250
+
+	// Settings as the inverter leaves the factory
+	sim.holding[luxproto.HOLD_AC_CHARGE_POWER] = 100
+	sim.holding[luxproto.HOLD_AC_CHARGE_SOC] = 100
+	sim.holding[luxproto.HOLD_FORCED_DISCHARGE_POWER] = 100
+	sim.holding[luxproto.HOLD_FORCED_DISCHARGE_SOC] = 10
+	sim.holding[luxproto.HOLD_EXPORT_LIMIT] = 100
+
+	sim.Update(time.Now())
+	return sim
+}
+
+// Seed makes the simulated weather and load repeat for tests.
+func (sim *Simulator) Seed(seed int64) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	sim.random.Seed(seed)
+}
+
+func (sim *Simulator) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return sim.Serve(listener)
+}
+
+// DialAndServe connects to address the way a dongle connects to the cloud
+// server and serves the connection until it closes.
+func (sim *Simulator) DialAndServe(address string) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	sim.handle(conn)
+	return nil
+}
+
+func (sim *Simulator) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go sim.handle(conn)
+	}
+}
+
+type simulatedConnection struct {
+	sim    *Simulator
+	conn   net.Conn
+	lock   sync.Mutex
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (sim *Simulator) handle(conn net.Conn) {
+	client := &simulatedConnection{sim: sim, conn: conn, closed: make(chan struct{})}
+	defer client.close()
+
+	go client.push()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, luxproto.MAX_FRAME_LENGTH), luxproto.MAX_FRAME_LENGTH)
+	scanner.Split(luxproto.ScanFrames)
+	for scanner.Scan() {
+		response := sim.Respond(scanner.Bytes())
+		if response != nil {
+			client.send(response)
+		}
+	}
+}
+
+func (client *simulatedConnection) close() {
+	client.once.Do(func() {
+		close(client.closed)
+		client.conn.Close()
+	})
+}
+
+// push sends heartbeats and input register blocks until the connection
+// closes.
+func (client *simulatedConnection) push() {
+	heartbeat := time.NewTicker(client.sim.Heartbeat)
+	defer heartbeat.Stop()
+	data := time.NewTicker(client.sim.Interval)
+	defer data.Stop()
+
+	client.send(luxproto.EncodeHeartbeat(client.sim.DatalogSerial))
+	for {
+		select {
+		case <-client.closed:
+			return
+		case <-heartbeat.C:
+			client.send(luxproto.EncodeHeartbeat(client.sim.DatalogSerial))
+		case now := <-data.C:
+			client.sim.Update(now)
+			if client.sim.chance(client.sim.Faults.Disconnect) {
+				println("Simulating disconnect of", client.conn.RemoteAddr().String())
+				client.close()
+				return
+			}
+			if !client.sim.Push {
+				continue
+			}
+			for _, register := range luxproto.InputBlocks(false) {
+				client.send(client.sim.InputFrame(register, luxproto.SECTION_REGISTERS))
+			}
+		}
+	}
+}
+
+func (client *simulatedConnection) send(frame []byte) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if client.sim.chance(client.sim.Faults.BadCRC) {
+		frame = append([]byte(nil), frame...)
+		frame[len(frame)-1] ^= 0xFF
+	}
+
+	if client.sim.chance(client.sim.Faults.SplitFrames) {
+		split := 1 + client.sim.intn(len(frame)-1)
+		if _, err := client.conn.Write(frame[:split]); err != nil {
+			client.close()
+			return
+		}
+		time.Sleep(time.Duration(10+client.sim.intn(200)) * time.Millisecond)
+		frame = frame[split:]
+	}
+
+	if _, err := client.conn.Write(frame); err != nil {
+		client.close()
+	}
+}
+
+func (sim *Simulator) chance(probability float64) bool {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	return probability > 0 && sim.random.Float64() < probability
+}
+
+func (sim *Simulator) intn(n int) int {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	return sim.random.Intn(n)
+}
+
+// InputFrame builds the frame the dongle pushes for count input registers
+// starting at register.
+func (sim *Simulator) InputFrame(register uint16, count uint16) []byte {
+	msg := luxproto.Message{
+		Address:        luxproto.ADDRESS_RESPONSE,
+		DeviceFunction: luxproto.DEVICE_READINPUT,
+		SerialNumber:   sim.InverterSerial,
+		Register:       register,
+		Values:         sim.read(sim.input[:], register, count),
+	}
+	return luxproto.EncodeFrame(luxproto.FUNCTION_DATA, sim.DatalogSerial, msg.Bytes())
+}
+
+// Respond handles a frame received from a client and returns the frame to
+// send back, or nil if there is nothing to answer.
+func (sim *Simulator) Respond(frame []byte) []byte {
+	header, data, err := luxproto.ParseFrame(frame)
+	if err != nil {
+		println("Simulator received invalid frame:", err.Error())
+		return nil
+	}
+
+	if header.Function != luxproto.FUNCTION_DATA {
+		return nil
+	}
+
+	request, err := luxproto.ParseMessage(data)
+	if err != nil {
+		println("Simulator received invalid request:", err.Error())
+		return nil
+	}
+
+	if request.Address != luxproto.ADDRESS_REQUEST {
+		return nil
+	}
+
+	response := luxproto.Message{
+		Address:        luxproto.ADDRESS_RESPONSE,
+		DeviceFunction: request.DeviceFunction,
+		SerialNumber:   sim.InverterSerial,
+		Register:       request.Register,
+		Count:          request.Count,
+	}
+
+	switch request.DeviceFunction {
+	case luxproto.DEVICE_READINPUT, luxproto.DEVICE_READHOLD:
+		if !inRange(request.Register, int(request.Count)) {
+			return sim.exception(response, modbus.ILLEGAL_DATA_ADDRESS)
+		}
+		bank := sim.input[:]
+		if request.DeviceFunction == luxproto.DEVICE_READHOLD {
+			bank = sim.holding[:]
+		}
+		response.Values = sim.read(bank, request.Register, request.Count)
+	case luxproto.DEVICE_WRITESINGLE, luxproto.DEVICE_WRITEMULTI:
+		if !inRange(request.Register, len(request.Values)/2) {
+			return sim.exception(response, modbus.ILLEGAL_DATA_ADDRESS)
+		}
+		sim.write(request.Register, request.Values)
+		response.Values = request.Values
+	default:
+		println("Simulator received unhandled device function:", request.DeviceFunction)
+		return sim.exception(response, modbus.ILLEGAL_FUNCTION)
+	}
+
+	return luxproto.EncodeFrame(luxproto.FUNCTION_DATA, sim.DatalogSerial, response.Bytes())
+}
+
+// exception answers a request with a Modbus exception, the way the inverter
+// answers requests it cannot serve.
+func (sim *Simulator) exception(response luxproto.Message, exception modbus.Exception) []byte {
+	response.DeviceFunction |= luxproto.DEVICE_EXCEPTION
+	response.Count = 0
+	response.Values = []byte{uint8(exception)}
+	return luxproto.EncodeFrame(luxproto.FUNCTION_DATA, sim.DatalogSerial, response.Bytes())
+}
+
+// inRange tells if count registers from register exist. Both banks have the
+// same size.
+func inRange(register uint16, count int) bool {
+	return count > 0 && int(register)+count <= HOLDING_REGISTERS
+}
+
+func (sim *Simulator) read(bank []uint16, register uint16, count uint16) []byte {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	values := make([]byte, 2*int(count))
+	for i := 0; i < int(count); i++ {
+		if int(register)+i < len(bank) {
+			binary.LittleEndian.PutUint16(values[2*i:], bank[int(register)+i])
+		}
+	}
+	return values
+}
+
+func (sim *Simulator) write(register uint16, values []byte) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	for i := 0; i+1 < len(values); i += 2 {
+		if int(register)+i/2 < len(sim.holding) {
+			sim.holding[int(register)+i/2] = binary.LittleEndian.Uint16(values[i:])
+		}
+	}
+}
+
+// Holding returns the current value of a holding register, 0 for registers
+// the simulator does not have.
+func (sim *Simulator) Holding(register uint16) uint16 {
+	if !inRange(register, 1) {
+		return 0
+	}
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+	return sim.holding[register]
+}
+
+// Update advances the simulated inverter to now and refreshes the input
+// registers, unless the data is stale.
+func (sim *Simulator) Update(now time.Time) {
+	sim.lock.Lock()
+	defer sim.lock.Unlock()
+
+	elapsed := now.Sub(sim.updated).Hours()
+	if sim.updated.IsZero() || elapsed < 0 || elapsed > 1 {
+		elapsed = 0
+	}
+	sim.updated = now
+
+	if sim.stale > 0 {
+		sim.stale--
+		return
+	}
+	if sim.Faults.StaleData > 0 && sim.random.Float64() < sim.Faults.StaleData {
+		println("Simulating stale data")
+		sim.stale = sim.Faults.StaleCycles
+	}
+
+	sim.model.exportLimit = math.Inf(1)
+	if sim.holding[luxproto.HOLD_FUNCTIONS]&luxproto.ENABLE_EXPORT_LIMIT != 0 {
+		sim.model.exportLimit = float64(sim.holding[luxproto.HOLD_EXPORT_LIMIT]) / 100 * RATED_POWER
+	}
+	raw := sim.model.step(now, elapsed, sim.random)
+	copy(sim.input[:], raw.Registers())
+}
+
+// step moves the model forward by elapsed hours and returns the registers
+// an inverter in that state would report.
+func (model *simulatedInverter) step(now time.Time, elapsed float64, random *rand.Rand) luxproto.LogDataRaw {
+	if now.YearDay() != model.day {
+		model.day = now.YearDay()
+		model.energyToday = [10]float64{}
+	}
+
+	// Sun between 6:00 and 18:00 with slowly drifting clouds
+	hour := float64(now.Hour()) + float64(now.Minute())/60 + float64(now.Second())/3600
+	sun := math.Max(0, math.Sin(math.Pi*(hour-6)/12))
+	model.cloud = math.Min(1, math.Max(0, model.cloud+random.NormFloat64()*0.05))
+	pv1 := 3000 * sun * (1 - 0.7*model.cloud)
+	pv2 := 2000 * sun * (1 - 0.7*model.cloud)
+
+	// House load wanders around a base load with an evening peak
+	base := 350.0
+	if hour >= 17 && hour < 22 {
+		base = 1200
+	}
+	model.load = math.Max(100, model.load+(base-model.load)*0.1+random.NormFloat64()*80)
+
+	// Battery takes the surplus and covers the deficit within its limits
+	capacity := 10.24 // kWh
+	surplus := pv1 + pv2 - model.load
+	charge, discharge := 0.0, 0.0
+	if surplus > 0 && model.soc < 100 {
+		charge = math.Min(surplus, 3000)
+	} else if surplus < 0 && model.soc > 10 {
+		discharge = math.Min(-surplus, 3000)
+	}
+	model.soc = math.Min(100, math.Max(0, model.soc+(charge*0.95-discharge)/1000*elapsed/capacity*100))
+	model.cycles += discharge / 1000 * elapsed / capacity
+
+	// PV is curtailed to keep the export within the limit
+	grid := surplus - charge + discharge
+	if grid > model.exportLimit {
+		curtail := (pv1 + pv2 - (grid - model.exportLimit)) / (pv1 + pv2)
+		pv1 *= curtail
+		pv2 *= curtail
+		grid = model.exportLimit
+	}
+	export, imported := math.Max(0, grid), math.Max(0, -grid)
+	inverter := pv1 + pv2 + discharge - charge
+
+	for i, power := range []float64{pv1, pv2, 0, inverter, 0, charge, discharge, 0, export, imported} {
+		model.energyToday[i] += power / 1000 * elapsed
+		model.energyTotal[i] += power / 1000 * elapsed
+	}
+	model.runtime += elapsed * 3600
+
+	status := uint16(luxproto.STATUS_STANDBY)
+	switch {
+	case charge > 0:
+		status = luxproto.STATUS_PV_CHARGE_ON_GRID
+	case discharge > 0 && pv1+pv2 > 0:
+		status = luxproto.STATUS_PV_BATTERY_ON_GRID
+	case discharge > 0:
+		status = luxproto.STATUS_BATTERY_ON_GRID
+	case pv1+pv2 > 0:
+		status = luxproto.STATUS_PV_ON_GRID
+	}
+
+	batteryVoltage := 48 + 6*model.soc/100
+	gridVoltage := 230 + random.NormFloat64()*2
+	temperature := 25 + inverter/200 + random.NormFloat64()
+
+	raw := luxproto.LogDataRaw{}
+	raw.Section1 = luxproto.LogDataRawSection1{
+		Status:                      status,
+		PV1_Voltage:                 uint16(10 * math.Max(0, 360*math.Min(1, sun*5)+random.NormFloat64()*2)),
+		PV2_Voltage:                 uint16(10 * math.Max(0, 320*math.Min(1, sun*5)+random.NormFloat64()*2)),
+		Battery_Voltage:             uint16(10 * batteryVoltage),
+		SOC:                         uint8(model.soc),
+		SOH:                         100,
+		PV1_Power:                   uint16(pv1),
+		PV2_Power:                   uint16(pv2),
+		Charge_Power:                uint16(charge),
+		Discharge_Power:             uint16(discharge),
+		Voltage_AC_R:                uint16(10 * gridVoltage),
+		Frequency_Grid:              uint16(100 * (50 + random.NormFloat64()*0.02)),
+		ActiveInverter_Power:        uint16(math.Max(0, inverter)),
+		ActiveCharge_Power:          uint16(math.Max(0, -inverter)),
+		Inductor_Current:            uint16(100 * math.Abs(inverter) / gridVoltage),
+		Grid_Power_Factor:           1000,
+		Voltage_EPS_R:               uint16(10 * gridVoltage),
+		Frequency_EPS:               5000,
+		Power_To_Grid:               uint16(export),
+		Power_From_Grid:             uint16(imported),
+		PV1_Energy_Today:            uint16(10 * model.energyToday[energyPV1]),
+		PV2_Energy_Today:            uint16(10 * model.energyToday[energyPV2]),
+		PV3_Energy_Today:            uint16(10 * model.energyToday[energyPV3]),
+		ActiveInverter_Energy_Today: uint16(10 * model.energyToday[energyInverter]),
+		AC_Charging_Today:           uint16(10 * model.energyToday[energyACCharge]),
+		Charging_Today:              uint16(10 * model.energyToday[energyCharge]),
+		Discharging_Today:           uint16(10 * model.energyToday[energyDischarge]),
+		EPS_Today:                   uint16(10 * model.energyToday[energyEPS]),
+		Exported_Today:              uint16(10 * model.energyToday[energyExport]),
+		Grid_Today:                  uint16(10 * model.energyToday[energyImport]),
+		Bus1_Voltage:                380,
+		Bus2_Voltage:                300,
+	}
+	raw.Section2 = luxproto.LogDataRawSection2{
+		PV1_Energy_Total:            luxproto.NewU32(uint32(10 * model.energyTotal[energyPV1])),
+		PV2_Energy_Total:            luxproto.NewU32(uint32(10 * model.energyTotal[energyPV2])),
+		PV3_Energy_Total:            luxproto.NewU32(uint32(10 * model.energyTotal[energyPV3])),
+		ActiveInverter_Energy_Total: luxproto.NewU32(uint32(10 * model.energyTotal[energyInverter])),
+		AC_Charging_Total:           luxproto.NewU32(uint32(10 * model.energyTotal[energyACCharge])),
+		Charging_Total:              luxproto.NewU32(uint32(10 * model.energyTotal[energyCharge])),
+		Discharging_Total:           luxproto.NewU32(uint32(10 * model.energyTotal[energyDischarge])),
+		EPS_Total:                   luxproto.NewU32(uint32(10 * model.energyTotal[energyEPS])),
+		Exported_Total:              luxproto.NewU32(uint32(10 * model.energyTotal[energyExport])),
+		Grid_Total:                  luxproto.NewU32(uint32(10 * model.energyTotal[energyImport])),
+		Inner_Temperature:           int16(temperature),
+		Radiator1_Temperature:       int16(temperature - 3),
+		Radiator2_Temperature:       int16(temperature - 5),
+		Battery_Temperature:         int16(22 + random.NormFloat64()*0.5),
+		Runtime:                     luxproto.NewU32(uint32(model.runtime)),
+	}
+	raw.Section3 = luxproto.LogDataRawSection3{
+		BatteryComType:               luxproto.NewU8Pair(1),
+		BMS_Max_Charge_Current:       10000,
+		BMS_Max_Discharge_Current:    10000,
+		BMS_Charge_Voltage_Reference: 560,
+		BMS_Discharge_Cutoff:         480,
+		Battery_Parallel_Count:       2,
+		Battery_Capacity:             200,
+		Battery_Current:              int16(100 * (charge - discharge) / batteryVoltage),
+		MaxCell_Voltage:              uint16(1000 * (batteryVoltage/16 + 0.01)),
+		MinCell_Voltage:              uint16(1000 * (batteryVoltage/16 - 0.01)),
+		MaxCell_Temp:                 23,
+		MinCell_Temp:                 21,
+		Cycle_Count:                  uint16(model.cycles),
+		BatteryInverter_Voltage:      uint16(10 * batteryVoltage),
+	}
+	// A single phase inverter without generator, all load is on L1
+	raw.Section4 = luxproto.LogDataRawSection4{
+		Load_Power_L1: uint16(model.load),
+	}
+
+	return raw
+}
@@ -0,0 +1,126 @@
+package luxsim
+
+import (
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/modbus"
+)
+
+func request(sim *Simulator, function uint8, register uint16, count uint16, values ...uint16) luxproto.Message {
+	return luxproto.Message{
+		Address:        luxproto.ADDRESS_REQUEST,
+		DeviceFunction: function,
+		SerialNumber:   sim.InverterSerial,
+		Register:       register,
+		Count:          count,
+		Values:         luxproto.EncodeRegisters(values),
+	}
+}
+
+func respond(t *testing.T, sim *Simulator, msg luxproto.Message) luxproto.Message {
+	t.Helper()
+	frame := sim.Respond(luxproto.EncodeFrame(luxproto.FUNCTION_DATA, sim.DatalogSerial, msg.Bytes()))
+	if frame == nil {
+		t.Fatal("no response")
+	}
+	_, data, err := luxproto.ParseFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := luxproto.ParseMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestRespond(t *testing.T) {
+	sim := NewSimulator("BA00000001", "0000000001")
+
+	response := respond(t, sim, request(sim, luxproto.DEVICE_READHOLD, luxproto.HOLD_AC_CHARGE_POWER, 2))
+	if values := response.Registers(); len(values) != 2 || values[0] != 100 || values[1] != 100 {
+		t.Errorf("read %v", values)
+	}
+
+	respond(t, sim, request(sim, luxproto.DEVICE_WRITESINGLE, luxproto.HOLD_EXPORT_LIMIT, 1, 40))
+	respond(t, sim, request(sim, luxproto.DEVICE_WRITEMULTI, 254, 2, 1, 2))
+	if sim.Holding(luxproto.HOLD_EXPORT_LIMIT) != 40 || sim.Holding(255) != 2 {
+		t.Errorf("written %d and %d", sim.Holding(luxproto.HOLD_EXPORT_LIMIT), sim.Holding(255))
+	}
+}
+
+func TestRespondOutOfRange(t *testing.T) {
+	sim := NewSimulator("BA00000001", "0000000001")
+
+	tests := []struct {
+		name      string
+		msg       luxproto.Message
+		exception modbus.Exception
+	}{
+		{"ReadHold", request(sim, luxproto.DEVICE_READHOLD, 250, 10), modbus.ILLEGAL_DATA_ADDRESS},
+		{"ReadInput", request(sim, luxproto.DEVICE_READINPUT, 300, 1), modbus.ILLEGAL_DATA_ADDRESS},
+		{"WriteSingle", request(sim, luxproto.DEVICE_WRITESINGLE, 256, 1, 1), modbus.ILLEGAL_DATA_ADDRESS},
+		{"WriteMulti", request(sim, luxproto.DEVICE_WRITEMULTI, 255, 2, 1, 2), modbus.ILLEGAL_DATA_ADDRESS},
+		{"Function", request(sim, 0x07, 0, 1), modbus.ILLEGAL_FUNCTION},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := respond(t, sim, test.msg)
+			if response.DeviceFunction != test.msg.DeviceFunction|luxproto.DEVICE_EXCEPTION {
+				t.Errorf("function %02X", response.DeviceFunction)
+			}
+			if len(response.Values) != 1 || modbus.Exception(response.Values[0]) != test.exception {
+				t.Errorf("exception % X", response.Values)
+			}
+		})
+	}
+
+	if sim.Holding(255) != 0 || sim.Holding(1000) != 0 {
+		t.Error("out of range write landed")
+	}
+}
+
+func TestModbusOutOfRange(t *testing.T) {
+	sim := NewSimulator("BA00000001", "0000000001")
+
+	if _, err := sim.ReadRegisters(1, luxproto.DEVICE_READHOLD, 200, 57); err != modbus.ILLEGAL_DATA_ADDRESS {
+		t.Errorf("read beyond the registers returned %v", err)
+	}
+	if err := sim.WriteRegisters(1, 256, []uint16{1}); err != modbus.ILLEGAL_DATA_ADDRESS {
+		t.Errorf("write beyond the registers returned %v", err)
+	}
+	if values, err := sim.ReadRegisters(1, luxproto.DEVICE_READHOLD, 200, 56); err != nil || len(values) != 56 {
+		t.Errorf("read up to the last register returned %d values, %v", len(values), err)
+	}
+}
+
+func TestExportLimit(t *testing.T) {
+	sim := NewSimulator("BA00000001", "0000000001")
+	sim.Seed(1)
+	noon := time.Date(2024, 6, 3, 12, 0, 0, 0, time.Local)
+
+	export := func(minute int) float32 {
+		sim.Update(noon.Add(time.Duration(minute) * time.Minute))
+		frame := sim.InputFrame(luxproto.INPUT_SECTION1, luxproto.SECTION_REGISTERS)
+		log := luxproto.LogData{}
+		if !log.Decode(frame, uint16(len(frame))) {
+			t.Fatal("frame not decoded")
+		}
+		return log.Section1.Power_To_Grid
+	}
+
+	// The limit does nothing until it is enabled
+	respond(t, sim, request(sim, luxproto.DEVICE_WRITESINGLE, luxproto.HOLD_EXPORT_LIMIT, 1, 10))
+	if watts := export(0); watts <= 0.1*RATED_POWER {
+		t.Fatalf("%v W exported without the limit", watts)
+	}
+
+	functions := sim.Holding(luxproto.HOLD_FUNCTIONS) | luxproto.ENABLE_EXPORT_LIMIT
+	respond(t, sim, request(sim, luxproto.DEVICE_WRITESINGLE, luxproto.HOLD_FUNCTIONS, 1, functions))
+	if watts := export(1); watts > 0.1*RATED_POWER {
+		t.Errorf("%v W exported with a limit of 10 %%", watts)
+	}
+}
@@ -0,0 +1,242 @@
+package luxtariff
+
+import (
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+const (
+	PERIOD_DAY   = "day"
+	PERIOD_MONTH = "month"
+	// RESET_WINDOW around midnight is when the *_Today counters may restart
+	RESET_WINDOW = time.Hour
+)
+
+// Costs of one inverter over a day or a month. Energies are in kWh, money
+// in Tariff.Currency.
+type Costs struct {
+	Period         string // PERIOD_DAY or PERIOD_MONTH
+	Date           string // Like "2024-06-01" or "2024-06"
+	SerialNumber   string
+	InverterSerial string
+	Currency       string
+	Start          time.Time // Midnight starting the period
+	End            time.Time // Last data counted
+
+	Imported      float32
+	Exported      float32
+	Self_Consumed float32 // PV used by the load straight away
+	Discharged    float32
+
+	Import_Cost    float64
+	Export_Revenue float64
+	Net_Cost       float64 // Import_Cost less Export_Revenue
+	// Self_Consumption_Savings is the self consumed PV at the import price
+	Self_Consumption_Savings float64
+	// Battery_Savings is the discharged energy at the import price, less
+	// what charging cost: the import price for AC charging and the export
+	// price foregone for charging from PV
+	Battery_Savings float64
+	Savings         float64
+}
+
+// counters are the energy counters in kWh the costs come from.
+type counters struct {
+	grid        float32
+	exported    float32
+	pv          float32
+	charging    float32
+	discharging float32
+	acCharging  float32
+}
+
+func todayCounters(section luxproto.LogDataSection1) counters {
+	return counters{
+		grid:        section.Grid_Today,
+		exported:    section.Exported_Today,
+		pv:          section.PV1_Energy_Today + section.PV2_Energy_Today + section.PV3_Energy_Today,
+		charging:    section.Charging_Today,
+		discharging: section.Discharging_Today,
+		acCharging:  section.AC_Charging_Today,
+	}
+}
+
+func totalCounters(section luxproto.LogDataSection2) counters {
+	return counters{
+		grid:        section.Grid_Total,
+		exported:    section.Exported_Total,
+		pv:          section.PV1_Energy_Total + section.PV2_Energy_Total + section.PV3_Energy_Total,
+		charging:    section.Charging_Total,
+		discharging: section.Discharging_Total,
+		acCharging:  section.AC_Charging_Total,
+	}
+}
+
+// since returns the energy counted from last to current. When a counter
+// went backwards it returns false unless reset is set, then the counters
+// started over and all of their current value is new.
+func (current counters) since(last counters, reset bool) (counters, bool) {
+	now := current.values()
+	before := last.values()
+	for i := range now {
+		if now[i] >= before[i] {
+			continue
+		}
+		if !reset {
+			return counters{}, false
+		}
+		before[i] = 0
+	}
+	return counters{
+		grid:        now[0] - before[0],
+		exported:    now[1] - before[1],
+		pv:          now[2] - before[2],
+		charging:    now[3] - before[3],
+		discharging: now[4] - before[4],
+		acCharging:  now[5] - before[5],
+	}, true
+}
+
+func (current counters) values() [6]float32 {
+	return [6]float32{current.grid, current.exported, current.pv, current.charging, current.discharging, current.acCharging}
+}
+
+// nearMidnight tells if the *_Today counters of the inverter may have
+// restarted at local, allowing for an inverter clock that is off.
+func nearMidnight(local time.Time) bool {
+	minutes := local.Hour()*60 + local.Minute()
+	window := int(RESET_WINDOW / time.Minute)
+	return minutes < window || minutes >= 24*60-window
+}
+
+type inverter struct {
+	// The *_Total counters of Section2 are used once seen, the *_Today
+	// counters of Section1 until then
+	useTotals bool
+	total     counters
+	today     counters
+	haveToday bool
+	day       Costs
+	month     Costs
+}
+
+// Tracker prices the energy each inverter counted since its last frame at
+// the tariff of the time of the frame.
+type Tracker struct {
+	tariff   Tariff
+	location *time.Location
+
+	lock      sync.Mutex
+	inverters map[string]*inverter
+}
+
+// NewTracker checks the bands of tariff and starts days and months at
+// midnight in location.
+func NewTracker(tariff Tariff, location *time.Location) (*Tracker, error) {
+	if err := tariff.parse(); err != nil {
+		return nil, err
+	}
+	if location == nil {
+		location = time.Local
+	}
+	return &Tracker{tariff: tariff, location: location, inverters: make(map[string]*inverter)}, nil
+}
+
+// Update prices the energy counted since the last frame of the inverter and
+// returns the costs of the current day and month when any was added.
+func (tracker *Tracker) Update(log luxproto.LogData) (day Costs, month Costs, changed bool) {
+	now := log.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	local := now.In(tracker.location)
+
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	key := log.SerialNumber + "/" + log.InverterSerial
+	current, ok := tracker.inverters[key]
+	if !ok {
+		current = &inverter{}
+		tracker.inverters[key] = current
+	}
+
+	// A counter going backwards is a glitched or late frame, skipped to keep
+	// the baseline. Only the *_Today counters restart, at midnight.
+	var delta counters
+	switch {
+	case log.Section2.Loaded:
+		counted := totalCounters(log.Section2)
+		if current.useTotals {
+			if delta, changed = counted.since(current.total, false); !changed {
+				return Costs{}, Costs{}, false
+			}
+		}
+		current.useTotals = true
+		current.total = counted
+	case log.Section1.Loaded && !current.useTotals:
+		counted := todayCounters(log.Section1)
+		if current.haveToday {
+			if delta, changed = counted.since(current.today, nearMidnight(local)); !changed {
+				return Costs{}, Costs{}, false
+			}
+		}
+		current.haveToday = true
+		current.today = counted
+	}
+	if !changed {
+		return Costs{}, Costs{}, false
+	}
+
+	year, monthOfYear, dayOfMonth := local.Date()
+	dayStart := time.Date(year, monthOfYear, dayOfMonth, 0, 0, 0, 0, tracker.location)
+	monthStart := time.Date(year, monthOfYear, 1, 0, 0, 0, 0, tracker.location)
+	if !current.day.Start.Equal(dayStart) {
+		current.day = tracker.period(log, PERIOD_DAY, dayStart.Format("2006-01-02"), dayStart)
+	}
+	if !current.month.Start.Equal(monthStart) {
+		current.month = tracker.period(log, PERIOD_MONTH, monthStart.Format("2006-01"), monthStart)
+	}
+
+	importPrice, exportPrice := tracker.tariff.Prices(local)
+	current.day.add(delta, now, importPrice, exportPrice)
+	current.month.add(delta, now, importPrice, exportPrice)
+	return current.day, current.month, true
+}
+
+func (tracker *Tracker) period(log luxproto.LogData, period string, date string, start time.Time) Costs {
+	return Costs{
+		Period:         period,
+		Date:           date,
+		SerialNumber:   log.SerialNumber,
+		InverterSerial: log.InverterSerial,
+		Currency:       tracker.tariff.Currency,
+		Start:          start,
+	}
+}
+
+func (costs *Costs) add(delta counters, now time.Time, importPrice float64, exportPrice float64) {
+	fromPV := delta.charging - delta.acCharging
+	if fromPV < 0 {
+		fromPV = 0
+	}
+	selfConsumed := delta.pv - delta.exported - fromPV
+	if selfConsumed < 0 {
+		selfConsumed = 0
+	}
+
+	costs.End = now
+	costs.Imported += delta.grid
+	costs.Exported += delta.exported
+	costs.Self_Consumed += selfConsumed
+	costs.Discharged += delta.discharging
+
+	costs.Import_Cost += float64(delta.grid) * importPrice
+	costs.Export_Revenue += float64(delta.exported) * exportPrice
+	costs.Net_Cost = costs.Import_Cost - costs.Export_Revenue
+	costs.Self_Consumption_Savings += float64(selfConsumed) * importPrice
+	costs.Battery_Savings += float64(delta.discharging)*importPrice - float64(delta.acCharging)*importPrice - float64(fromPV)*exportPrice
+	costs.Savings = costs.Self_Consumption_Savings + costs.Battery_Savings
+}
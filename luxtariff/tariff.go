@@ -0,0 +1,83 @@
+// Package luxtariff turns the energy counters of the inverters into money:
+// what the grid import cost, what the export earned and what PV and the
+// battery saved, per day and per month.
+package luxtariff
+
+import (
+	"fmt"
+	"time"
+
+	"LuxLogger/luxtime"
+)
+
+// Band is a time-of-use window with its own prices. End before Start spans
+// midnight.
+type Band struct {
+	Name  string
+	Start string // Like "07:00"
+	End   string
+	// Days limits the band to "weekday", "weekend" or days like "Mon", all
+	// days when empty
+	Days []string
+
+	Import float64 // Per kWh
+	// Export is the feed-in price during the band, 0 keeps Tariff.Export
+	Export float64
+
+	start int // Minutes after midnight
+	end   int
+	days  [7]bool
+}
+
+// Tariff holds the prices per kWh. Bands override Import and Export while
+// they apply, the first matching band wins.
+type Tariff struct {
+	Currency string
+	Import   float64
+	Export   float64 // Fixed feed-in rate
+	Bands    []Band
+}
+
+// parse checks the bands and works out their times.
+func (tariff *Tariff) parse() error {
+	for i := range tariff.Bands {
+		band := &tariff.Bands[i]
+		var err error
+		if band.start, err = luxtime.Minutes(band.Start); err != nil {
+			return fmt.Errorf("band %q: %w", band.Name, err)
+		}
+		if band.end, err = luxtime.Minutes(band.End); err != nil {
+			return fmt.Errorf("band %q: %w", band.Name, err)
+		}
+		if band.days, err = luxtime.Days(band.Days); err != nil {
+			return fmt.Errorf("band %q: %w", band.Name, err)
+		}
+	}
+	return nil
+}
+
+// Prices returns the import and export price at the local time at.
+func (tariff *Tariff) Prices(at time.Time) (importPrice float64, exportPrice float64) {
+	now := at.Hour()*60 + at.Minute()
+	for _, band := range tariff.Bands {
+		// A band spanning midnight belongs to the day it starts on
+		day := at.Weekday()
+		inside := band.start <= now && now < band.end
+		if band.end <= band.start {
+			inside = now >= band.start
+			if now < band.end {
+				inside = true
+				day = (day + 6) % 7
+			}
+		}
+		if !inside || !band.days[day] {
+			continue
+		}
+		exportPrice = tariff.Export
+		if band.Export != 0 {
+			exportPrice = band.Export
+		}
+		return band.Import, exportPrice
+	}
+	return tariff.Import, tariff.Export
+}
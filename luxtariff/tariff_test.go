@@ -0,0 +1,182 @@
+package luxtariff
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxtest"
+)
+
+func tariff() Tariff {
+	return Tariff{
+		Currency: "ZAR",
+		Import:   2,
+		Export:   1,
+		Bands: []Band{
+			{Name: "Peak", Start: "17:00", End: "20:00", Days: []string{"weekday"}, Import: 5},
+			{Name: "Night", Start: "22:00", End: "06:00", Import: 1, Export: 0.5},
+		},
+	}
+}
+
+func TestPrices(t *testing.T) {
+	tariff := tariff()
+	if err := tariff.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at      time.Time
+		imports float64
+		exports float64
+	}{
+		{time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC), 2, 1},   // Monday midday
+		{time.Date(2024, 6, 3, 18, 0, 0, 0, time.UTC), 5, 1},   // Monday peak
+		{time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC), 2, 1},   // No peak on Saturday
+		{time.Date(2024, 6, 3, 23, 0, 0, 0, time.UTC), 1, 0.5}, // Night before midnight
+		{time.Date(2024, 6, 4, 5, 59, 0, 0, time.UTC), 1, 0.5}, // And after
+		{time.Date(2024, 6, 4, 6, 0, 0, 0, time.UTC), 2, 1},
+	}
+	for _, test := range tests {
+		imports, exports := tariff.Prices(test.at)
+		if imports != test.imports || exports != test.exports {
+			t.Errorf("%v: prices %v %v, want %v %v", test.at, imports, exports, test.imports, test.exports)
+		}
+	}
+}
+
+func TestInvalidBand(t *testing.T) {
+	bad := []Band{
+		{Name: "Peak", Start: "17h00", End: "20:00"},
+		{Name: "Peak", Start: "17:00", End: "20:00", Days: []string{"Someday"}},
+	}
+	for _, band := range bad {
+		if _, err := NewTracker(Tariff{Bands: []Band{band}}, time.UTC); err == nil {
+			t.Errorf("band %+v accepted", band)
+		}
+	}
+}
+
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
+
+func TestDailyCosts(t *testing.T) {
+	tracker, err := NewTracker(tariff(), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monday := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+
+	frames := []luxproto.LogData{
+		luxtest.Frame(monday.Add(1*time.Hour), luxproto.LogDataSection1{}),
+		// Night: 4 kWh imported of which 3 kWh charged the battery at 1
+		luxtest.Frame(monday.Add(5*time.Hour), luxproto.LogDataSection1{Grid_Today: 4, Charging_Today: 3, AC_Charging_Today: 3}),
+		// Midday: 6 kWh PV, 2 kWh exported, 1 kWh charged from PV
+		luxtest.Frame(monday.Add(12*time.Hour), luxproto.LogDataSection1{Grid_Today: 4, Exported_Today: 2, PV1_Energy_Today: 6, Charging_Today: 4, AC_Charging_Today: 3}),
+		// Peak: 3 kWh discharged instead of imported at 5
+		luxtest.Frame(monday.Add(18*time.Hour), luxproto.LogDataSection1{Grid_Today: 4, Exported_Today: 2, PV1_Energy_Today: 6, Charging_Today: 4, AC_Charging_Today: 3, Discharging_Today: 3}),
+	}
+	var day, month Costs
+	for i, log := range frames {
+		var changed bool
+		day, month, changed = tracker.Update(log)
+		if changed != (i > 0) {
+			t.Fatalf("frame %d changed %v", i, changed)
+		}
+	}
+
+	if day.Period != PERIOD_DAY || day.Date != "2024-06-03" || day.Currency != "ZAR" || !day.Start.Equal(monday) {
+		t.Errorf("day %+v", day)
+	}
+	if day.Imported != 4 || day.Exported != 2 || day.Self_Consumed != 3 || day.Discharged != 3 {
+		t.Errorf("energies %+v", day)
+	}
+	if !near(day.Import_Cost, 4) || !near(day.Export_Revenue, 2) || !near(day.Net_Cost, 2) {
+		t.Errorf("cost %v revenue %v net %v", day.Import_Cost, day.Export_Revenue, day.Net_Cost)
+	}
+	// 3 kWh self consumed at 2, 3 kWh discharged at 5 less 3 at 1 and 1 at 1
+	if !near(day.Self_Consumption_Savings, 6) || !near(day.Battery_Savings, 11) || !near(day.Savings, 17) {
+		t.Errorf("savings %v battery %v total %v", day.Self_Consumption_Savings, day.Battery_Savings, day.Savings)
+	}
+	if month.Period != PERIOD_MONTH || month.Date != "2024-06" || !near(month.Net_Cost, day.Net_Cost) {
+		t.Errorf("month %+v", month)
+	}
+
+	// The counters restart at midnight, the new day starts from them
+	day, month, _ = tracker.Update(luxtest.Frame(monday.Add(24*time.Hour+30*time.Minute), luxproto.LogDataSection1{Grid_Today: 1}))
+	if day.Date != "2024-06-04" || day.Imported != 1 || !near(day.Import_Cost, 1) {
+		t.Errorf("next day %+v", day)
+	}
+	if month.Imported != 5 || !near(month.Import_Cost, 5) {
+		t.Errorf("month after two days %+v", month)
+	}
+}
+
+func TestTotalsReplaceToday(t *testing.T) {
+	tracker, _ := NewTracker(Tariff{Import: 2, Export: 1}, time.UTC)
+	start := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+
+	tracker.Update(luxtest.Frame(start, luxproto.LogDataSection1{}))
+	total := luxtest.Frame(start.Add(time.Minute), luxproto.LogDataSection2{Grid_Total: 1000})
+	if _, _, changed := tracker.Update(total); changed {
+		t.Error("first totals counted")
+	}
+
+	total.Time = start.Add(2 * time.Minute)
+	total.Section2.Grid_Total = 1002
+	day, _, _ := tracker.Update(total)
+	if day.Imported != 2 {
+		t.Errorf("imported %v", day.Imported)
+	}
+	// With the totals there, the today counters are left alone
+	if _, _, changed := tracker.Update(luxtest.Frame(start.Add(3*time.Minute), luxproto.LogDataSection1{Grid_Today: 50})); changed {
+		t.Error("today counters counted next to the totals")
+	}
+}
+
+func TestCountersGoingBack(t *testing.T) {
+	tracker, _ := NewTracker(Tariff{Import: 2, Export: 1}, time.UTC)
+	start := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	total := func(minute int, grid float32, exported float32) luxproto.LogData {
+		return luxtest.Frame(start.Add(time.Duration(minute)*time.Minute), luxproto.LogDataSection2{Grid_Total: grid, Exported_Total: exported})
+	}
+
+	tracker.Update(total(0, 1000, 500))
+	tracker.Update(total(1, 1002, 500))
+	// A zeroed frame and a late frame are skipped, the baseline stays
+	if _, _, changed := tracker.Update(total(2, 0, 0)); changed {
+		t.Error("zeroed totals counted")
+	}
+	if _, _, changed := tracker.Update(total(3, 1001, 500)); changed {
+		t.Error("totals going back counted")
+	}
+	day, month, _ := tracker.Update(total(4, 1003, 501))
+	if day.Imported != 3 || day.Exported != 1 || month.Imported != 3 || !near(day.Import_Cost, 6) {
+		t.Errorf("day %+v", day)
+	}
+}
+
+func TestTodayResetsAtMidnight(t *testing.T) {
+	tracker, _ := NewTracker(Tariff{Import: 2, Export: 1}, time.UTC)
+	start := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+
+	tracker.Update(luxtest.Frame(start, luxproto.LogDataSection1{Grid_Today: 5}))
+	tracker.Update(luxtest.Frame(start.Add(time.Minute), luxproto.LogDataSection1{Grid_Today: 6}))
+	// In the afternoon a drop is a glitch, not a new day
+	if _, _, changed := tracker.Update(luxtest.Frame(start.Add(2*time.Minute), luxproto.LogDataSection1{})); changed {
+		t.Error("zeroed today counters counted")
+	}
+	day, _, _ := tracker.Update(luxtest.Frame(start.Add(3*time.Minute), luxproto.LogDataSection1{Grid_Today: 7}))
+	if day.Imported != 2 {
+		t.Errorf("imported %v", day.Imported)
+	}
+
+	// Just after midnight the counters start over
+	day, _, _ = tracker.Update(luxtest.Frame(time.Date(2024, 6, 4, 0, 5, 0, 0, time.UTC), luxproto.LogDataSection1{Grid_Today: 0.5}))
+	if day.Date != "2024-06-04" || day.Imported != 0.5 {
+		t.Errorf("next day %+v", day)
+	}
+}
@@ -0,0 +1,43 @@
+// Package luxtest builds decoded frames of a test inverter for the tests of
+// the packages working on luxproto.LogData.
+package luxtest
+
+import (
+	"fmt"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+// Serial numbers of the test dongle and inverter
+const (
+	DATALOG  = "BA00000001"
+	INVERTER = "0000000001"
+)
+
+// Frame returns a frame of the test inverter at at with the given sections
+// loaded, such as a luxproto.LogDataSection1. The derived values are worked
+// out from them.
+func Frame(at time.Time, sections ...interface{}) luxproto.LogData {
+	log := luxproto.LogData{SerialNumber: DATALOG, InverterSerial: INVERTER, Time: at}
+	for _, section := range sections {
+		switch section := section.(type) {
+		case luxproto.LogDataSection1:
+			log.Section1 = section
+			log.Section1.Loaded = true
+		case luxproto.LogDataSection2:
+			log.Section2 = section
+			log.Section2.Loaded = true
+		case luxproto.LogDataSection3:
+			log.Section3 = section
+			log.Section3.Loaded = true
+		case luxproto.LogDataSection4:
+			log.Section4 = section
+			log.Section4.Loaded = true
+		default:
+			panic(fmt.Sprintf("%T is not a section", section))
+		}
+	}
+	log.Derive()
+	return log
+}
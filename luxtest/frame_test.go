@@ -0,0 +1,23 @@
+package luxtest
+
+import (
+	"testing"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+func TestFrame(t *testing.T) {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	log := Frame(at, luxproto.LogDataSection1{PV1_Power: 1000, Charge_Power: 400}, luxproto.LogDataSection3{Battery_Capacity: 100})
+
+	if log.SerialNumber != DATALOG || log.InverterSerial != INVERTER || !log.Time.Equal(at) {
+		t.Errorf("frame of %s/%s at %v", log.SerialNumber, log.InverterSerial, log.Time)
+	}
+	if !log.Section1.Loaded || log.Section2.Loaded || !log.Section3.Loaded || log.Section4.Loaded {
+		t.Errorf("loaded %v %v %v %v", log.Section1.Loaded, log.Section2.Loaded, log.Section3.Loaded, log.Section4.Loaded)
+	}
+	if !log.Derived.Loaded || log.Derived.Battery_Power != 400 {
+		t.Errorf("derived %+v", log.Derived)
+	}
+}
@@ -0,0 +1,55 @@
+// Package luxtime parses the times of day and days of the week used in the
+// config by the schedules, tariffs, export limits and quiet hours.
+package luxtime
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Minutes parses a time of day like "22:30" into minutes after midnight.
+func Minutes(text string) (int, error) {
+	parsed, err := time.Parse("15:04", text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", text)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// Days parses "weekday", "weekend" and days like "Mon" into the days of the
+// week they cover, indexed by time.Weekday. No names cover all days.
+func Days(names []string) ([7]bool, error) {
+	days := [7]bool{}
+	if len(names) == 0 {
+		return [7]bool{true, true, true, true, true, true, true}, nil
+	}
+	for _, day := range names {
+		switch name := strings.ToLower(day); name {
+		case "weekday":
+			for weekday := time.Monday; weekday <= time.Friday; weekday++ {
+				days[weekday] = true
+			}
+		case "weekend":
+			days[time.Saturday] = true
+			days[time.Sunday] = true
+		default:
+			weekday, ok := dayNames[name]
+			if !ok {
+				return days, fmt.Errorf("unknown day %q", day)
+			}
+			days[weekday] = true
+		}
+	}
+	return days, nil
+}
@@ -0,0 +1,35 @@
+package luxtime
+
+import "testing"
+
+func TestMinutes(t *testing.T) {
+	if minutes, err := Minutes("22:30"); err != nil || minutes != 22*60+30 {
+		t.Errorf("22:30 is %d minutes, %v", minutes, err)
+	}
+	for _, text := range []string{"", "22h", "24:00", "7:5"} {
+		if _, err := Minutes(text); err == nil {
+			t.Errorf("%q accepted", text)
+		}
+	}
+}
+
+func TestDays(t *testing.T) {
+	tests := []struct {
+		names    []string
+		expected [7]bool
+	}{
+		{nil, [7]bool{true, true, true, true, true, true, true}},
+		{[]string{"weekday"}, [7]bool{false, true, true, true, true, true, false}},
+		{[]string{"Weekend"}, [7]bool{true, false, false, false, false, false, true}},
+		{[]string{"Mon", "wed", "SAT"}, [7]bool{false, true, false, true, false, false, true}},
+	}
+	for _, test := range tests {
+		if days, err := Days(test.names); err != nil || days != test.expected {
+			t.Errorf("%v cover %v, %v", test.names, days, err)
+		}
+	}
+
+	if _, err := Days([]string{"Mon", "Funday"}); err == nil {
+		t.Error("unknown day accepted")
+	}
+}
@@ -0,0 +1,181 @@
+// Package modbus speaks plain Modbus, without the envelope of the Wi-Fi
+// dongle. It has an RTU client and slave for the RS485 port of the inverter
+// and a Modbus TCP server for other tools.
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Function codes
+const (
+	FUNCTION_READ_HOLDING  = 0x03
+	FUNCTION_READ_INPUT    = 0x04
+	FUNCTION_WRITE_SINGLE  = 0x06
+	FUNCTION_WRITE_MULTI   = 0x10
+	FUNCTION_EXCEPTION_BIT = 0x80
+)
+
+// Largest register counts a single request may carry
+const (
+	MAX_READ_COUNT  = 125
+	MAX_WRITE_COUNT = 123
+)
+
+// Exception is the error code of a Modbus exception response.
+type Exception uint8
+
+const (
+	ILLEGAL_FUNCTION         Exception = 0x01
+	ILLEGAL_DATA_ADDRESS     Exception = 0x02
+	ILLEGAL_DATA_VALUE       Exception = 0x03
+	SERVER_DEVICE_FAILURE    Exception = 0x04
+	GATEWAY_PATH_UNAVAILABLE Exception = 0x0A
+	GATEWAY_TARGET_FAILED    Exception = 0x0B
+)
+
+func (exception Exception) Error() string {
+	return fmt.Sprintf("modbus exception %02X", uint8(exception))
+}
+
+// Handler answers the register requests a slave receives.
+type Handler interface {
+	// ReadRegisters returns count registers of the holding or input
+	// registers, selected by function.
+	ReadRegisters(unit uint8, function uint8, register uint16, count uint16) ([]uint16, error)
+	// WriteRegisters writes consecutive holding registers.
+	WriteRegisters(unit uint8, register uint16, values []uint16) error
+}
+
+// handle answers the request pdu with the response pdu. Errors of handler
+// are sent as exceptions, SERVER_DEVICE_FAILURE unless they are one.
+func handle(handler Handler, unit uint8, pdu []byte) []byte {
+	values, err := dispatch(handler, unit, pdu)
+	if err != nil {
+		exception, ok := err.(Exception)
+		if !ok {
+			exception = SERVER_DEVICE_FAILURE
+		}
+		return []byte{pdu[0] | FUNCTION_EXCEPTION_BIT, uint8(exception)}
+	}
+	return values
+}
+
+func dispatch(handler Handler, unit uint8, pdu []byte) ([]byte, error) {
+	switch pdu[0] {
+	case FUNCTION_READ_HOLDING, FUNCTION_READ_INPUT:
+		if len(pdu) != 5 {
+			return nil, ILLEGAL_DATA_VALUE
+		}
+		register := binary.BigEndian.Uint16(pdu[1:3])
+		count := binary.BigEndian.Uint16(pdu[3:5])
+		if count == 0 || count > MAX_READ_COUNT {
+			return nil, ILLEGAL_DATA_VALUE
+		}
+		values, err := handler.ReadRegisters(unit, pdu[0], register, count)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != int(count) {
+			return nil, SERVER_DEVICE_FAILURE
+		}
+		response := []byte{pdu[0], uint8(2 * count)}
+		return append(response, encodeValues(values)...), nil
+
+	case FUNCTION_WRITE_SINGLE:
+		if len(pdu) != 5 {
+			return nil, ILLEGAL_DATA_VALUE
+		}
+		register := binary.BigEndian.Uint16(pdu[1:3])
+		err := handler.WriteRegisters(unit, register, decodeValues(pdu[3:5]))
+		if err != nil {
+			return nil, err
+		}
+		return pdu, nil
+
+	case FUNCTION_WRITE_MULTI:
+		if len(pdu) < 6 {
+			return nil, ILLEGAL_DATA_VALUE
+		}
+		register := binary.BigEndian.Uint16(pdu[1:3])
+		count := binary.BigEndian.Uint16(pdu[3:5])
+		if count == 0 || count > MAX_WRITE_COUNT || int(pdu[5]) != 2*int(count) || len(pdu) != 6+2*int(count) {
+			return nil, ILLEGAL_DATA_VALUE
+		}
+		err := handler.WriteRegisters(unit, register, decodeValues(pdu[6:]))
+		if err != nil {
+			return nil, err
+		}
+		return pdu[:5], nil
+	}
+
+	return nil, ILLEGAL_FUNCTION
+}
+
+// readRequest encodes the pdu of a register read.
+func readRequest(function uint8, register uint16, count uint16) []byte {
+	pdu := []byte{function, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(pdu[1:3], register)
+	binary.BigEndian.PutUint16(pdu[3:5], count)
+	return pdu
+}
+
+// writeRequest encodes the pdu writing values, as a single write when there
+// is only one.
+func writeRequest(register uint16, values []uint16) []byte {
+	if len(values) == 1 {
+		pdu := []byte{FUNCTION_WRITE_SINGLE, 0, 0}
+		binary.BigEndian.PutUint16(pdu[1:3], register)
+		return append(pdu, encodeValues(values)...)
+	}
+
+	pdu := []byte{FUNCTION_WRITE_MULTI, 0, 0, 0, 0, uint8(2 * len(values))}
+	binary.BigEndian.PutUint16(pdu[1:3], register)
+	binary.BigEndian.PutUint16(pdu[3:5], uint16(len(values)))
+	return append(pdu, encodeValues(values)...)
+}
+
+// checkResponse returns the exception of an exception response and makes sure
+// response answers request.
+func checkResponse(request []byte, response []byte) error {
+	if len(response) < 2 {
+		return errors.New("modbus response too short")
+	}
+	if response[0] == request[0]|FUNCTION_EXCEPTION_BIT {
+		return Exception(response[1])
+	}
+	if response[0] != request[0] {
+		return errors.New("modbus response to another function")
+	}
+	return nil
+}
+
+// readResponse returns the values of a read response.
+func readResponse(request []byte, response []byte) ([]uint16, error) {
+	if err := checkResponse(request, response); err != nil {
+		return nil, err
+	}
+	count := binary.BigEndian.Uint16(request[3:5])
+	if int(response[1]) != 2*int(count) || len(response) != 2+2*int(count) {
+		return nil, errors.New("modbus response with wrong register count")
+	}
+	return decodeValues(response[2:]), nil
+}
+
+func encodeValues(values []uint16) []byte {
+	data := make([]byte, 2*len(values))
+	for i, value := range values {
+		binary.BigEndian.PutUint16(data[2*i:], value)
+	}
+	return data
+}
+
+func decodeValues(data []byte) []uint16 {
+	values := make([]uint16, len(data)/2)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+	return values
+}
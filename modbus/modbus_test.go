@@ -0,0 +1,105 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// bank is a Handler with one register bank for both functions.
+type bank []uint16
+
+func (bank bank) ReadRegisters(unit uint8, function uint8, register uint16, count uint16) ([]uint16, error) {
+	if int(register)+int(count) > len(bank) {
+		return nil, ILLEGAL_DATA_ADDRESS
+	}
+	return bank[register : register+count], nil
+}
+
+func (bank bank) WriteRegisters(unit uint8, register uint16, values []uint16) error {
+	if register == 0 {
+		return errors.New("read only")
+	}
+	copy(bank[register:], values)
+	return nil
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  []byte
+		response []byte
+	}{
+		{"ReadHolding", []byte{3, 0, 1, 0, 2}, []byte{3, 4, 0, 2, 0, 3}},
+		{"ReadInput", []byte{4, 0, 0, 0, 1}, []byte{4, 2, 0, 1}},
+		{"WriteSingle", []byte{6, 0, 2, 0x12, 0x34}, []byte{6, 0, 2, 0x12, 0x34}},
+		{"WriteMulti", []byte{16, 0, 1, 0, 2, 4, 0, 5, 0, 6}, []byte{16, 0, 1, 0, 2}},
+		{"UnknownFunction", []byte{1, 0, 0, 0, 1}, []byte{0x81, 1}},
+		{"ZeroCount", []byte{3, 0, 0, 0, 0}, []byte{0x83, 3}},
+		{"TooMany", []byte{3, 0, 0, 0, 126}, []byte{0x83, 3}},
+		{"OutOfRange", []byte{3, 0, 3, 0, 2}, []byte{0x83, 2}},
+		{"ShortRequest", []byte{4, 0, 0}, []byte{0x84, 3}},
+		{"ByteCountMismatch", []byte{16, 0, 1, 0, 2, 2, 0, 5}, []byte{0x90, 3}},
+		{"HandlerError", []byte{6, 0, 0, 0, 1}, []byte{0x86, 4}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registers := bank{1, 2, 3, 4}
+			response := handle(registers, 1, test.request)
+			if !bytes.Equal(response, test.response) {
+				t.Errorf("got % X, expected % X", response, test.response)
+			}
+		})
+	}
+}
+
+func TestReadResponse(t *testing.T) {
+	request := readRequest(FUNCTION_READ_INPUT, 10, 2)
+	values, err := readResponse(request, []byte{4, 4, 0x12, 0x34, 0, 1})
+	if err != nil || values[0] != 0x1234 || values[1] != 1 {
+		t.Errorf("read %v %v", values, err)
+	}
+	if _, err := readResponse(request, []byte{0x84, 2}); err != ILLEGAL_DATA_ADDRESS {
+		t.Errorf("expected exception, got %v", err)
+	}
+	if _, err := readResponse(request, []byte{4, 2, 0, 1}); err == nil {
+		t.Error("short response accepted")
+	}
+}
+
+func TestServeTCPConn(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		client, server := net.Pipe()
+		served := make(chan struct{})
+		go func() {
+			serveTCPConn(context.Background(), server, bank{1, 2, 3, 4})
+			close(served)
+		}()
+
+		if _, err := client.Write(encodeTCP(uint16(i), 1, []byte{3, 0, 1, 0, 1})); err != nil {
+			t.Fatal(err)
+		}
+		transaction, unit, response, err := readTCP(client)
+		if err != nil || transaction != uint16(i) || unit != 1 || !bytes.Equal(response, []byte{3, 2, 0, 2}) {
+			t.Fatalf("transaction %d of unit %d answered % X, %v", transaction, unit, response, err)
+		}
+		client.Close()
+		<-served
+	}
+
+	// The goroutines watching the context end with the connections
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines left behind", after-before)
+	}
+}
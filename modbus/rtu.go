@@ -0,0 +1,214 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"LuxLogger/luxproto"
+)
+
+const (
+	DEFAULT_TIMEOUT = time.Second
+)
+
+var (
+	errCRC   = errors.New("modbus CRC mismatch")
+	errFrame = errors.New("modbus frame with unknown function")
+)
+
+// deadliner is implemented by serial ports and pseudo-terminals opened for
+// non-blocking I/O.
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// RTUClient is the master on an RS485 bus.
+type RTUClient struct {
+	// Timeout is how long a request waits for its response. It needs a port
+	// that supports read deadlines.
+	Timeout time.Duration
+
+	port io.ReadWriter
+	lock sync.Mutex
+}
+
+func NewRTUClient(port io.ReadWriter) *RTUClient {
+	return &RTUClient{Timeout: DEFAULT_TIMEOUT, port: port}
+}
+
+// ReadInput reads count input registers of unit starting at register.
+func (client *RTUClient) ReadInput(unit uint8, register uint16, count uint16) ([]uint16, error) {
+	request := readRequest(FUNCTION_READ_INPUT, register, count)
+	response, err := client.Request(unit, request)
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(request, response)
+}
+
+// ReadHold reads count holding registers of unit starting at register.
+func (client *RTUClient) ReadHold(unit uint8, register uint16, count uint16) ([]uint16, error) {
+	request := readRequest(FUNCTION_READ_HOLDING, register, count)
+	response, err := client.Request(unit, request)
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(request, response)
+}
+
+// Write writes consecutive holding registers of unit starting at register.
+func (client *RTUClient) Write(unit uint8, register uint16, values []uint16) error {
+	request := writeRequest(register, values)
+	response, err := client.Request(unit, request)
+	if err != nil {
+		return err
+	}
+	return checkResponse(request, response)
+}
+
+// Request sends the request pdu to unit and returns the response pdu.
+func (client *RTUClient) Request(unit uint8, pdu []byte) ([]byte, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if _, err := client.port.Write(encodeRTU(unit, pdu)); err != nil {
+		return nil, err
+	}
+
+	if port, ok := client.port.(deadliner); ok && client.Timeout > 0 {
+		port.SetReadDeadline(time.Now().Add(client.Timeout))
+		defer port.SetReadDeadline(time.Time{})
+	}
+
+	for {
+		responseUnit, response, err := readRTU(client.port, responseLength)
+		if err != nil {
+			client.discard()
+			return nil, err
+		}
+		// Other masters may share the bus
+		if responseUnit == unit {
+			return response, nil
+		}
+	}
+}
+
+// discard drops what is left of a broken frame so the next request starts
+// on a clean bus.
+func (client *RTUClient) discard() {
+	port, ok := client.port.(deadliner)
+	if !ok {
+		return
+	}
+	buffer := make([]byte, 256)
+	for {
+		port.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		if _, err := client.port.Read(buffer); err != nil {
+			return
+		}
+	}
+}
+
+// ServeRTU answers the requests to unit that arrive on port until it fails.
+// Requests to other units are ignored, broadcasts to unit 0 are handled
+// without an answer.
+func ServeRTU(port io.ReadWriter, unit uint8, handler Handler) error {
+	for {
+		requestUnit, request, err := readRTU(port, requestLength)
+		if err == errCRC || err == errFrame {
+			// Garbage on the bus, the next request starts after a gap
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if requestUnit != unit && requestUnit != 0 {
+			continue
+		}
+
+		response := handle(handler, unit, request)
+		if requestUnit == 0 {
+			continue
+		}
+		if _, err := port.Write(encodeRTU(unit, response)); err != nil {
+			return err
+		}
+	}
+}
+
+// encodeRTU adds the unit and CRC to pdu.
+func encodeRTU(unit uint8, pdu []byte) []byte {
+	frame := append([]byte{unit}, pdu...)
+	return binary.LittleEndian.AppendUint16(frame, luxproto.CRC16(frame))
+}
+
+// readRTU reads one frame from port. RTU frames carry no length, so length
+// works out how many bytes are left from the ones read so far, or returns
+// 0 when they are complete.
+func readRTU(port io.Reader, length func(frame []byte) (int, error)) (uint8, []byte, error) {
+	frame := make([]byte, 2, 256)
+	if _, err := io.ReadFull(port, frame); err != nil {
+		return 0, nil, err
+	}
+
+	for {
+		missing, err := length(frame)
+		if err != nil {
+			return 0, nil, err
+		}
+		if missing == 0 {
+			break
+		}
+		if len(frame)+missing > cap(frame) {
+			return 0, nil, errors.New("modbus frame too long")
+		}
+		start := len(frame)
+		frame = frame[:start+missing]
+		if _, err := io.ReadFull(port, frame[start:]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// The CRC follows the pdu
+	frame = frame[:len(frame)+2]
+	if _, err := io.ReadFull(port, frame[len(frame)-2:]); err != nil {
+		return 0, nil, err
+	}
+	if luxproto.CRC16(frame[:len(frame)-2]) != binary.LittleEndian.Uint16(frame[len(frame)-2:]) {
+		return 0, nil, errCRC
+	}
+	return frame[0], frame[1 : len(frame)-2], nil
+}
+
+// requestLength is the length function of requests to a slave.
+func requestLength(frame []byte) (int, error) {
+	switch frame[1] {
+	case FUNCTION_READ_HOLDING, FUNCTION_READ_INPUT, FUNCTION_WRITE_SINGLE:
+		return 6 - len(frame), nil
+	case FUNCTION_WRITE_MULTI:
+		if len(frame) < 7 {
+			return 7 - len(frame), nil
+		}
+		return 7 + int(frame[6]) - len(frame), nil
+	}
+	return 0, errFrame
+}
+
+// responseLength is the length function of responses from a slave.
+func responseLength(frame []byte) (int, error) {
+	switch {
+	case frame[1]&FUNCTION_EXCEPTION_BIT != 0:
+		return 3 - len(frame), nil
+	case frame[1] == FUNCTION_READ_HOLDING || frame[1] == FUNCTION_READ_INPUT:
+		if len(frame) < 3 {
+			return 1, nil
+		}
+		return 3 + int(frame[2]) - len(frame), nil
+	case frame[1] == FUNCTION_WRITE_SINGLE || frame[1] == FUNCTION_WRITE_MULTI:
+		return 6 - len(frame), nil
+	}
+	return 0, errFrame
+}
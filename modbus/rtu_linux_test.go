@@ -0,0 +1,115 @@
+package modbus_test
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"LuxLogger/luxproto"
+	"LuxLogger/luxsim"
+	"LuxLogger/modbus"
+)
+
+// openPTY returns the master side of a new pseudo-terminal and the device
+// name of its slave side.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Skip("no pseudo-terminals:", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	unlock := int32(0)
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		t.Fatal(err)
+	}
+	number := uint32(0)
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		t.Fatal(err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", number)
+}
+
+// ioctl is the one of serial_linux.go, out of reach of the external test
+// package the simulator needs to avoid an import cycle.
+func ioctl(file *os.File, request uintptr, argument unsafe.Pointer) error {
+	raw, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(argument))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func TestRTUAgainstSimulatedSlave(t *testing.T) {
+	master, device := openPTY(t)
+	port, err := modbus.OpenSerial(device, modbus.SerialConfig{Baud: 19200, Parity: modbus.PARITY_EVEN, StopBits: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	sim := luxsim.NewSimulator("BA00000001", "0000000001")
+	go modbus.ServeRTU(master, 1, sim)
+
+	client := modbus.NewRTUClient(port)
+	client.Timeout = 200 * time.Millisecond
+
+	// The inverter data ends up in the same structures as from the dongle
+	for _, register := range luxproto.InputBlocks(false) {
+		values, err := client.ReadInput(1, register, luxproto.SECTION_REGISTERS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log := luxproto.LogData{}
+		if !log.DecodeRegisters(register, values) {
+			t.Fatalf("block at %d did not decode", register)
+		}
+		if register == luxproto.INPUT_SECTION1 && (log.Section1.Battery_Voltage < 40 || log.Section1.Frequency_Grid < 45) {
+			t.Errorf("implausible values %+v", log.Section1)
+		}
+	}
+
+	if err := client.Write(1, 21, []uint16{0x1234}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Write(1, 68, []uint16{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	values, err := client.ReadHold(1, 68, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.Holding(21) != 0x1234 || values[2] != 3 {
+		t.Errorf("holding registers %04X %v", sim.Holding(21), values)
+	}
+
+	if _, err := client.ReadInput(1, 0, 200); err != modbus.ILLEGAL_DATA_VALUE {
+		t.Errorf("expected illegal data value, got %v", err)
+	}
+	if _, err := client.ReadHold(1, 250, 10); err != modbus.ILLEGAL_DATA_ADDRESS {
+		t.Errorf("expected illegal data address, got %v", err)
+	}
+
+	// Nothing answers for another unit, the bus stays usable afterwards
+	if _, err := client.ReadInput(2, 0, 1); err == nil {
+		t.Error("unit 2 answered")
+	}
+	if _, err := client.ReadInput(1, 0, 1); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,14 @@
+package modbus
+
+const (
+	PARITY_NONE = "N"
+	PARITY_EVEN = "E"
+	PARITY_ODD  = "O"
+)
+
+// SerialConfig holds the line settings of a serial port.
+type SerialConfig struct {
+	Baud     int
+	Parity   string // PARITY_NONE, PARITY_EVEN or PARITY_ODD
+	StopBits int
+}
@@ -0,0 +1,81 @@
+//go:build linux && (386 || amd64 || arm || arm64 || riscv64)
+
+package modbus
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// TCSETS of the generic Linux ABI, the syscall package lacks it
+const tcsets = 0x5402
+
+var baudRates = map[int]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+}
+
+// OpenSerial opens a serial device like /dev/ttyUSB0 in raw mode with 8 data
+// bits. The returned file supports read deadlines.
+func OpenSerial(device string, config SerialConfig) (*os.File, error) {
+	speed, ok := baudRates[config.Baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", config.Baud)
+	}
+
+	cflag := speed | syscall.CS8 | syscall.CREAD | syscall.CLOCAL
+	switch config.Parity {
+	case PARITY_NONE, "":
+	case PARITY_EVEN:
+		cflag |= syscall.PARENB
+	case PARITY_ODD:
+		cflag |= syscall.PARENB | syscall.PARODD
+	default:
+		return nil, fmt.Errorf("unknown parity %q", config.Parity)
+	}
+	if config.StopBits == 2 {
+		cflag |= syscall.CSTOPB
+	}
+
+	file, err := os.OpenFile(device, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	termios := syscall.Termios{Cflag: cflag, Ispeed: speed, Ospeed: speed}
+	// Return from read as soon as a byte arrives
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if err := ioctl(file, tcsets, unsafe.Pointer(&termios)); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("configuring %s: %w", device, err)
+	}
+	return file, nil
+}
+
+func ioctl(file *os.File, request uintptr, argument unsafe.Pointer) error {
+	raw, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(argument))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
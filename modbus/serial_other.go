@@ -0,0 +1,13 @@
+//go:build !(linux && (386 || amd64 || arm || arm64 || riscv64))
+
+package modbus
+
+import (
+	"errors"
+	"os"
+)
+
+// OpenSerial is only implemented on Linux.
+func OpenSerial(device string, config SerialConfig) (*os.File, error) {
+	return nil, errors.New("serial ports are not supported on this platform")
+}
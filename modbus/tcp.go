@@ -0,0 +1,102 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+// MBAP_LENGTH is the length of the header in front of every Modbus TCP pdu.
+const MBAP_LENGTH = 7
+
+// ListenAndServeTCP answers Modbus TCP requests on address until ctx is done.
+func ListenAndServeTCP(ctx context.Context, address string, handler Handler) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return ServeTCP(ctx, listener, handler)
+}
+
+// ServeTCP answers Modbus TCP requests from the connections accepted on
+// listener. The unit identifier of each request is passed to handler.
+func ServeTCP(ctx context.Context, listener net.Listener, handler Handler) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		go serveTCPConn(ctx, conn, handler)
+	}
+}
+
+// serveTCPConn answers the requests on conn until the client hangs up or ctx
+// is done.
+func serveTCPConn(ctx context.Context, conn net.Conn, handler Handler) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		transaction, unit, request, err := readTCP(conn)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				println("Modbus TCP client", conn.RemoteAddr().String(), "failed:", err.Error())
+			}
+			return
+		}
+
+		if _, err := conn.Write(encodeTCP(transaction, unit, handle(handler, unit, request))); err != nil {
+			return
+		}
+	}
+}
+
+// readTCP reads one request and returns its transaction identifier, unit
+// and pdu.
+func readTCP(conn io.Reader) (uint16, uint8, []byte, error) {
+	header := make([]byte, MBAP_LENGTH)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, 0, nil, err
+	}
+
+	if binary.BigEndian.Uint16(header[2:4]) != 0 {
+		return 0, 0, nil, errors.New("not a Modbus protocol identifier")
+	}
+	// The length counts the unit and the pdu
+	length := int(binary.BigEndian.Uint16(header[4:6]))
+	if length < 2 || length > 254 {
+		return 0, 0, nil, errors.New("invalid Modbus TCP length")
+	}
+
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(conn, pdu); err != nil {
+		return 0, 0, nil, err
+	}
+	return binary.BigEndian.Uint16(header[0:2]), header[6], pdu, nil
+}
+
+func encodeTCP(transaction uint16, unit uint8, pdu []byte) []byte {
+	frame := make([]byte, MBAP_LENGTH, MBAP_LENGTH+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:2], transaction)
+	binary.BigEndian.PutUint16(frame[4:6], uint16(len(pdu)+1))
+	frame[6] = unit
+	return append(frame, pdu...)
+}